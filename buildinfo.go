@@ -0,0 +1,64 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version is the proxy's version, when set at build time (ie, with -ldflags
+// "-X main.version=v1.2.3"). It defaults to the module version of the build.
+var version string
+
+// buildInfo is the proxy's build information (see -version).
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go"`
+}
+
+// readBuildInfo returns the proxy's build information, from the build's
+// embedded module and vcs information.
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		if info.Version == "" {
+			info.Version = "unknown"
+		}
+		return info
+	}
+	if info.Version == "" {
+		// built from a checkout, the module version is (devel)
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// String satisfies the fmt.Stringer interface, formatting the build
+// information as a single line (ie, "v1.2.3 (commit 4f1c2a9e6b3d, modified,
+// go1.22.5)").
+func (b buildInfo) String() string {
+	var details []string
+	if b.Commit != "" {
+		commit := b.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		details = append(details, "commit "+commit)
+	}
+	if b.Modified {
+		details = append(details, "modified")
+	}
+	details = append(details, b.GoVersion)
+	return b.Version + " (" + strings.Join(details, ", ") + ")"
+}
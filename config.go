@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyConfig applies the values of the yaml config file to the flags. The
+// config file's keys are the flag names, and the values are either a single
+// value, or a list of values for repeatable or comma-separated flags. Flags
+// set on the command line are not changed.
+//
+// Besides yaml's # comments, the config file can use // and /* */ comments,
+// and trailing commas in lists and objects, so that JSON5-style configs (ie,
+// {"l": "0.0.0.0:9223", // shared}) can also be used. Errors point at the line
+// of the offending key.
+func applyConfig(fs *flag.FlagSet, name string) error {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(stripComments(buf), &doc); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+	if len(doc.Content) == 0 {
+		// empty config file
+		return nil
+	}
+	config := doc.Content[0]
+	if config.Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid config file %s: line %d: expected a mapping of option names to values", name, config.Line)
+	}
+	set := visited(fs)
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(config.Content); i += 2 {
+		k, v := config.Content[i], config.Content[i+1]
+		key := k.Value
+		errorf := func(format string, a ...interface{}) error {
+			return fmt.Errorf("invalid config file %s: line %d: option %q: %s", name, k.Line, key, fmt.Sprintf(format, a...))
+		}
+		f := fs.Lookup(key)
+		switch {
+		case k.Kind != yaml.ScalarNode:
+			return fmt.Errorf("invalid config file %s: line %d: option names must be strings", name, k.Line)
+		case f == nil || key == "config":
+			return errorf("unknown option")
+		case seen[key]:
+			return errorf("option already set")
+		}
+		seen[key] = true
+		if set[key] {
+			continue
+		}
+		values, err := configValues(v)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		if _, ok := f.Value.(*listFlag); !ok {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, x := range values {
+			if err := fs.Set(key, x); err != nil {
+				return errorf("invalid value %q: %v", x, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues returns the string values of a config value.
+func configValues(n *yaml.Node) ([]string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return []string{""}, nil
+		}
+		return []string{n.Value}, nil
+	case yaml.SequenceNode:
+		var values []string
+		for _, z := range n.Content {
+			if z.Kind != yaml.ScalarNode {
+				return nil, errNestedValue
+			}
+			values = append(values, z.Value)
+		}
+		return values, nil
+	case yaml.AliasNode:
+		return configValues(n.Alias)
+	}
+	return nil, errNestedValue
+}
+
+// errNestedValue is the error of nested config values.
+var errNestedValue = errors.New("nested values are not supported")
+
+// stripComments removes the // line comments and /* */ block comments of the
+// config file, outside of quoted strings. Comments must start the line or
+// follow a space or a delimiter, so that unquoted yaml values (ie,
+// http://localhost:9222) are kept. The newlines of comments are kept so that
+// the line numbers of errors are unchanged.
+func stripComments(buf []byte) []byte {
+	out := make([]byte, 0, len(buf))
+	var quote byte
+	for i := 0; i < len(buf); i++ {
+		c := buf[i]
+		boundary := i == 0 || isCommentBoundary(buf[i-1])
+		switch {
+		case quote != 0:
+			switch {
+			case c == '\\' && quote == '"' && i+1 < len(buf):
+				out = append(out, c)
+				i++
+				c = buf[i]
+			case c == quote, c == '\n':
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if boundary || buf[i-1] == ':' {
+				quote = c
+			}
+		case c == '#' && boundary:
+			// yaml comments are kept, as is
+			j := bytes.IndexByte(buf[i:], '\n')
+			if j < 0 {
+				j = len(buf) - i
+			}
+			out = append(out, buf[i:i+j]...)
+			i += j - 1
+			continue
+		case c == '/' && boundary && i+1 < len(buf) && (buf[i+1] == '/' || buf[i+1] == '*'):
+			end := "\n"
+			if buf[i+1] == '*' {
+				end = "*/"
+			}
+			j := bytes.Index(buf[i+2:], []byte(end))
+			switch {
+			case j < 0:
+				j = len(buf)
+			case end == "\n":
+				// keep the newline ending line comments
+				j += i + 2
+			default:
+				j += i + 2 + len(end)
+			}
+			out = append(out, bytes.Repeat([]byte("\n"), bytes.Count(buf[i:j], []byte("\n")))...)
+			i = j - 1
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// isCommentBoundary returns true when a comment or quoted string can start
+// after the character.
+func isCommentBoundary(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', ',', '{', '}', '[', ']':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		in, exp string
+	}{
+		{"l: localhost:9223 // the listen", "l: localhost:9223 "},
+		{"l: localhost:9223 // the listen\nr: localhost:9222\n", "l: localhost:9223 \nr: localhost:9222\n"},
+		{"// a comment\nl: localhost:9223", "\nl: localhost:9223"},
+		{"//", ""},
+		{"l: localhost:9223 /* the listen */\n", "l: localhost:9223 \n"},
+		{"/* a\nblock\ncomment */l: localhost:9223", "\n\nl: localhost:9223"},
+		{"l: localhost:9223 /* unterminated\n", "l: localhost:9223 \n"},
+		{"r: http://localhost:9222\n", "r: http://localhost:9222\n"},
+		{`log-prefix: "// {session} "` + "\n", `log-prefix: "// {session} "` + "\n"},
+		{`log-prefix: '/* {session} */' // the prefix`, `log-prefix: '/* {session} */' `},
+		{`log-prefix: "\" // "`, `log-prefix: "\" // "`},
+		{"# a yaml comment // kept\nl: localhost:9223", "# a yaml comment // kept\nl: localhost:9223"},
+		{`{"l": "localhost:9223", // the listen` + "\n" + `"r": "localhost:9222"}`, `{"l": "localhost:9223", ` + "\n" + `"r": "localhost:9222"}`},
+	}
+	for i, test := range tests {
+		if s := string(stripComments([]byte(test.in))); s != test.exp {
+			t.Errorf("test %d: expected %q, got: %q", i, test.exp, s)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables for the flags.
+const envPrefix = "CDP_PROXY_"
+
+// envNames are the environment variable names for the short flags.
+var envNames = map[string]string{
+	"l": "LISTEN",
+	"r": "REMOTE",
+	"n": "NOLOG",
+}
+
+// envLineFlags are the repeatable flags whose values can contain commas (the
+// fallbacks of -r, a User-Agent, or a header value), split on newlines instead
+// of commas in their environment variables.
+var envLineFlags = map[string]bool{
+	"r":                true,
+	"version-override": true,
+	"remote-header":    true,
+}
+
+// sensitiveFlags are the flags whose values are not logged.
+var sensitiveFlags = map[string]bool{
+	"auth": true,
+}
+
+// envName returns the environment variable name for the flag (ie,
+// CDP_PROXY_LOG_MAX_SIZE for -log-max-size).
+func envName(name string) string {
+	if s, ok := envNames[name]; ok {
+		return envPrefix + s
+	}
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets the flags not set on the command line from their environment
+// variables, returning the environment variable names of the flags that were
+// set. Repeatable flags are split on commas, or on newlines for envLineFlags.
+func applyEnv(fs *flag.FlagSet) (map[string]string, error) {
+	set := visited(fs)
+	env := make(map[string]string)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if set[f.Name] || !ok || err != nil {
+			return
+		}
+		values := []string{v}
+		switch _, list := f.Value.(*listFlag); {
+		case list && envLineFlags[f.Name]:
+			values = splitLines(v)
+		case list:
+			values = splitList(v)
+		}
+		for _, s := range values {
+			if err = fs.Set(f.Name, s); err != nil {
+				err = fmt.Errorf("invalid $%s: %w", name, err)
+				return
+			}
+		}
+		env[f.Name] = name
+	})
+	return env, err
+}
+
+// splitLines splits the newline-separated values of s, trimming spaces and
+// removing empty values.
+func splitLines(s string) []string {
+	var v []string
+	for _, x := range strings.Split(s, "\n") {
+		if x = strings.TrimSpace(x); x != "" {
+			v = append(v, x)
+		}
+	}
+	return v
+}
+
+// logFlags logs the flags that are not at their default value, and where
+// their values came from.
+func logFlags(fs *flag.FlagSet, cmd map[string]bool, env, profile map[string]string) {
+	fs.Visit(func(f *flag.Flag) {
+		source := "config"
+		if cmd[f.Name] {
+			source = "flag"
+		} else if name, ok := env[f.Name]; ok {
+			source = "$" + name
+		} else if name, ok := profile[f.Name]; ok {
+			source = "profile " + name
+		}
+		value := f.Value.String()
+		if sensitiveFlags[f.Name] {
+			value = "***"
+		}
+		log.Printf("using -%s=%s (from %s)", f.Name, value, source)
+	})
+}
+
+// visited returns the names of the flags that have been set.
+func visited(fs *flag.FlagSet) map[string]bool {
+	m := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		m[f.Name] = true
+	})
+	return m
+}
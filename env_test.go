@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name, env string
+		exp       []string
+	}{
+		{"r", "localhost:9222,localhost:9232", []string{"localhost:9222,localhost:9232"}},
+		{"r", "localhost:9222\na=localhost:9232,localhost:9242\n", []string{"localhost:9222", "a=localhost:9232,localhost:9242"}},
+		{"version-override", "User-Agent=Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko)", []string{"User-Agent=Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko)"}},
+		{"remote-header", "Accept: text/html, application/json\nX-Token: a", []string{"Accept: text/html, application/json", "X-Token: a"}},
+		{"sample", "Network.*=0.1, Page.*=0.5", []string{"Network.*=0.1", "Page.*=0.5"}},
+	}
+	for i, test := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var values listFlag
+		fs.Var(&values, test.name, "")
+		t.Setenv(envName(test.name), test.env)
+		if _, err := applyEnv(fs); err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		if !reflect.DeepEqual([]string(values), test.exp) {
+			t.Errorf("test %d: expected %q, got: %q", i, test.exp, values)
+		}
+	}
+}
+
+func TestApplyEnvRemote(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var remotes listFlag
+	fs.Var(&remotes, "r", "")
+	t.Setenv("CDP_PROXY_REMOTE", "localhost:9222,localhost:9232")
+	if _, err := applyEnv(fs); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the fallbacks are a single remote, instead of remotes replacing each
+	// other
+	if opts := remoteOptions(remotes); len(opts) != 2 {
+		t.Errorf("expected 2 options, got: %d", len(opts))
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The exit codes of the proxy, so that orchestration (ie, a supervisor
+// restarting the proxy) can tell the failures apart. Invalid flags exit with
+// 2, as with the flag package.
+const (
+	exitFailure      = 1
+	exitAddrInUse    = 3
+	exitPermission   = 4
+	exitAddrNotAvail = 5
+)
+
+// exitStatus returns the message and exit code for the error returned by run,
+// with an actionable message for the common errors listening on an address.
+func exitStatus(err error) (string, int) {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "listen" {
+		return err.Error(), exitFailure
+	}
+	addr := "the address"
+	if opErr.Addr != nil {
+		addr = opErr.Addr.String()
+	}
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return fmt.Sprintf("address %s already in use, is another proxy running on it?", addr), exitAddrInUse
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Sprintf("permission denied listening on %s (ports below 1024 need privileges)", addr), exitPermission
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return fmt.Sprintf("address %s is not available, is it an address of this host?", addr), exitAddrNotAvail
+	}
+	return err.Error(), exitFailure
+}
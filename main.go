@@ -10,178 +10,967 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"net/http/httputil"
+	"net/http/pprof"
+	"net/netip"
 	"net/url"
 	"os"
-	"path"
-	"regexp"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/chromedp/chromedp-proxy/proxy"
 )
 
 func main() {
-	listen := flag.String("l", "localhost:9223", "listen address")
-	remote := flag.String("r", "localhost:9222", "remote address")
+	listen := flag.String("l", proxy.DefaultListen, "listen address (host:port, or unix:/path for a unix socket)")
+	var remotes listFlag
+	flag.Var(&remotes, "r", "remote address (host:port, or url for a tls remote or a remote under a path prefix, comma-separated to fail over to fallbacks), repeat as name=address to serve a remote under /name/ (default \""+proxy.DefaultRemote+"\")")
+	launch := flag.Bool("launch", false, "launch a browser and use it as the remote, shutting it down on exit")
+	chrome := flag.String("chrome", "", "browser binary to launch (default searches for chrome or chromium)")
+	chromeArgs := flag.String("chrome-args", "", "space-separated extra args to launch the browser with (ie, --headless)")
+	wsPath := flag.String("ws-path", proxy.DefaultWSPath, "path prefix of the remote's websocket endpoints")
+	noNormalizeHost := flag.Bool("no-normalize-host", false, "dial the websocket urls reported by the remote's /json/version and /json/new as is, instead of with the -r host")
+	noVersionCheck := flag.Bool("no-version-check", false, "connect sessions without checking the remote's /json/version")
+	minProtocol := flag.String("min-protocol", "", "refuse sessions to a remote whose /json/version reports an older Protocol-Version (ie, 1.3)")
+	remoteHTTP := flag.String("r-http", "", "address of the default remote's http endpoints (/json), when not the -r address")
+	remoteWS := flag.String("r-ws", "", "address to dial the default remote's websockets on, when not the -r address")
+	remoteInsecure := flag.Bool("remote-insecure", false, "skip tls certificate verification of the remote")
+	srv := flag.String("srv", "", "dns srv record listing the backends to balance sessions across, instead of the default remote (ie, _cdp._tcp.example.com)")
+	srvInterval := flag.Duration("srv-interval", proxy.DefaultSRVInterval, "interval to re-resolve the -srv record at")
+	remoteFile := flag.String("remote-file", "", "file to read the default remote's address from, re-read on each request, instead of -r")
+	activePort := flag.String("devtools-active-port", "", "browser's DevToolsActivePort file to read the default remote's port from, re-read on each request, instead of -r")
+	remoteSelect := flag.String("remote-select", "", "comma-separated remote addresses that requests can select with the X-CDP-Remote header, as host:port with the host an address, cidr, or glob of its dot-separated labels (ie, 10.0.0.0/24:9222 or 10.0.0.*:9222)")
+	versionFile := flag.String("version-file", "", "file to write the remote's /json/version output to at startup, and whenever the remote's version changes")
+	var versionOverrides listFlag
+	flag.Var(&versionOverrides, "version-override", `field of the remote's /json/version output to override for clients, as "Field=value" (ie, User-Agent=Mozilla/5.0, an empty value removes the field) (repeatable)`)
+	var remoteHeaders listFlag
+	flag.Var(&remoteHeaders, "remote-header", `header to send with the requests to the remote, as "Key: Value" (repeatable)`)
+	remoteProxy := flag.String("remote-proxy", "", "http proxy url to connect to the remote through (defaults to $HTTPS_PROXY or $HTTP_PROXY)")
 	noLog := flag.Bool("n", false, "disable logging to file")
-	logMask := flag.String("log", "logs/cdp-%s.log", "log file mask")
+	noStdout := flag.Bool("no-stdout", false, "do not mirror session logs to stdout when logging to a file (with -n, discard session logs)")
+	logMask := flag.String("log", proxy.DefaultLogMask, "log file mask")
+	logNameReplace := flag.String("log-name-replace", "", "replace the characters of the devtools id that are not allowed in log file names with this string, instead of removing them")
+	logNameType := flag.Bool("log-name-type", false, "prefix the devtools id in log file names with the target type (ie, page_<id>)")
+	logSingle := flag.String("log-single", "", "log all sessions to a single shared log file instead of the log file mask")
+	logMaxSize := flag.Int64("log-max-size", 0, "rotate log files after the size in MB (0 disables rotation)")
+	logBackups := flag.Int("log-backups", proxy.DefaultLogBackups, "number of rotated log files to keep")
+	logMaxFiles := flag.Int("log-max-files", 0, "remove the oldest session log files when there are more than the number of files (0 for no limit)")
+	logMaxTotal := flag.Int64("log-max-total-bytes", 0, "remove the oldest session log files when their total size exceeds the bytes (0 for no limit)")
+	logGzip := flag.Bool("log-gzip", false, "gzip log files when closed or rotated")
+	logS3 := flag.String("log-s3", "", "upload each log file when closed to the s3 bucket, removing the local file (s3://bucket/prefix, with $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_REGION)")
+	format := proxy.FormatText
+	flag.Var(&format, "format", "log format (text, jsonl)")
+	var stdoutFormat proxy.Format
+	flag.Var(&stdoutFormat, "stdout-format", "stdout log format (text, jsonl, defaults to -format)")
+	runID := flag.String("run-id", "", "tag all log lines with the run id (set to an empty value to generate a random id)")
+	logOnError := flag.Bool("log-on-error", false, "only write the log files of sessions that fail, buffering each session's latest log lines in memory")
+	logOnErrorLines := flag.Int("log-on-error-lines", proxy.DefaultLogOnErrorLines, "maximum number of log lines buffered per session with -log-on-error")
+	logPrefix := flag.String("log-prefix", "", "template of the text log line prefix, with {time}, {session}, {remote}, {run} and {dir} placeholders (empty for the default \"{time} \")")
+	var samples listFlag
+	flag.Var(&samples, "sample", `CDP method glob to only log a random sample of, as "method=rate" (ie, Network.dataReceived=0.01 logs 1 in 100), still forwarding all messages (repeatable)`)
+	headFrames := flag.Int64("head-frames", 0, "only log the first N messages of each direction of a session, still forwarding later messages (0 logs all messages)")
+	timestampFrames := flag.Bool("timestamp-frames", false, "log each message with the elapsed time since the start of its session (ie, +00:00:01.234)")
+	splitByDomain := flag.Bool("split-by-domain", false, "log each session's messages to a file per CDP domain (ie, cdp-<id>-Network.log), and responses to a _responses file")
+	logHashes := flag.Bool("log-hashes", false, "add the sha256 of each jsonl log entry and a chained hash of the session's entries, to verify the log with -verify-log")
+	logMicros := flag.Bool("log-micros", false, "log timestamps with microseconds and each message's session sequence number")
+	logOrdered := flag.Bool("log-ordered", false, "log each session's messages in a consistent total order through a single writer, numbering them with seq= (only affects the log, not forwarding)")
+	logHandshake := flag.Bool("log-handshake", false, "log the headers of the client's and the remote's websocket handshakes")
+	metadataOnly := flag.Bool("metadata-only", false, "log only the direction, CDP method and id, and byte size of each message, never its payload")
+	useSyslog := flag.Bool("syslog", false, "also send logs to syslog")
+	syslogAddr := flag.String("syslog-addr", "", "remote syslog address (ie, udp://host:514, default is the local syslog)")
+	quiet := flag.Bool("quiet", false, "do not log the session connection banner lines")
+	detachOnClose := flag.Bool("detach-on-close", false, "send an Inspector.detached event to clients before closing their sessions on shutdown or when dropped")
+	admin := flag.Bool("admin", false, "serve the active sessions as json on /admin/sessions, pause/resume endpoints, and the evicted sessions on /admin/evictions (protected by -auth, when set)")
+	logStream := flag.Bool("log-stream", false, "stream log lines to websocket viewers on /logs")
+	color := flag.String("color", "auto", "colorize stdout log lines (auto, always, never)")
+	pretty := flag.Bool("pretty", false, "pretty print JSON messages in the text log")
+	redact := flag.String("redact", "", "comma-separated JSON field paths to redact in the log (ie, params.request.headers.Authorization)")
+	latency := flag.Bool("latency", false, "log the round-trip latency of each command")
+	otel := flag.Bool("otel", false, "export an OpenTelemetry span for each session to $OTEL_EXPORTER_OTLP_ENDPOINT (default http://localhost:4318)")
+	otelCalls := flag.Bool("otel-calls", false, "also export a span for each CDP command (with -otel)")
+	maxLogBytes := flag.Int("max-log-bytes", proxy.DefaultMaxLogBytes, "maximum bytes of a message to log (0 disables truncation)")
+	cert := flag.String("cert", "", "tls certificate file")
+	key := flag.String("key", "", "tls key file")
+	tlsListen := flag.String("tls-listen", "", "tls listen address to serve alongside the plain -l listen address (requires -cert and -key)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", proxy.DefaultShutdownTimeout, "time to let active sessions finish on shutdown")
+	readBuffer := flag.Int("read-buffer", proxy.DefaultReadBufferSize, "websocket buffer size in bytes for messages from the client")
+	writeBuffer := flag.Int("write-buffer", proxy.DefaultWriteBufferSize, "websocket buffer size in bytes for messages to the client")
+	maxMessageSize := flag.Int64("max-message-size", 0, "close sessions where the client or remote sends a message larger than the size in bytes (0 for no limit)")
+	sessionMaxBytes := flag.Int64("session-max-bytes", 0, "close sessions where the client sends more than the bytes to the remote in total (0 for no limit)")
+	sessionMaxFrames := flag.Int64("session-max-frames", 0, "close sessions where the client sends more than the messages to the remote (0 for no limit)")
+	sessionMaxRemoteBytes := flag.Int64("session-max-remote-bytes", 0, "close sessions where the remote sends more than the bytes to the client in total (0 for no limit)")
+	subprotocols := flag.String("subprotocols", "", "comma-separated websocket subprotocol globs requested by the client to forward to the remote (ie, * for all)")
+	compression := flag.Bool("compression", false, "negotiate websocket compression (permessage-deflate) with the remote and client")
+	writeTimeout := flag.Duration("write-timeout", 0, "close sessions whose peer does not read a message within the timeout (0 disables the timeout)")
+	writeQueue := flag.Int("write-queue", 0, "queue up to the number of messages per direction for a slow peer, closing the session when full (0 disables the queue)")
+	protocolCache := flag.Bool("protocol-cache", false, "cache each remote's /json/protocol for the lifetime of the proxy")
+	targetsPage := flag.Bool("list-targets-html", false, "serve an html index of the remote's targets at the proxy root, linking to the devtools frontend through the proxy")
+	statsInterval := flag.Duration("stats-interval", 0, "interval to log the active connections and message throughput at (ie, 5s; 0 disables)")
+	metrics := flag.String("metrics", "", "prometheus metrics listen address (ie, localhost:9224)")
+	pprofAddr := flag.String("pprof", "", "pprof debug listen address (ie, localhost:6060)")
+	auth := flag.String("auth", "", "require basic auth credentials (user:pass, defaults to $CDP_PROXY_AUTH)")
+	responseHeaders := flag.String("response-headers", "", "comma-separated headers to copy from the remote's websocket handshake response to the client's (ie, Set-Cookie)")
+	forwardedFor := flag.Bool("forwarded-for", false, "send the client address to the remote in a X-Forwarded-For header")
+	trustedProxies := flag.String("trusted-proxies", "", "comma-separated addresses or CIDRs of proxies whose X-Forwarded-For client address is trusted (ie, 10.0.0.0/8)")
+	allowOrigin := flag.String("allow-origin", "", "comma-separated origins allowed to connect (default allows all)")
+	include := flag.String("include", "", "comma-separated CDP method globs to log (ie, Network.*,-Network.dataReceived)")
+	exclude := flag.String("exclude", "", "comma-separated CDP method globs to not log")
+	keepalive := flag.Duration("keepalive", 0, "interval to send websocket pings to the client and remote (0 disables pings)")
+	heartbeat := flag.Duration("heartbeat", 0, "log a heartbeat line for sessions with no messages for the duration, repeated while idle (0 disables heartbeats)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "close sessions with no messages for the duration (0 disables the timeout)")
+	handshakeTimeout := flag.Duration("handshake-timeout", 0, "timeout for the websocket handshake with the remote (0 disables the timeout)")
+	session := flag.String("session", "", "comma-separated devtools id globs of the sessions to log (default logs all sessions)")
+	targetType := flag.String("target-type", "", "comma-separated target types of the sessions to log (ie, page, default logs all sessions)")
+	ids := flag.String("ids", "", "comma-separated CDP message ids or id ranges of the commands to log with their responses (ie, 42,100-120, events are not logged)")
+	cdpSession := flag.String("cdp-session", "", "comma-separated sessionId globs of the flattened target sessions whose messages to log (root for messages without a sessionId, default logs all messages)")
+	execCommand := flag.String("exec", "", "pipe each message to the stdin of the program (a command line, split on spaces), without waiting for it")
+	execIntercept := flag.Bool("exec-intercept", false, "forward the -exec program's replies instead of the messages (adds the program's latency to each message)")
+	denyBrowser := flag.Bool("deny-browser-target", false, "reject sessions of the browser target (/devtools/browser/<id>)")
+	validate := flag.Bool("validate", false, "reject client messages that are not well-formed CDP commands with an error response, instead of forwarding them")
+	var injectDelays listFlag
+	flag.Var(&injectDelays, "inject-delay", `delay forwarding the messages with a CDP method matching the glob, as "[in:|out:]method=delay[-max]" (ie, out:Page.navigate=2s delays the responses to Page.navigate, *=50ms-200ms delays all messages randomly) (repeatable)`)
+	var dropRates listFlag
+	flag.Var(&dropRates, "drop-rate", `randomly drop the rate of the messages with a CDP method matching the glob instead of forwarding them, for chaos testing, as "[[in:|out:]method=]rate" (ie, 0.01 drops 1% of all messages, out:Network.*=0.1 drops 10% of Network events) (repeatable)`)
+	block := flag.String("block", "", "comma-separated CDP method globs to block from the client (ie, Browser.*,Target.createTarget)")
+	followRedirects := flag.Bool("follow-redirects", false, "follow redirects of the remote's http endpoints (ie, /json) instead of passing them on to the client")
+	jsonErrors := flag.Bool("json-errors", false, "respond to failed devtools connections with a json error body, including the failed stage")
+	connectCommands := flag.String("on-connect-commands", "", `json array of CDP commands to write to the remote when a session connects (ie, [{"method":"Network.enable"}])`)
+	dialRetries := flag.Int("dial-retries", 0, "number of times to retry connecting to the remote")
+	dialBackoff := flag.Duration("dial-backoff", proxy.DefaultDialBackoff, "wait before the first retry connecting to the remote, doubled on each retry")
+	reconnect := flag.Duration("reconnect", 0, "reconnect to the remote for up to the duration when a session's remote connection is lost (0 disables reconnection)")
+	reconnectAnyPage := flag.Bool("reconnect-any-page", false, "reconnect sessions whose target is no longer listed to the remote's first page target, which may be a different tab (with -reconnect)")
+	maxConns := flag.Int("max-conns", 0, "maximum concurrent devtools sessions (0 for no limit)")
+	rate := flag.Float64("rate", 0, "maximum new devtools sessions per second (0 for no limit)")
+	readyJSON := flag.Bool("ready-json", false, `print a {"event":"listening"} json line to stdout once the proxy is listening`)
+	oneShot := flag.Bool("one-shot", false, "shut down gracefully after the first devtools session closes")
+	checkRemote := flag.Bool("check-remote", false, "exit with an error at startup when a remote is unreachable")
+	rulesFile := flag.String("rules", "", "routing rules file, routing the root's targets to the remotes by url or title (lines of <remote> <url|host|title> <pattern>)")
+	list := flag.Bool("list", false, "list the targets exposed by the remote and exit")
+	record := flag.String("record", "", "record all sessions to a single archive file (ie, session.cdpr)")
+	dbFile := flag.String("db", "", "insert all proxied messages in a SQLite database file (ie, capture.sqlite, requires building with -tags sqlite)")
+	teeAddr := flag.String("tee", "", "mirror all proxied messages to the sink, as archive json lines (tcp host:port, unix:///path, file or pipe path, or ws:// url)")
+	browserTraceDir := flag.String("browser-trace-dir", "", "trace the browser for each session (with Tracing.start), saving each session's trace to a chrome://tracing file in the directory")
+	consoleDir := flag.String("console-dir", "", "write the console messages and exceptions of each session to a console log file in the directory")
+	screencastDir := flag.String("screencast-dir", "", "save the Page.screencastFrame images of all sessions to files in the directory")
+	traceEvents := flag.String("trace-events", "", "write the CDP calls of all sessions to a Trace Event Format file for Perfetto or chrome://tracing (ie, trace.json)")
+	har := flag.String("har", "", "write the Network events of all sessions to a HAR file (ie, out.har)")
+	harBodies := flag.Bool("har-bodies", false, "capture response bodies in the HAR file (intercepts responses with the Fetch domain)")
+	replay := flag.String("replay", "", "replay the client messages from a log file to the remote and exit")
+	verifyLog := flag.String("verify-log", "", "verify the hashes of a jsonl log file written with -log-hashes and exit")
+	analyze := flag.String("analyze", "", "print the message statistics of a log file (and the messages matching -include and -exclude) and exit")
+	diff := flag.String("diff", "", "compare the client commands of a log file with the log file passed as argument (ie, -diff a.log b.log), print the first divergence and exit")
+	config := flag.String("config", "", "yaml or json config file with flag values (flags override config values)")
+	showVersion := flag.Bool("version", false, "print the proxy's version, git commit and go version and exit")
+	profile := flag.String("profile", "", "preset of buffer, compression, keepalive and logging flags ("+strings.Join(profileNames(), ", ")+"), overridden by individual flags")
 	flag.Parse()
-	if err := run(context.Background(), *listen, *remote, *noLog, *logMask); err != nil {
+	if *showVersion {
+		fmt.Println("chromedp-proxy " + readBuildInfo().String())
+		return
+	}
+	// flags override environment variables, which override the config file,
+	// which overrides the profile
+	cmd := visited(flag.CommandLine)
+	env, err := applyEnv(flag.CommandLine)
+	if err == nil && *config != "" {
+		err = applyConfig(flag.CommandLine, *config)
+	}
+	var prof map[string]string
+	if err == nil && *profile != "" {
+		prof, err = applyProfile(flag.CommandLine, *profile)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-}
-
-func run(ctx context.Context, listen, remote string, noLog bool, logMask string) error {
-	mux := http.NewServeMux()
-	simplep := httputil.NewSingleHostReverseProxy(&url.URL{
-		Scheme: "http",
-		Host:   remote,
-	})
-	mux.Handle("/json", simplep)
-	mux.Handle("/", simplep)
-	mux.HandleFunc("/devtools/", func(res http.ResponseWriter, req *http.Request) {
-		id := path.Base(req.URL.Path)
-		f, logger := createLog(noLog, logMask, id)
-		if f != nil {
-			defer f.Close()
-		}
-		logger.Printf("---------- connection from %s ----------", req.RemoteAddr)
-		ver, err := checkVersion(ctx, remote)
-		if err != nil {
-			msg := fmt.Sprintf("version error, got: %v", err)
-			logger.Println(msg)
-			http.Error(res, msg, http.StatusInternalServerError)
-			return
+	if visited(flag.CommandLine)["run-id"] {
+		if *runID == "" {
+			*runID = newRunID()
+		}
+		log.SetPrefix("[" + *runID + "] ")
+		log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	}
+	if !*quiet {
+		logFlags(flag.CommandLine, cmd, env, prof)
+	}
+	useColor, err := colorEnabled(*color)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	var authUser, authPass string
+	if *auth != "" {
+		var ok bool
+		if authUser, authPass, ok = strings.Cut(*auth, ":"); !ok {
+			fmt.Fprintln(os.Stderr, "error: -auth must be in the form user:pass")
+			os.Exit(1)
 		}
-		logger.Printf("endpoint %s reported: %s", remote, string(ver))
-		endpoint := "ws://" + remote + path.Join(path.Dir(req.URL.Path), id)
-		// connect outgoing websocket
-		logger.Printf("connecting to %s", endpoint)
-		out, pres, err := wsDialer.Dial(endpoint, nil)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := []proxy.Option{
+		proxy.WithListen(*listen),
+		proxy.WithRemoteInsecure(*remoteInsecure),
+		proxy.WithWSPath(*wsPath),
+		proxy.WithNoVersionCheck(*noVersionCheck),
+		proxy.WithVersionFile(*versionFile),
+		proxy.WithMinProtocol(*minProtocol),
+		proxy.WithNoNormalizeHost(*noNormalizeHost),
+		proxy.WithNoLog(*noLog),
+		proxy.WithLogMask(*logMask),
+		proxy.WithLogNameType(*logNameType),
+		proxy.WithLogSingle(*logSingle),
+		proxy.WithLogRotate(*logMaxSize*1024*1024, *logBackups),
+		proxy.WithLogGzip(*logGzip),
+		proxy.WithNoStdout(*noStdout),
+		proxy.WithLogQuota(*logMaxFiles, *logMaxTotal),
+		proxy.WithFormat(format),
+		proxy.WithStdoutFormat(stdoutFormat),
+		proxy.WithLogMicros(*logMicros),
+		proxy.WithLogHashes(*logHashes),
+		proxy.WithSplitByDomain(*splitByDomain),
+		proxy.WithTimestampFrames(*timestampFrames),
+		proxy.WithHeadFrames(*headFrames),
+		proxy.WithLogPrefix(*logPrefix),
+		proxy.WithMetadataOnly(*metadataOnly),
+		proxy.WithOrderedLog(*logOrdered),
+		proxy.WithLogHandshake(*logHandshake),
+		proxy.WithRunID(*runID),
+		proxy.WithSyslog(*useSyslog, *syslogAddr),
+		proxy.WithQuiet(*quiet),
+		proxy.WithLogStream(*logStream),
+		proxy.WithAdmin(*admin),
+		proxy.WithDetachOnClose(*detachOnClose),
+		proxy.WithColor(useColor),
+		proxy.WithPretty(*pretty),
+		proxy.WithRedact(splitList(*redact)...),
+		proxy.WithMaxLogBytes(*maxLogBytes),
+		proxy.WithLatency(*latency),
+		proxy.WithTraceCalls(*otelCalls),
+		proxy.WithTLS(*cert, *key),
+		proxy.WithTLSListen(*tlsListen),
+		proxy.WithShutdownTimeout(*shutdownTimeout),
+		proxy.WithBufferSizes(*readBuffer, *writeBuffer),
+		proxy.WithMaxMessageSize(*maxMessageSize),
+		proxy.WithSessionMaxBytes(*sessionMaxBytes),
+		proxy.WithSessionMaxFrames(*sessionMaxFrames),
+		proxy.WithSessionMaxRemoteBytes(*sessionMaxRemoteBytes),
+		proxy.WithKeepalive(*keepalive),
+		proxy.WithHeartbeat(*heartbeat),
+		proxy.WithIdleTimeout(*idleTimeout),
+		proxy.WithHandshakeTimeout(*handshakeTimeout),
+		proxy.WithDialRetries(*dialRetries, *dialBackoff),
+		proxy.WithReconnect(*reconnect),
+		proxy.WithReconnectAnyPage(*reconnectAnyPage),
+		proxy.WithMaxConns(*maxConns),
+		proxy.WithRate(*rate),
+		proxy.WithWriteQueue(*writeQueue),
+		proxy.WithWriteTimeout(*writeTimeout),
+		proxy.WithCompression(*compression),
+		proxy.WithSubprotocols(splitList(*subprotocols)...),
+		proxy.WithProtocolCache(*protocolCache),
+		proxy.WithMetrics(*metrics),
+		proxy.WithStatsInterval(*statsInterval),
+		proxy.WithTargetsPage(*targetsPage),
+		proxy.WithBasicAuth(authUser, authPass),
+		proxy.WithAllowOrigins(splitList(*allowOrigin)...),
+		proxy.WithResponseHeaders(splitList(*responseHeaders)...),
+		proxy.WithInclude(splitList(*include)...),
+		proxy.WithExclude(splitList(*exclude)...),
+		proxy.WithLogSessions(splitList(*session)...),
+		proxy.WithLogTargetTypes(splitList(*targetType)...),
+		proxy.WithLogCDPSessions(splitList(*cdpSession)...),
+		proxy.WithBlock(splitList(*block)...),
+		proxy.WithValidate(*validate),
+		proxy.WithDenyBrowserTarget(*denyBrowser),
+		proxy.WithJSONErrors(*jsonErrors),
+		proxy.WithFollowRedirects(*followRedirects),
+		proxy.WithRecord(*record),
+		proxy.WithTee(*teeAddr),
+		proxy.WithDB(*dbFile),
+		proxy.WithHAR(*har),
+		proxy.WithHARBodies(*harBodies),
+		proxy.WithTraceEvents(*traceEvents),
+		proxy.WithScreencastDir(*screencastDir),
+		proxy.WithConsoleDir(*consoleDir),
+		proxy.WithBrowserTraceDir(*browserTraceDir),
+	}
+	opts = append(opts, remoteOptions(remotes)...)
+	if *remoteHTTP != "" {
+		opts = append(opts, proxy.WithRemoteHTTP(*remoteHTTP))
+	}
+	if *remoteWS != "" {
+		opts = append(opts, proxy.WithRemoteWS(*remoteWS))
+	}
+	if *srv != "" {
+		opts = append(opts, proxy.WithSRV(*srv, *srvInterval))
+	}
+	if *remoteFile != "" {
+		opts = append(opts, proxy.WithRemoteFile(*remoteFile))
+	}
+	if *activePort != "" {
+		opts = append(opts, proxy.WithDevToolsActivePort(*activePort))
+	}
+	if *remoteSelect != "" {
+		opts = append(opts, proxy.WithRemoteSelect(splitList(*remoteSelect)...))
+	}
+	if *rulesFile != "" {
+		rules, err := readRules(*rulesFile)
 		if err != nil {
-			msg := fmt.Sprintf("could not connect to %s, got: %v", endpoint, err)
-			logger.Println(msg)
-			http.Error(res, msg, http.StatusInternalServerError)
-			return
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
-		defer pres.Body.Close()
-		defer out.Close()
-		logger.Printf("connected to %s", endpoint)
-		// connect incoming websocket
-		logger.Printf("upgrading connection on %s", req.RemoteAddr)
-		in, err := wsUpgrader.Upgrade(res, req, nil)
+		opts = append(opts, proxy.WithRules(rules...))
+	}
+	if *remoteProxy != "" {
+		u, err := url.Parse(*remoteProxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			fmt.Fprintf(os.Stderr, "error: invalid -remote-proxy %q\n", *remoteProxy)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithRemoteProxy(u))
+	}
+	if *logOnError {
+		opts = append(opts, proxy.WithLogOnError(*logOnErrorLines))
+	}
+	if *logNameReplace != "" {
+		opts = append(opts, proxy.WithLogNameFunc(proxy.ReplaceLogName(*logNameReplace)))
+	}
+	if *execCommand != "" {
+		opts = append(opts, proxy.WithExec(strings.Fields(*execCommand), *execIntercept))
+	}
+	if *forwardedFor {
+		opts = append(opts, proxy.WithForwardedFor(true))
+	}
+	if *trustedProxies != "" {
+		prefixes, err := parsePrefixes(splitList(*trustedProxies))
 		if err != nil {
-			msg := fmt.Sprintf("could not upgrade websocket from %s, got: %v", req.RemoteAddr, err)
-			logger.Println(msg)
-			http.Error(res, msg, http.StatusInternalServerError)
-			return
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
 		}
-		defer in.Close()
-		logger.Printf("upgraded connection on %s", req.RemoteAddr)
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
-		errc := make(chan error, 1)
-		go proxyWS(ctx, logger, "<-", in, out, errc)
-		go proxyWS(ctx, logger, "->", out, in, errc)
-		<-errc
-		logger.Printf("---------- closing %s ----------", req.RemoteAddr)
-	})
-	return http.ListenAndServe(listen, mux)
-}
-
-const (
-	incomingBufferSize = 10 * 1024 * 1024
-	outgoingBufferSize = 25 * 1024 * 1024
-)
-
-var wsUpgrader = &websocket.Upgrader{
-	ReadBufferSize:  incomingBufferSize,
-	WriteBufferSize: outgoingBufferSize,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+		opts = append(opts, proxy.WithTrustedProxies(prefixes...))
+	}
+	for _, v := range injectDelays {
+		glob, min, max, dirs, err := parseInjectDelay(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithInjectDelay(glob, min, max, dirs...))
+	}
+	for _, v := range dropRates {
+		glob, rate, dirs, err := parseDropRate(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithDropRate(glob, rate, dirs...))
+	}
+	for _, v := range samples {
+		glob, rate, err := parseSample(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithSample(glob, rate))
+	}
+	if len(versionOverrides) != 0 {
+		overrides, err := parseVersionOverrides(versionOverrides)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithVersionOverride(overrides))
+	}
+	if len(remoteHeaders) != 0 {
+		header, err := parseHeaders(remoteHeaders)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithRemoteHeader(header))
+	}
+	if *ids != "" {
+		ranges, err := proxy.ParseIDRanges(*ids)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithLogIDs(ranges...))
+	}
+	if *logS3 != "" {
+		cfg, err := s3Config(*logS3)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithLogS3(cfg))
+	}
+	if *otel {
+		endpoint, header, err := otlpConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithTracing(endpoint, header))
+	}
+	if *connectCommands != "" {
+		commands, err := parseCommands(*connectCommands)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, proxy.WithOnConnectCommands(commands...))
+	}
+	cfg := runConfig{
+		replay:      *replay,
+		analyze:     *analyze,
+		diff:        diffArgs(*diff),
+		verifyLog:   *verifyLog,
+		list:        *list,
+		checkRemote: *checkRemote,
+		oneShot:     *oneShot,
+		readyJSON:   *readyJSON,
+		pprofAddr:   *pprofAddr,
+		launch:      *launch,
+		chrome:      *chrome,
+		chromeArgs:  strings.Fields(*chromeArgs),
+	}
+	if err := run(ctx, cfg, opts...); err != nil {
+		msg, code := exitStatus(err)
+		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
+		stop()
+		os.Exit(code)
+	}
 }
 
-var wsDialer = &websocket.Dialer{
-	ReadBufferSize:  outgoingBufferSize,
-	WriteBufferSize: incomingBufferSize,
+// runConfig is the configuration for run.
+type runConfig struct {
+	// replay is the log file to replay, instead of running the proxy.
+	replay string
+	// analyze is the log file to print the statistics of, instead of running
+	// the proxy.
+	analyze string
+	// diff are the two log files to compare, instead of running the proxy.
+	diff []string
+	// verifyLog is the log file to verify the hashes of, instead of running
+	// the proxy.
+	verifyLog string
+	// list lists the remote's targets, instead of running the proxy.
+	list bool
+	// checkRemote checks that the remotes are reachable before serving.
+	checkRemote bool
+	// oneShot shuts the proxy down after the first session closes.
+	oneShot bool
+	// readyJSON prints a json line to stdout once the proxy is listening.
+	readyJSON bool
+	// pprofAddr is the address to serve the pprof handlers on.
+	pprofAddr string
+	// launch launches a browser as the default remote.
+	launch bool
+	// chrome is the path of the browser binary to launch.
+	chrome string
+	// chromeArgs are the extra args to launch the browser with.
+	chromeArgs []string
 }
 
-// proxyWS proxies in and out messages for a websocket connection, logging the
-// message to the logger with the passed prefix. Any error encountered will be
-// sent to errc.
-func proxyWS(ctx context.Context, logger *log.Logger, prefix string, in, out *websocket.Conn, errc chan error) {
-	var mt int
-	var buf []byte
-	var err error
-	for {
-		select {
+// run creates and runs the proxy with the passed options, or replays the log
+// file when replay is not empty. When pprofAddr is not empty, the pprof
+// handlers are served on a separate server at the address. When launch is
+// true, a browser is launched and used as the default remote, and is shut
+// down when run returns. When oneShot is true, the proxy is shut down
+// gracefully once the first devtools session has closed. When readyJSON is
+// true, a listening event is printed to stdout once the proxy is listening
+// (see readyEvent).
+func run(ctx context.Context, cfg runConfig, opts ...proxy.Option) error {
+	if cfg.pprofAddr != "" {
+		ln, err := net.Listen("tcp", cfg.pprofAddr)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		go func() {
+			_ = http.Serve(ln, pprofHandler())
+		}()
+	}
+	if cfg.launch {
+		b, err := proxy.Launch(ctx, cfg.chrome, cfg.chromeArgs...)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		log.Printf("launched browser listening on %s", b.Addr)
+		opts = append(opts, proxy.WithRemote(b.Addr))
+	}
+	// only serving is stopped after a one shot session, so that a launched
+	// browser outlives the proxy's graceful shutdown
+	serveCtx := ctx
+	if cfg.oneShot {
+		var cancel context.CancelFunc
+		serveCtx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		var once sync.Once
+		opts = append(opts, proxy.WithOnDisconnect(func(proxy.Info, proxy.Stats) {
+			once.Do(func() {
+				log.Printf("session closed, shutting down (-one-shot)")
+				cancel()
+			})
+		}))
+	}
+	p := proxy.New(opts...)
+	if cfg.verifyLog != "" {
+		f, err := os.Open(cfg.verifyLog)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		res, err := proxy.VerifyLog(f)
+		if err != nil {
+			return fmt.Errorf("could not verify %s: %w", cfg.verifyLog, err)
+		}
+		fmt.Printf("verified %d entries of %d sessions\n", res.Entries, res.Sessions)
+		return nil
+	}
+	if cfg.diff != nil {
+		return diffLogs(p, cfg.diff[0], cfg.diff[1])
+	}
+	if cfg.analyze != "" {
+		f, err := os.Open(cfg.analyze)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return p.Analyze(f, os.Stdout)
+	}
+	if cfg.list {
+		return listTargets(ctx, p)
+	}
+	if cfg.checkRemote {
+		if err := p.CheckRemotes(ctx); err != nil {
+			return err
+		}
+	}
+	if cfg.replay == "" {
+		// a socket activation listener replaces -l
+		ln, err := activationListener()
+		switch {
+		case err != nil:
+			return err
+		case ln != nil:
+			log.Printf("using the systemd socket activation listener")
 		default:
-			mt, buf, err = in.ReadMessage()
-			if err != nil {
-				errc <- err
-				return
+			if ln, err = p.Listen(); err != nil {
+				return err
 			}
-			logger.Println(prefix, string(buf))
-			err = out.WriteMessage(mt, buf)
+		}
+		build := readBuildInfo()
+		log.Printf("chromedp-proxy %s listening on %s", build, ln.Addr())
+		if cfg.readyJSON {
+			buf, err := json.Marshal(readyEvent{Event: "listening", Addr: ln.Addr().String(), Remote: p.RemoteAddr(""), buildInfo: build})
 			if err != nil {
-				errc <- err
-				return
+				return err
 			}
+			fmt.Println(string(buf))
+		}
+		go reopenLogs(ctx, p)
+		return p.Serve(serveCtx, ln)
+	}
+	f, err := os.Open(cfg.replay)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.Replay(ctx, f)
+}
+
+// readyEvent is the json line printed to stdout once the proxy is listening
+// (see -ready-json), for wrappers to wait on, with the proxy's build
+// information.
+type readyEvent struct {
+	Event  string `json:"event"`
+	Addr   string `json:"addr"`
+	Remote string `json:"remote"`
+	buildInfo
+}
+
+// reopenLogs reopens the proxy's log files on SIGHUP, for external log
+// rotation tools, until the context is done.
+func reopenLogs(ctx context.Context, p *proxy.Proxy) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
 		case <-ctx.Done():
 			return
+		case <-ch:
+			if err := p.ReopenLogs(); err != nil {
+				log.Print(err)
+				continue
+			}
+			log.Printf("reopened log files")
 		}
 	}
 }
 
-// checkVersion retrieves the version information for the remote endpoint, and
-// formats it appropriately.
-func checkVersion(ctx context.Context, remote string) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+remote+"/json/version", nil)
+// diffArgs returns the log files to compare for -diff: the flag's file, and
+// the file passed as argument.
+func diffArgs(filename string) []string {
+	if filename == "" {
+		return nil
+	}
+	return []string{filename, flag.Arg(0)}
+}
+
+// diffLogs prints the first divergence of the log files, returning an error
+// when the logs diverge.
+func diffLogs(p *proxy.Proxy, a, b string) error {
+	if b == "" {
+		return fmt.Errorf("-diff needs a second log file argument (ie, -diff a.log b.log)")
+	}
+	fa, err := os.Open(a)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	cl := &http.Client{}
-	res, err := cl.Do(req)
+	defer fa.Close()
+	fb, err := os.Open(b)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer fb.Close()
+	diverged, err := p.Diff(fa, fb, os.Stdout)
+	switch {
+	case err != nil:
+		return err
+	case diverged:
+		return fmt.Errorf("%s and %s diverge", a, b)
+	}
+	return nil
+}
+
+// listTargets prints the targets exposed by the proxy's remotes.
+func listTargets(ctx context.Context, p *proxy.Proxy) error {
+	targets, err := p.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	named := false
+	for _, t := range targets {
+		named = named || t.Remote != ""
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if named {
+		fmt.Fprint(w, "REMOTE\t")
+	}
+	fmt.Fprintln(w, "ID\tTYPE\tTITLE\tURL")
+	for _, t := range targets {
+		if named {
+			fmt.Fprintf(w, "%s\t", t.Remote)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ID, t.Type, t.Title, t.URL)
+	}
+	return w.Flush()
+}
+
+// pprofHandler returns a http.Handler serving the pprof handlers under
+// /debug/pprof/.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// colorEnabled returns whether stdout should be colorized for the color flag
+// value. When auto, stdout is colorized when it is a terminal, and the
+// NO_COLOR environment variable is not set.
+func colorEnabled(color string) (bool, error) {
+	switch color {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid -color %q", color)
+}
+
+// splitList splits a comma-separated list, discarding empty values.
+func splitList(s string) []string {
+	var v []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			v = append(v, x)
+		}
+	}
+	return v
+}
+
+// listFlag is a repeatable string flag.
+type listFlag []string
+
+// String satisfies the flag.Value interface.
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set satisfies the flag.Value interface.
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// remoteOptions returns the proxy options for the remote flags, where each
+// remote is either an address for the default remote, or name=address for a
+// named remote.
+func remoteOptions(remotes []string) []proxy.Option {
+	if len(remotes) == 0 {
+		return nil
+	}
+	opts := []proxy.Option{proxy.WithRemote("")}
+	for _, r := range remotes {
+		if name, addr, ok := strings.Cut(r, "="); ok && name != "" && !strings.ContainsAny(name, ":/") {
+			opts = append(opts, proxy.WithNamedRemote(name, addr))
+		} else {
+			opts = append(opts, proxy.WithRemote(r))
+		}
 	}
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+	return opts
+}
+
+// readRules reads the routing rules file.
+func readRules(name string) ([]proxy.Rule, error) {
+	f, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
-	var v map[string]string
-	if err := json.Unmarshal(body, &v); err != nil {
-		return nil, fmt.Errorf("expected json result: %w", err)
+	defer f.Close()
+	rules, err := proxy.ReadRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", name, err)
+	}
+	return rules, nil
+}
+
+// parseCommands parses the json array of CDP commands passed to
+// -on-connect-commands.
+func parseCommands(s string) ([]proxy.Command, error) {
+	var commands []proxy.Command
+	if err := json.Unmarshal([]byte(s), &commands); err != nil {
+		return nil, fmt.Errorf("invalid -on-connect-commands: %w", err)
+	}
+	for i, cmd := range commands {
+		if cmd.Method == "" {
+			return nil, fmt.Errorf("invalid -on-connect-commands: command %d has no method", i+1)
+		}
+	}
+	return commands, nil
+}
+
+// newRunID returns a random (version 4) uuid for the run id.
+func newRunID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// parseHeaders parses the "Key: Value" headers of the -remote-header flags.
+func parseHeaders(values []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, kv := range values {
+		k, v, ok := strings.Cut(kv, ":")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return nil, fmt.Errorf("invalid -remote-header %q (expected \"Key: Value\")", kv)
+		}
+		header.Add(k, strings.TrimSpace(v))
+	}
+	return header, nil
+}
+
+// parseVersionOverrides parses the Field=value overrides of the
+// -version-override flag.
+func parseVersionOverrides(values []string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, kv := range values {
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return nil, fmt.Errorf("invalid -version-override %q (expected \"Field=value\")", kv)
+		}
+		overrides[k] = v
+	}
+	return overrides, nil
+}
+
+// parseInjectDelay parses a [in:|out:]method=delay[-max] delay of the
+// -inject-delay flag.
+func parseInjectDelay(v string) (string, time.Duration, time.Duration, []proxy.Direction, error) {
+	invalid := fmt.Errorf("invalid -inject-delay %q (expected \"[in:|out:]method=delay[-max]\")", v)
+	spec, delay, ok := strings.Cut(v, "=")
+	if !ok {
+		return "", 0, 0, nil, invalid
+	}
+	glob, dirs, ok := parseMethodRule(spec)
+	if !ok {
+		return "", 0, 0, nil, invalid
+	}
+	minDelay, maxDelay, isRange := strings.Cut(delay, "-")
+	min, err := time.ParseDuration(strings.TrimSpace(minDelay))
+	if err != nil || min < 0 {
+		return "", 0, 0, nil, invalid
+	}
+	max := min
+	if isRange {
+		if max, err = time.ParseDuration(strings.TrimSpace(maxDelay)); err != nil || max < min {
+			return "", 0, 0, nil, invalid
+		}
+	}
+	return glob, min, max, dirs, nil
+}
+
+// parseDropRate parses a [[in:|out:]method=]rate drop rate of the -drop-rate
+// flag, a rate alone dropping messages of all methods in both directions.
+func parseDropRate(v string) (string, float64, []proxy.Direction, error) {
+	invalid := fmt.Errorf("invalid -drop-rate %q (expected \"[[in:|out:]method=]rate\" with a rate between 0 and 1)", v)
+	spec, s, ok := strings.Cut(v, "=")
+	if !ok {
+		spec, s = "*", v
+	}
+	glob, dirs, ok := parseMethodRule(spec)
+	if !ok {
+		return "", 0, nil, invalid
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return "", 0, nil, invalid
+	}
+	return glob, rate, dirs, nil
+}
+
+// parseMethodRule parses the [in:|out:]method glob of a fault injection rule,
+// returning the glob and the rule's direction, if any.
+func parseMethodRule(spec string) (string, []proxy.Direction, bool) {
+	var dirs []proxy.Direction
+	if d, glob, ok := strings.Cut(spec, ":"); ok {
+		var dir proxy.Direction
+		if err := dir.UnmarshalText([]byte(d)); err != nil {
+			return "", nil, false
+		}
+		dirs, spec = []proxy.Direction{dir}, glob
+	}
+	if spec = strings.TrimSpace(spec); spec == "" {
+		return "", nil, false
+	}
+	return spec, dirs, true
+}
+
+// parseSample parses a method=rate sample of the -sample flag.
+func parseSample(v string) (string, float64, error) {
+	glob, s, ok := strings.Cut(v, "=")
+	if glob = strings.TrimSpace(glob); !ok || glob == "" {
+		return "", 0, fmt.Errorf("invalid -sample %q (expected \"method=rate\")", v)
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return "", 0, fmt.Errorf("invalid -sample %q (expected a rate between 0 and 1)", v)
 	}
-	return body, nil
+	return glob, rate, nil
 }
 
-// createLog creates a log for the specified id based on flags.
-func createLog(noLog bool, logMask, id string) (io.Closer, *log.Logger) {
-	var f io.Closer
-	var w io.Writer = os.Stdout
-	if !noLog && logMask != "" {
-		filename := logMask
-		if strings.Contains(logMask, "%s") {
-			filename = fmt.Sprintf(logMask, cleanRE.ReplaceAllString(id, ""))
+// parsePrefixes parses the addresses and CIDRs of the -trusted-proxies flag.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range values {
+		if addr, err := netip.ParseAddr(v); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
 		}
-		l, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		prefix, err := netip.ParsePrefix(v)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("invalid trusted proxy %q", v)
 		}
-		f, w = l, io.MultiWriter(os.Stdout, l)
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// s3Config returns the configuration of the s3 bucket of the s3://bucket/prefix
+// url, from the standard AWS_* environment variables.
+func s3Config(s string) (proxy.S3Config, error) {
+	bucket, prefix, err := proxy.ParseS3URL(s)
+	if err != nil {
+		return proxy.S3Config{}, err
+	}
+	cfg := proxy.S3Config{
+		Bucket:          bucket,
+		Prefix:          prefix,
+		Region:          os.Getenv("AWS_REGION"),
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL_S3"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
 	}
-	return f, log.New(w, "", log.LstdFlags)
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if u, err := url.Parse(cfg.Endpoint); cfg.Endpoint != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		return proxy.S3Config{}, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return proxy.S3Config{}, fmt.Errorf("-log-s3 requires the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+	return cfg, nil
 }
 
-var cleanRE = regexp.MustCompile(`[^a-zA-Z0-9_\-\.]`)
+// otlpConfig returns the OTLP/HTTP traces endpoint and export headers from the
+// standard OTEL_EXPORTER_OTLP_* environment variables.
+func otlpConfig() (string, http.Header, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			base = "http://localhost:4318"
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		return "", nil, fmt.Errorf("invalid otlp endpoint %q", endpoint)
+	}
+	header := make(http.Header)
+	for _, kv := range splitList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")) {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q", kv)
+		}
+		if uv, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = uv
+		}
+		header.Add(strings.TrimSpace(k), v)
+	}
+	return endpoint, header, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profiles are the presets of flag values for -profile, tuning the buffer
+// sizes, compression, keepalive and logging together.
+var profiles = map[string]map[string]string{
+	// low-memory keeps the per-session buffers small, truncating logged
+	// messages early, for many concurrent sessions on a small host
+	"low-memory": {
+		"read-buffer":        "65536",
+		"write-buffer":       "65536",
+		"compression":        "false",
+		"max-log-bytes":      "4096",
+		"log-on-error-lines": "1000",
+	},
+	// high-throughput uses buffers larger than the defaults, skips
+	// compression, and truncates logged messages early, for bulk traffic (ie,
+	// screencasts, heavy Network events)
+	"high-throughput": {
+		"read-buffer":   "33554432",
+		"write-buffer":  "33554432",
+		"compression":   "false",
+		"max-log-bytes": "1024",
+		"keepalive":     "30s",
+	},
+	// capture logs everything in full, for debugging sessions after the
+	// fact
+	"capture": {
+		"max-log-bytes": "0",
+		"log-micros":    "true",
+		"log-handshake": "true",
+		"log-gzip":      "true",
+		"keepalive":     "30s",
+	},
+}
+
+// profileNames returns the names of the profiles, sorted.
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile sets the flags of the named profile that have not been set
+// otherwise (on the command line, by an environment variable, or by the
+// config file), returning the profile name of each flag that was set.
+func applyProfile(fs *flag.FlagSet, name string) (map[string]string, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q (expected one of %s)", name, strings.Join(profileNames(), ", "))
+	}
+	set := visited(fs)
+	applied := make(map[string]string)
+	for k, v := range profile {
+		if set[k] {
+			continue
+		}
+		if err := fs.Set(k, v); err != nil {
+			return nil, fmt.Errorf("profile %s: invalid value %q for -%s: %w", name, v, k, err)
+		}
+		applied[k] = name
+	}
+	return applied, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"testing"
+
+	"github.com/chromedp/chromedp-proxy/proxy"
+)
+
+func TestProfileHighThroughput(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	for name := range profiles["high-throughput"] {
+		fs.String(name, "", "")
+	}
+	if err := fs.Set("keepalive", "5s"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	applied, err := applyProfile(fs, "high-throughput")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, ok := applied["keepalive"]; ok || fs.Lookup("keepalive").Value.String() != "5s" {
+		t.Errorf("expected the flag to override the profile, got: %s", fs.Lookup("keepalive").Value)
+	}
+	// the profile's metadata only logging would conflict with -record, -har,
+	// -tee and -db
+	if _, ok := applied["metadata-only"]; ok {
+		t.Errorf("expected no metadata only logging")
+	}
+	for name, def := range map[string]int{"read-buffer": proxy.DefaultReadBufferSize, "write-buffer": proxy.DefaultWriteBufferSize} {
+		n, err := strconv.Atoi(fs.Lookup(name).Value.String())
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if n < def {
+			t.Errorf("expected -%s of at least the default %d, got: %d", name, def, n)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActiveSession is an active devtools session (see Proxy.Sessions).
+type ActiveSession struct {
+	Info
+	// Messages are the number of messages proxied so far in each direction,
+	// indexed by direction.
+	Messages [2]int64
+	// Bytes are the number of message bytes proxied so far in each direction,
+	// indexed by direction.
+	Bytes [2]int64
+	// LastActivity is the time of the last message proxied in either
+	// direction.
+	LastActivity time.Time
+	// Paused is true while the session's forwarding is paused (see
+	// WithAdmin).
+	Paused bool
+}
+
+// activeSessions is the registry of the proxy's active sessions.
+type activeSessions struct {
+	mu       sync.Mutex
+	sessions map[*session]Info
+}
+
+// add adds a session to the registry.
+func (a *activeSessions) add(s *session, info Info) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sessions == nil {
+		a.sessions = make(map[*session]Info)
+	}
+	a.sessions[s] = info
+}
+
+// remove removes a session from the registry.
+func (a *activeSessions) remove(s *session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, s)
+}
+
+// Sessions returns the proxy's active devtools sessions, in order of their
+// start time.
+func (p *Proxy) Sessions() []ActiveSession {
+	p.active.mu.Lock()
+	sessions := make([]ActiveSession, 0, len(p.active.sessions))
+	for s, info := range p.active.sessions {
+		st := s.stats.stats()
+		sessions = append(sessions, ActiveSession{
+			Info:         info,
+			Messages:     st.Messages,
+			Bytes:        st.Bytes,
+			LastActivity: time.Unix(0, s.last.Load()),
+			Paused:       s.gate.paused(),
+		})
+	}
+	p.active.mu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	return sessions
+}
+
+// sessionResponse is the json of an active session, as served by the admin
+// sessions endpoint.
+type sessionResponse struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	Remote       string    `json:"remote,omitempty"`
+	RemoteAddr   string    `json:"remoteAddr"`
+	Browser      string    `json:"browser,omitempty"`
+	Start        time.Time `json:"start"`
+	Conn         string    `json:"conn"`
+	MessagesIn   int64     `json:"messagesIn"`
+	MessagesOut  int64     `json:"messagesOut"`
+	BytesIn      int64     `json:"bytesIn"`
+	BytesOut     int64     `json:"bytesOut"`
+	LastActivity time.Time `json:"lastActivity"`
+	Paused       bool      `json:"paused"`
+}
+
+// serveSessions serves the proxy's active sessions as a json array (see
+// WithAdmin).
+func (p *Proxy) serveSessions(res http.ResponseWriter, req *http.Request) {
+	writeSessions(res, p.Sessions())
+}
+
+// writeSessions writes the sessions as a json array of session responses.
+func writeSessions(res http.ResponseWriter, sessions []ActiveSession) {
+	v := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		v = append(v, sessionResponse{
+			ID:           s.ID,
+			Path:         s.Path,
+			Remote:       s.Remote,
+			RemoteAddr:   s.RemoteAddr,
+			Browser:      s.Browser,
+			Start:        s.Start,
+			Conn:         s.Conn,
+			MessagesIn:   s.Messages[Incoming],
+			MessagesOut:  s.Messages[Outgoing],
+			BytesIn:      s.Bytes[Incoming],
+			BytesOut:     s.Bytes[Outgoing],
+			LastActivity: s.LastActivity,
+			Paused:       s.Paused,
+		})
+	}
+	res.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	enc := json.NewEncoder(res)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// logError is an error response read from a log.
+type logError struct {
+	e      *LogEntry
+	id     int64
+	method string
+	msg    string
+}
+
+// Analyze reads a log written by the proxy (in either the text or jsonl
+// format), and writes the aggregate statistics of the logged messages to w:
+// the message counts and bytes per direction, the count of each CDP method,
+// and the error responses (with the method of the failed command, when the
+// command was logged).
+//
+// When the proxy has include or exclude globs (see WithInclude and
+// WithExclude), the messages matching the globs are also written to w, as
+// they are read.
+func (p *Proxy) Analyze(rd io.Reader, w io.Writer) error {
+	var msgs, bytes [2]int64
+	methods := make(map[string]int64)
+	sessions := make(map[string]bool)
+	// the methods of the logged commands, by session and id
+	commands := make(map[string]string)
+	var errs []logError
+	lr := NewLogReader(rd)
+	for {
+		e, err := lr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+		case err != nil:
+			return fmt.Errorf("unable to read log: %w", err)
+		case !e.IsMessage():
+			continue
+		default:
+			var msg cdpMessage
+			if e.Metadata {
+				msg.Method, msg.ID = e.Method, e.ID
+			} else if !e.Binary && json.Unmarshal(e.Msg, &msg) != nil {
+				// truncated messages are not valid json
+				msg = truncatedMessage(e.Msg)
+			}
+			msgs[e.Dir]++
+			bytes[e.Dir] += int64(e.Size)
+			sessions[e.Session] = true
+			key := e.Session + "/" + msg.SessionID + "/"
+			if msg.ID != nil {
+				key += strconv.FormatInt(*msg.ID, 10)
+			}
+			switch {
+			case msg.Method != "":
+				methods[msg.Method]++
+				if e.Dir == Incoming && msg.ID != nil {
+					commands[key] = msg.Method
+				}
+			case msg.ID != nil && msg.Error != nil:
+				var cerr cdpError
+				_ = json.Unmarshal(msg.Error, &cerr)
+				errs = append(errs, logError{e: e, id: *msg.ID, method: commands[key], msg: cerr.Message})
+			}
+			if p.filter != nil && p.filter.match(msg.Method) {
+				writeLogEntry(w, e)
+			}
+			continue
+		}
+		break
+	}
+	fmt.Fprintf(w, "sessions: %d\n", len(sessions))
+	fmt.Fprintf(w, "messages: in %d (%d bytes), out %d (%d bytes)\n", msgs[Incoming], bytes[Incoming], msgs[Outgoing], bytes[Outgoing])
+	type methodCount struct {
+		method string
+		count  int64
+	}
+	var counts []methodCount
+	for method, count := range methods {
+		counts = append(counts, methodCount{method, count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count == counts[j].count {
+			return counts[i].method < counts[j].method
+		}
+		return counts[i].count > counts[j].count
+	})
+	fmt.Fprintf(w, "methods: %d\n", len(counts))
+	for _, c := range counts {
+		fmt.Fprintf(w, "  %-40s %d\n", c.method, c.count)
+	}
+	fmt.Fprintf(w, "errors: %d\n", len(errs))
+	for _, le := range errs {
+		method := le.method
+		if method == "" {
+			method = "unknown method"
+		}
+		fmt.Fprintf(w, "  %s #%d %s: %s\n", le.e.Time.Format(textTimeLayout), le.id, method, le.msg)
+	}
+	return nil
+}
+
+// truncatedMethodRE and truncatedIDRE match the method and id of a truncated
+// message.
+var (
+	truncatedMethodRE = regexp.MustCompile(`"method":"([\w.]+)"`)
+	truncatedIDRE     = regexp.MustCompile(`^\{"id":(-?\d+)`)
+)
+
+// truncatedMessage returns the CDP message of a truncated message, with only
+// its method and id.
+func truncatedMessage(buf []byte) cdpMessage {
+	var msg cdpMessage
+	if m := truncatedMethodRE.FindSubmatch(buf); m != nil {
+		msg.Method = string(m[1])
+	}
+	if m := truncatedIDRE.FindSubmatch(buf); m != nil {
+		if id, err := strconv.ParseInt(string(m[1]), 10, 64); err == nil {
+			msg.ID = &id
+		}
+	}
+	return msg
+}
+
+// writeLogEntry writes a logged message in the text log format.
+func writeLogEntry(w io.Writer, e *LogEntry) {
+	msg := string(e.Msg)
+	switch {
+	case e.Metadata:
+		tag := e.Method
+		if e.ID != nil {
+			tag = strings.TrimSpace(tag + " #" + strconv.FormatInt(*e.ID, 10))
+		}
+		msg = sizeField + strconv.Itoa(e.Size)
+		if tag != "" {
+			msg = "[" + tag + "] " + msg
+		}
+	case e.Binary:
+		msg = binaryTag + " " + strconv.Itoa(e.Size) + " bytes"
+	case e.Truncated:
+		msg += "…(truncated, total=" + strconv.Itoa(e.Size) + " bytes)"
+	}
+	fmt.Fprintf(w, "%s %s %s\n", e.Time.Format(textTimeLayout), e.Dir.prefix(), msg)
+}
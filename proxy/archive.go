@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ArchiveHeader is the header of a session archive, written once at the top
+// of the archive.
+type ArchiveHeader struct {
+	// Time is the time the archive was started.
+	Time time.Time `json:"time"`
+	// Remote is the remote address of the first recorded session.
+	Remote string `json:"remote"`
+	// Version is the /json/version output of the remote.
+	Version json.RawMessage `json:"version"`
+}
+
+// ArchiveFrame is a websocket message recorded in a session archive.
+type ArchiveFrame struct {
+	// Time is the time the message was proxied.
+	Time time.Time `json:"time"`
+	// Session is the devtools id of the session.
+	Session string `json:"session"`
+	// Remote is the client address of the session.
+	Remote string `json:"remote"`
+	// Dir is the direction of the message.
+	Dir Direction `json:"dir"`
+	// Binary is true when the message was a binary websocket message.
+	Binary bool `json:"binary,omitempty"`
+	// Data is the message data.
+	Data []byte `json:"data"`
+}
+
+// archive is a session archive being recorded.
+type archive struct {
+	mu       sync.Mutex
+	filename string
+	f        *os.File
+	w        *bufio.Writer
+	enc      *json.Encoder
+	err      error
+}
+
+// start starts the archive, writing the header when the archive has not been
+// started yet.
+func (a *archive) start(remote string, ver []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil || a.err != nil {
+		return
+	}
+	if a.f, a.err = os.Create(a.filename); a.err != nil {
+		return
+	}
+	a.w = bufio.NewWriter(a.f)
+	a.enc = json.NewEncoder(a.w)
+	a.err = a.enc.Encode(ArchiveHeader{
+		Time:    time.Now(),
+		Remote:  remote,
+		Version: json.RawMessage(ver),
+	})
+}
+
+// record records a proxied frame for the session.
+func (a *archive) record(s *session, f *frame) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.enc == nil || a.err != nil {
+		return
+	}
+	a.err = a.enc.Encode(ArchiveFrame{
+		Time:    time.Now(),
+		Session: s.id,
+		Remote:  s.remoteAddr,
+		Dir:     f.dir,
+		Binary:  f.typ == websocket.BinaryMessage,
+		Data:    f.buf,
+	})
+}
+
+// Close flushes and closes the archive.
+func (a *archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.w.Flush()
+	if closeErr := a.f.Close(); err == nil {
+		err = closeErr
+	}
+	a.f, a.enc = nil, nil
+	if a.err != nil {
+		return a.err
+	}
+	return err
+}
+
+// ArchiveReader reads a session archive written by the proxy (see
+// WithRecord).
+type ArchiveReader struct {
+	dec    *json.Decoder
+	header ArchiveHeader
+}
+
+// NewArchiveReader creates a new archive reader, reading the archive's header.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	ar := &ArchiveReader{dec: json.NewDecoder(r)}
+	if err := ar.dec.Decode(&ar.header); err != nil {
+		return nil, fmt.Errorf("invalid archive header: %w", err)
+	}
+	return ar, nil
+}
+
+// Header returns the archive's header.
+func (ar *ArchiveReader) Header() ArchiveHeader {
+	return ar.header
+}
+
+// Next returns the next frame in the archive, or io.EOF when there are no
+// more frames.
+func (ar *ArchiveReader) Next() (*ArchiveFrame, error) {
+	f := new(ArchiveFrame)
+	if err := ar.dec.Decode(f); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid archive frame: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// fetchPaused are the params of a Fetch.requestPaused event.
+type fetchPaused struct {
+	RequestID          string `json:"requestId"`
+	NetworkID          string `json:"networkId"`
+	ResponseStatusCode int    `json:"responseStatusCode"`
+}
+
+// captureBodies starts capturing the response bodies of the session's target
+// into the session's HAR entries, until the context is closed, returning the
+// connection used to capture the bodies. The bodies are captured over a
+// secondary connection to the target, enabling the Fetch domain to pause each
+// response, retrieve its body, and continue it.
+//
+// Returns nil when the bodies cannot be captured.
+func (s *session) captureBodies(ctx context.Context, r *remote, urlpath string) *Conn {
+	if isBrowserPath(urlpath) {
+		s.logf("not capturing response bodies for browser session")
+		return nil
+	}
+	var conn *Conn
+	conn, err := s.p.Dial(ctx, r.name, urlpath, func(ev Event) {
+		if ev.Method != "Fetch.requestPaused" {
+			return
+		}
+		var v fetchPaused
+		if err := json.Unmarshal(ev.Params, &v); err != nil {
+			return
+		}
+		go s.captureBody(ctx, conn, v)
+	})
+	if err != nil {
+		s.logf("could not connect to capture response bodies, got: %v", err)
+		return nil
+	}
+	err = conn.Execute(ctx, "Fetch.enable", map[string]interface{}{
+		"patterns": []map[string]string{{"urlPattern": "*", "requestStage": "Response"}},
+	}, nil)
+	if err != nil {
+		s.logf("could not enable response body capture, got: %v", err)
+		conn.Close()
+		return nil
+	}
+	return conn
+}
+
+// captureBody retrieves the body of a paused response, and continues the
+// response. Redirect and error responses have no body.
+func (s *session) captureBody(ctx context.Context, conn *Conn, v fetchPaused) {
+	// paused requests must always be continued
+	defer func() {
+		_ = conn.Execute(ctx, "Fetch.continueRequest", map[string]string{"requestId": v.RequestID}, nil)
+	}()
+	if v.ResponseStatusCode == 0 || v.NetworkID == "" {
+		return
+	}
+	var res struct {
+		Body          string `json:"body"`
+		Base64Encoded bool   `json:"base64Encoded"`
+	}
+	if err := conn.Execute(ctx, "Fetch.getResponseBody", map[string]string{"requestId": v.RequestID}, &res); err != nil {
+		return
+	}
+	s.har.setBody(v.NetworkID, res.Body, res.Base64Encoded)
+}
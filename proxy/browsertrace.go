@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// browserTraceTimeout is the time to wait for the browser to send the rest of
+// a session's trace once tracing is ended.
+const browserTraceTimeout = 10 * time.Second
+
+// browserTrace captures the browser's trace of a session's target to a file
+// in the proxy's browser trace directory (see WithBrowserTraceDir), in the
+// JSON object format of chrome://tracing and Perfetto. The trace is captured
+// over a secondary connection to the target, so that the client receives
+// none of the Tracing events, and that tracing can be ended after the client
+// has disconnected.
+type browserTrace struct {
+	filename string
+	conn     *Conn
+
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	n   int
+	err error
+
+	once     sync.Once
+	complete chan struct{}
+}
+
+// startBrowserTrace starts tracing the session's target, returning the
+// trace. Returns nil when tracing cannot be started.
+func (s *session) startBrowserTrace(ctx context.Context, r *remote, urlpath string) *browserTrace {
+	dir := s.p.browserTraceDir
+	t := &browserTrace{
+		filename: filepath.Join(dir, CleanLogName(s.id)+"-"+s.stats.start.Format(screencastTimeFormat)+".json"),
+		complete: make(chan struct{}),
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		s.logf("could not create browser trace directory, got: %v", err)
+		return nil
+	}
+	f, err := os.Create(t.filename)
+	if err != nil {
+		s.logf("could not create browser trace file, got: %v", err)
+		return nil
+	}
+	t.f, t.w = f, bufio.NewWriter(f)
+	_, _ = t.w.WriteString(`{"traceEvents":[`)
+	if t.conn, err = s.p.Dial(ctx, r.name, urlpath, t.event); err != nil {
+		s.logf("could not connect to trace the browser, got: %v", err)
+		t.close()
+		return nil
+	}
+	err = t.conn.Execute(ctx, "Tracing.start", map[string]string{"transferMode": "ReportEvents"}, nil)
+	if err != nil {
+		s.logf("could not start tracing the browser, got: %v", err)
+		t.conn.Close()
+		t.close()
+		return nil
+	}
+	s.infof("tracing the browser to %s", t.filename)
+	return t
+}
+
+// event handles an event of the trace's connection, writing the trace events
+// of Tracing.dataCollected events to the file.
+func (t *browserTrace) event(ev Event) {
+	switch ev.Method {
+	case "Tracing.dataCollected":
+		var v struct {
+			Value []json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(ev.Params, &v); err != nil {
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, buf := range v.Value {
+			if t.n != 0 {
+				_ = t.w.WriteByte(',')
+			}
+			if _, err := t.w.Write(buf); err != nil && t.err == nil {
+				t.err = err
+			}
+			t.n++
+		}
+	case "Tracing.tracingComplete":
+		t.once.Do(func() {
+			close(t.complete)
+		})
+	}
+}
+
+// stopBrowserTrace ends tracing the session's target, waiting for the rest of
+// the trace, and closes the trace file.
+func (s *session) stopBrowserTrace(t *browserTrace) {
+	ctx, cancel := context.WithTimeout(context.Background(), browserTraceTimeout)
+	defer cancel()
+	if err := t.conn.Execute(ctx, "Tracing.end", nil, nil); err != nil {
+		s.logf("could not end tracing the browser, the trace may be incomplete: %v", err)
+	} else {
+		select {
+		case <-t.complete:
+		case <-ctx.Done():
+			s.logf("timed out waiting for the browser's trace, the trace may be incomplete")
+		}
+	}
+	t.conn.Close()
+	if err := t.close(); err != nil {
+		s.logf("could not write browser trace file %s, got: %v", t.filename, err)
+		return
+	}
+	s.infof("wrote %d browser trace events to %s", t.n, t.filename)
+}
+
+// close terminates and closes the trace file.
+func (t *browserTrace) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = t.w.WriteString("]}\n")
+	err := t.err
+	if ferr := t.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// errSessionBudget is the error closing a session that exceeded its budget
+// (see WithSessionMaxBytes, WithSessionMaxFrames and
+// WithSessionMaxRemoteBytes).
+var errSessionBudget = errors.New("session budget exceeded")
+
+// The reasons of evictions, by the budget the session exceeded.
+const (
+	evictMaxFrames      = "max-frames"
+	evictMaxBytes       = "max-bytes"
+	evictMaxRemoteBytes = "max-remote-bytes"
+)
+
+// maxEvictions is the number of recent evictions kept for the admin
+// evictions endpoint.
+const maxEvictions = 100
+
+// sessionBudget are the messages and bytes a session's client sent to the
+// remote, and the bytes its remote sent to the client, counted against the
+// proxy's session budgets. Each direction is only counted by the session's
+// goroutine for the direction.
+type sessionBudget struct {
+	frames      int64
+	bytes       int64
+	remoteBytes int64
+}
+
+// hasBudget returns true when the proxy limits the messages or bytes a
+// session's client can send to the remote, or the bytes its remote can send
+// to the client.
+func (p *Proxy) hasBudget() bool {
+	return p.sessionMaxFrames > 0 || p.sessionMaxBytes > 0 || p.remoteMaxBytes > 0
+}
+
+// spend counts a message of n bytes of the direction against the session's
+// budget, closing the session and returning errSessionBudget when the message
+// exceeds the budget. The message is not forwarded.
+func (s *session) spend(dir Direction, n int) error {
+	p := s.p
+	var reason string
+	if dir == Outgoing {
+		s.budget.remoteBytes += int64(n)
+		if p.remoteMaxBytes <= 0 || s.budget.remoteBytes <= p.remoteMaxBytes {
+			return nil
+		}
+		s.logf("remote exceeded the session budget of %d bytes, closing session", p.remoteMaxBytes)
+		reason = evictMaxRemoteBytes
+	} else {
+		s.budget.frames++
+		s.budget.bytes += int64(n)
+		switch {
+		case p.sessionMaxFrames > 0 && s.budget.frames > p.sessionMaxFrames:
+			s.logf("client exceeded the session budget of %d messages, closing session", p.sessionMaxFrames)
+			reason = evictMaxFrames
+		case p.sessionMaxBytes > 0 && s.budget.bytes > p.sessionMaxBytes:
+			s.logf("client exceeded the session budget of %d bytes, closing session", p.sessionMaxBytes)
+			reason = evictMaxBytes
+		default:
+			return nil
+		}
+	}
+	s.evict(reason)
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session budget exceeded")
+	_ = s.out[Outgoing].Load().WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+	return errSessionBudget
+}
+
+// Eviction is a session closed for exceeding its budget (see
+// Proxy.Evictions).
+type Eviction struct {
+	Info
+	// Reason is the budget the session exceeded: max-frames, max-bytes, or
+	// max-remote-bytes.
+	Reason string
+	// Time is the time the session was evicted.
+	Time time.Time
+	// Messages are the number of messages proxied in each direction until the
+	// eviction, indexed by direction.
+	Messages [2]int64
+	// Bytes are the number of message bytes proxied in each direction until
+	// the eviction, indexed by direction.
+	Bytes [2]int64
+}
+
+// evictions are the proxy's recent evictions, oldest first.
+type evictions struct {
+	mu     sync.Mutex
+	recent []Eviction
+}
+
+// evict records the eviction of the session for the reason, counting it in
+// the proxy's metrics, and logging the session's totals.
+func (s *session) evict(reason string) {
+	p := s.p
+	p.active.mu.Lock()
+	info := p.active.sessions[s]
+	p.active.mu.Unlock()
+	st := s.stats.stats()
+	e := Eviction{
+		Info:     info,
+		Reason:   reason,
+		Time:     time.Now(),
+		Messages: st.Messages,
+		Bytes:    st.Bytes,
+	}
+	p.metrics.evicted(reason)
+	p.evictions.mu.Lock()
+	p.evictions.recent = append(p.evictions.recent, e)
+	if n := len(p.evictions.recent); n > maxEvictions {
+		p.evictions.recent = append([]Eviction(nil), p.evictions.recent[n-maxEvictions:]...)
+	}
+	p.evictions.mu.Unlock()
+	s.logf("evicted session: reason=%s id=%s conn=%s remoteAddr=%s messagesIn=%d bytesIn=%d messagesOut=%d bytesOut=%d",
+		reason, info.ID, info.Conn, info.RemoteAddr,
+		st.Messages[Incoming], st.Bytes[Incoming], st.Messages[Outgoing], st.Bytes[Outgoing])
+}
+
+// Evictions returns the proxy's most recent evictions of sessions exceeding
+// their budgets, oldest first.
+func (p *Proxy) Evictions() []Eviction {
+	p.evictions.mu.Lock()
+	defer p.evictions.mu.Unlock()
+	return append([]Eviction(nil), p.evictions.recent...)
+}
+
+// evictionResponse is the json of an eviction, as served by the admin
+// evictions endpoint.
+type evictionResponse struct {
+	ID          string    `json:"id"`
+	Path        string    `json:"path"`
+	Remote      string    `json:"remote,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	Start       time.Time `json:"start"`
+	Conn        string    `json:"conn"`
+	Reason      string    `json:"reason"`
+	Time        time.Time `json:"time"`
+	MessagesIn  int64     `json:"messagesIn"`
+	MessagesOut int64     `json:"messagesOut"`
+	BytesIn     int64     `json:"bytesIn"`
+	BytesOut    int64     `json:"bytesOut"`
+}
+
+// serveEvictions serves the proxy's recent evictions as a json array (see
+// WithAdmin).
+func (p *Proxy) serveEvictions(res http.ResponseWriter, req *http.Request) {
+	evictions := p.Evictions()
+	v := make([]evictionResponse, 0, len(evictions))
+	for _, e := range evictions {
+		v = append(v, evictionResponse{
+			ID:          e.ID,
+			Path:        e.Path,
+			Remote:      e.Remote,
+			RemoteAddr:  e.RemoteAddr,
+			Start:       e.Start,
+			Conn:        e.Conn,
+			Reason:      e.Reason,
+			Time:        e.Time,
+			MessagesIn:  e.Messages[Incoming],
+			MessagesOut: e.Messages[Outgoing],
+			BytesIn:     e.Bytes[Incoming],
+			BytesOut:    e.Bytes[Outgoing],
+		})
+	}
+	res.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	enc := json.NewEncoder(res)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sessionErrors are the error responses the remote sent to a session, by the
+// method of their commands.
+type sessionErrors struct {
+	mu      sync.Mutex
+	total   int64
+	methods map[string]int64
+}
+
+// recordError logs the frame when it is an error response, with the method of
+// its command, and counts the error for the session's summary. Error
+// responses of logged sessions are logged regardless of the proxy's method
+// filters (see WithInclude and WithExclude), so that failures stand out of
+// large captures. When only logging metadata (see WithMetadataOnly), the
+// error's message is not logged.
+func (s *session) recordError(f *frame) {
+	msg := f.message()
+	if msg.ID == nil || msg.Method != "" || msg.Error == nil {
+		return
+	}
+	method := s.pending.method(f)
+	if method == "" {
+		// the command was not tracked
+		method = "unknown"
+	}
+	var cerr cdpError
+	_ = json.Unmarshal(msg.Error, &cerr)
+	tag := fmt.Sprintf("%s #%d", method, *msg.ID)
+	if id := msg.cdpSession(); id != "" {
+		tag += " @" + id
+	}
+	if s.p.metadataOnly {
+		s.logf("ERROR [%s]: code %d", tag, cerr.Code)
+	} else {
+		s.logf("ERROR [%s]: %s (%d)", tag, cerr.Message, cerr.Code)
+	}
+	e := &s.errs
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.methods == nil {
+		e.methods = make(map[string]int64)
+	}
+	e.total++
+	e.methods[method]++
+}
+
+// errorSummary returns the summary line of the session's error responses, by
+// the methods that produced them, when the remote sent any.
+func (s *session) errorSummary() []string {
+	e := &s.errs
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.total == 0 {
+		return nil
+	}
+	methods := make([]string, 0, len(e.methods))
+	for method := range e.methods {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		if e.methods[methods[i]] == e.methods[methods[j]] {
+			return methods[i] < methods[j]
+		}
+		return e.methods[methods[i]] > e.methods[methods[j]]
+	})
+	var counts []string
+	for _, method := range methods {
+		counts = append(counts, fmt.Sprintf("%s=%d", method, e.methods[method]))
+	}
+	return []string{fmt.Sprintf("summary: error responses: %d (%s)", e.total, strings.Join(counts, " "))}
+}
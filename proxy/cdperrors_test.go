@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestRecordError(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		exp  []string
+		not  []string
+	}{
+		{
+			"default", nil,
+			[]string{"ERROR [Page.navigate #1]: SECRET payload text (-32000)", "summary: error responses: 1 (Page.navigate=1)"},
+			nil,
+		},
+		{
+			"excluded method", []Option{WithExclude("Page.*")},
+			[]string{"ERROR [Page.navigate #1]: SECRET payload text (-32000)", "summary: error responses: 1 (Page.navigate=1)"},
+			nil,
+		},
+		{
+			"metadata only", []Option{WithMetadataOnly(true)},
+			[]string{"ERROR [Page.navigate #1]: code -32000", "summary: error responses: 1 (Page.navigate=1)"},
+			[]string{"SECRET"},
+		},
+		{
+			"excluded session", []Option{WithLogSessions("P9")},
+			[]string{"(not logged)"},
+			[]string{"ERROR", "SECRET", "error responses"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := fakeremote.New()
+			defer remote.Close()
+			remote.Reply = func(string, []byte) [][]byte {
+				return [][]byte{[]byte(`{"id":1,"error":{"code":-32000,"message":"SECRET payload text"}}`)}
+			}
+			p, stdout := startProxy(t, remote, test.opts...)
+			c := dialPage(t, p, "P1")
+			roundTrip(t, c, `{"id":1,"method":"Page.navigate","params":{"url":"https://example.com/"}}`)
+			closeClient(t, c)
+			log := waitLog(t, stdout, "closing")
+			for _, s := range test.exp {
+				if !strings.Contains(log, s) {
+					t.Errorf("expected the log to contain %q, got:\n%s", s, log)
+				}
+			}
+			for _, s := range test.not {
+				if strings.Contains(log, s) {
+					t.Errorf("expected the log not to contain %q, got:\n%s", s, log)
+				}
+			}
+		})
+	}
+}
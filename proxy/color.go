@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// ANSI color escape sequences.
+const (
+	colorReset   = "\x1b[0m"
+	colorGreen   = "\x1b[32m"
+	colorCyan    = "\x1b[36m"
+	colorYellow  = "\x1b[33m"
+	colorMagenta = "\x1b[35m"
+)
+
+// colorWriter is a writer that colorizes the direction prefix, and the CDP
+// method and id fields, of the text log lines written to it.
+type colorWriter struct {
+	w io.Writer
+}
+
+// colorLineRE matches the timestamp, run id and session id tags, and
+// direction prefix of a text log line.
+var colorLineRE = regexp.MustCompile(`^((?:\d{4}/\d\d/\d\d \d\d:\d\d:\d\d(?:\.\d+)? )?(?:\[[^\] ]+\] )*)(<-|->) `)
+
+// colorFieldRE matches the CDP method and id fields of a message.
+var colorFieldRE = regexp.MustCompile(`("method":\s*"[^"]*")|("id":\s*\d+)`)
+
+// Write satisfies the io.Writer interface.
+func (cw colorWriter) Write(buf []byte) (int, error) {
+	m := colorLineRE.FindSubmatchIndex(buf)
+	if m == nil {
+		return cw.w.Write(buf)
+	}
+	color := colorGreen
+	if string(buf[m[4]:m[5]]) == Outgoing.prefix() {
+		color = colorCyan
+	}
+	var b bytes.Buffer
+	b.Write(buf[:m[4]])
+	b.WriteString(color)
+	b.Write(buf[m[4]:m[5]])
+	b.WriteString(colorReset)
+	b.Write(colorFieldRE.ReplaceAllFunc(buf[m[5]:], func(field []byte) []byte {
+		color := colorYellow
+		if bytes.HasPrefix(field, []byte(`"id"`)) {
+			color = colorMagenta
+		}
+		return append(append([]byte(color), field...), colorReset...)
+	}))
+	if _, err := cw.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
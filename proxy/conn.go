@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is a minimal CDP client connection to a remote target, opened by the
+// proxy alongside (and independently of) the proxied sessions. The browser
+// allows multiple clients per target, so a Conn can be used to issue commands
+// to the target of a proxied session (ie, from a ConnectHook) without
+// interfering with the client's own message ids.
+//
+// The messages of a Conn are not logged or recorded.
+type Conn struct {
+	c       *websocket.Conn
+	onEvent func(Event)
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	id      int64
+	pending map[int64]chan connResponse
+	err     error
+	done    chan struct{}
+}
+
+// Event is a CDP event received on a Conn.
+type Event struct {
+	// Method is the event's method (ie, Network.requestWillBeSent).
+	Method string `json:"method"`
+	// SessionID is the id of the flattened target session the event was sent
+	// for, empty for the connection's own target.
+	SessionID string `json:"sessionId"`
+	// Params are the event's raw params.
+	Params json.RawMessage `json:"params"`
+}
+
+// connMessage is a message received on a Conn.
+type connMessage struct {
+	ID     *int64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *cdpError       `json:"error"`
+	Event
+}
+
+// connResponse is a command response received on a Conn.
+type connResponse struct {
+	result json.RawMessage
+	err    error
+}
+
+// Error satisfies the error interface.
+func (e *cdpError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// Dial opens a CDP connection to the target for the devtools path (ie,
+// /devtools/page/<id>) on the named remote (empty for the default remote).
+//
+// The onEvent func, when not nil, is called for each event received on the
+// connection. It is called from the connection's read loop, so it must not
+// block, and must not call Execute directly (ie, call Execute in a goroutine
+// instead).
+func (p *Proxy) Dial(ctx context.Context, name, urlpath string, onEvent func(Event)) (*Conn, error) {
+	var r *remote
+	for _, v := range p.remotes {
+		if v.name == name {
+			r = v
+		}
+	}
+	if r == nil {
+		return nil, fmt.Errorf("unknown remote %q", name)
+	}
+	c, res, err := p.dialer.DialContext(ctx, p.backend(ctx, r).url(true, urlpath).String(), nil)
+	if err != nil {
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, err
+	}
+	res.Body.Close()
+	conn := &Conn{
+		c:       c,
+		onEvent: onEvent,
+		pending: make(map[int64]chan connResponse),
+		done:    make(chan struct{}),
+	}
+	go conn.run()
+	return conn, nil
+}
+
+// run reads the messages on the connection, until the connection is closed.
+func (c *Conn) run() {
+	defer close(c.done)
+	for {
+		_, buf, err := c.c.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.err = err
+			for _, ch := range c.pending {
+				ch <- connResponse{err: err}
+			}
+			c.pending = nil
+			return
+		}
+		var msg connMessage
+		if err := json.Unmarshal(buf, &msg); err != nil {
+			continue
+		}
+		if msg.ID == nil {
+			if c.onEvent != nil && msg.Method != "" {
+				c.onEvent(msg.Event)
+			}
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[*msg.ID]
+		delete(c.pending, *msg.ID)
+		c.mu.Unlock()
+		if ch == nil {
+			continue
+		}
+		if msg.Error != nil {
+			ch <- connResponse{err: msg.Error}
+		} else {
+			ch <- connResponse{result: msg.Result}
+		}
+	}
+}
+
+// Execute sends a command with the params to the target, waiting for its
+// response and unmarshaling the result into res (when not nil). A CDP error
+// response is returned as an error.
+func (c *Conn) Execute(ctx context.Context, method string, params, res interface{}) error {
+	if params == nil {
+		params = struct{}{}
+	}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.id++
+	id, ch := c.id, make(chan connResponse, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	buf, err := json.Marshal(struct {
+		ID     int64       `json:"id"`
+		Method string      `json:"method"`
+		Params interface{} `json:"params"`
+	}{id, method, params})
+	if err == nil {
+		c.writeMu.Lock()
+		err = c.c.WriteMessage(websocket.TextMessage, buf)
+		c.writeMu.Unlock()
+	}
+	if err != nil {
+		c.forget(id)
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		c.forget(id)
+		return ctx.Err()
+	case r := <-ch:
+		if r.err != nil || res == nil {
+			return r.err
+		}
+		return json.Unmarshal(r.result, res)
+	}
+}
+
+// forget removes a pending command.
+func (c *Conn) forget(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.writeMu.Lock()
+	_ = c.c.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(closeTimeout),
+	)
+	c.writeMu.Unlock()
+	select {
+	case <-c.done:
+	case <-time.After(closeTimeout):
+	}
+	err := c.c.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
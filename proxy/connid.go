@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// connInfo identifies a client's TCP connection to the proxy, for correlating
+// the proxy's logs with packet captures and the remote's logs.
+type connInfo struct {
+	// id is the connection's ULID
+	id string
+	// accepted is the time the connection was accepted
+	accepted time.Time
+}
+
+// connInfoKey is the context key of the connection info of a request.
+type connInfoKey struct{}
+
+// connContext returns the context of a connection accepted by the proxy's
+// server, with the connection's info.
+func connContext(ctx context.Context, _ net.Conn) context.Context {
+	now := time.Now()
+	return context.WithValue(ctx, connInfoKey{}, connInfo{id: newULID(now), accepted: now})
+}
+
+// requestConn returns the info of the request's connection. Requests not
+// served by the proxy's server (ie, when using the proxy's handler directly)
+// are given a new connection info.
+func requestConn(req *http.Request) connInfo {
+	if c, ok := req.Context().Value(connInfoKey{}).(connInfo); ok {
+		return c
+	}
+	now := time.Now()
+	return connInfo{id: newULID(now), accepted: now}
+}
+
+// ulidEncoding is the Crockford base32 alphabet of ULIDs.
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a new ULID (see https://github.com/ulid/spec) for the time:
+// a 48 bit millisecond timestamp followed by 80 random bits, encoded as 26
+// characters sorting by time.
+func newULID(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i], ms = byte(ms), ms>>8
+	}
+	_, _ = rand.Read(b[6:])
+	// encode the 128 bits as 26 5 bit groups, the first group holding the
+	// top 3 bits
+	var s [26]byte
+	var acc uint32
+	bits, j := 2, 0
+	for _, c := range b {
+		acc, bits = acc<<8|uint32(c), bits+8
+		for bits >= 5 {
+			bits -= 5
+			s[j], j = ulidEncoding[acc>>uint(bits)&31], j+1
+		}
+	}
+	return string(s[:])
+}
+
+// logConn logs the session's client connection, with the addresses of both
+// ends, and the time it was accepted.
+func (s *session) logConn(req *http.Request) {
+	local := "unknown"
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		local = addr.String()
+	}
+	s.infof("connection %s (%s -> %s) accepted at %s", s.conn.id, req.RemoteAddr, local, s.conn.accepted.UTC().Format(time.RFC3339Nano))
+}
@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consoleTimeFormat is the format of the times of the console log lines.
+const consoleTimeFormat = "2006/01/02 15:04:05.000"
+
+// consoleSession writes the console messages and exceptions of a session to a
+// console log file in the proxy's console directory (see WithConsoleDir). The
+// file is only created once the session has a console message.
+type consoleSession struct {
+	filename string
+
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	err error
+}
+
+// newConsoleSession creates a new console session, writing to the file.
+func newConsoleSession(filename string) *consoleSession {
+	return &consoleSession{filename: filename}
+}
+
+// remoteObject is the subset of a CDP Runtime.RemoteObject used by the console
+// log.
+type remoteObject struct {
+	Type                string          `json:"type"`
+	Subtype             string          `json:"subtype"`
+	Value               json.RawMessage `json:"value"`
+	UnserializableValue string          `json:"unserializableValue"`
+	Description         string          `json:"description"`
+	Preview             *objectPreview  `json:"preview"`
+}
+
+// objectPreview is the subset of a CDP Runtime.ObjectPreview used by the
+// console log.
+type objectPreview struct {
+	Subtype    string `json:"subtype"`
+	Overflow   bool   `json:"overflow"`
+	Properties []struct {
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"properties"`
+}
+
+// stackTrace is the subset of a CDP Runtime.StackTrace used by the console
+// log.
+type stackTrace struct {
+	CallFrames []struct {
+		FunctionName string `json:"functionName"`
+		URL          string `json:"url"`
+		LineNumber   int    `json:"lineNumber"`
+		ColumnNumber int    `json:"columnNumber"`
+	} `json:"callFrames"`
+}
+
+// location returns the location of the stack trace's top call frame (ie,
+// "https://example.com/app.js:10:5"), or "".
+func (st *stackTrace) location() string {
+	if st == nil || len(st.CallFrames) == 0 || st.CallFrames[0].URL == "" {
+		return ""
+	}
+	cf := st.CallFrames[0]
+	// line and column numbers are 0-based
+	return cf.URL + ":" + strconv.Itoa(cf.LineNumber+1) + ":" + strconv.Itoa(cf.ColumnNumber+1)
+}
+
+// consoleMessage is the subset of the Runtime.consoleAPICalled,
+// Runtime.exceptionThrown and Log.entryAdded events used by the console log.
+type consoleMessage struct {
+	Params struct {
+		// Runtime.consoleAPICalled
+		Type       string         `json:"type"`
+		Args       []remoteObject `json:"args"`
+		StackTrace *stackTrace    `json:"stackTrace"`
+		// Runtime.consoleAPICalled and Runtime.exceptionThrown
+		Timestamp float64 `json:"timestamp"`
+		// Runtime.exceptionThrown
+		ExceptionDetails struct {
+			Text         string        `json:"text"`
+			URL          string        `json:"url"`
+			LineNumber   int           `json:"lineNumber"`
+			ColumnNumber int           `json:"columnNumber"`
+			Exception    *remoteObject `json:"exception"`
+			StackTrace   *stackTrace   `json:"stackTrace"`
+		} `json:"exceptionDetails"`
+		// Log.entryAdded
+		Entry struct {
+			Source     string      `json:"source"`
+			Level      string      `json:"level"`
+			Text       string      `json:"text"`
+			URL        string      `json:"url"`
+			LineNumber *int        `json:"lineNumber"`
+			Timestamp  float64     `json:"timestamp"`
+			StackTrace *stackTrace `json:"stackTrace"`
+		} `json:"entry"`
+	} `json:"params"`
+}
+
+// record writes the console message of a Runtime.consoleAPICalled,
+// Runtime.exceptionThrown or Log.entryAdded event to the console log.
+func (c *consoleSession) record(s *session, f *frame) {
+	if f.dir != Outgoing {
+		return
+	}
+	msg := f.message()
+	switch msg.Method {
+	case "Runtime.consoleAPICalled", "Runtime.exceptionThrown", "Log.entryAdded":
+	default:
+		return
+	}
+	var cm consoleMessage
+	if err := json.Unmarshal(f.buf, &cm); err != nil {
+		return
+	}
+	var ts float64
+	var line string
+	switch p := cm.Params; msg.Method {
+	case "Runtime.consoleAPICalled":
+		ts, line = p.Timestamp, "console."+p.Type+": "+formatConsoleArgs(p.Args)
+		if loc := p.StackTrace.location(); loc != "" {
+			line += " (" + loc + ")"
+		}
+	case "Runtime.exceptionThrown":
+		d := p.ExceptionDetails
+		text := d.Text
+		// the exception's description has its message and stack
+		if d.Exception != nil && d.Exception.Description != "" {
+			text += " " + d.Exception.Description
+		} else if d.Exception != nil {
+			text += " " + formatRemoteObject(*d.Exception)
+		}
+		ts, line = p.Timestamp, "exception: "+text
+		if loc := d.StackTrace.location(); loc != "" {
+			line += " (" + loc + ")"
+		} else if d.URL != "" {
+			line += " (" + d.URL + ":" + strconv.Itoa(d.LineNumber+1) + ":" + strconv.Itoa(d.ColumnNumber+1) + ")"
+		}
+	case "Log.entryAdded":
+		e := p.Entry
+		ts, line = e.Timestamp, "log."+e.Level+" ["+e.Source+"]: "+e.Text
+		switch loc := e.StackTrace.location(); {
+		case loc != "":
+			line += " (" + loc + ")"
+		case e.URL != "" && e.LineNumber != nil:
+			line += " (" + e.URL + ":" + strconv.Itoa(*e.LineNumber+1) + ")"
+		case e.URL != "":
+			line += " (" + e.URL + ")"
+		}
+	}
+	t := time.Now()
+	if ts > 0 {
+		// timestamps are in milliseconds since the epoch
+		t = time.UnixMicro(int64(ts * 1000))
+	}
+	if id := msg.cdpSession(); id != "" {
+		line = "[" + id + "] " + line
+	}
+	c.write(s, t.Format(consoleTimeFormat)+" "+line)
+}
+
+// write writes a line to the console log, creating the file on first use.
+func (c *consoleSession) write(s *session, line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if c.f == nil {
+		if c.err = os.MkdirAll(filepath.Dir(c.filename), 0o755); c.err == nil {
+			c.f, c.err = os.Create(c.filename)
+		}
+		if c.err != nil {
+			s.logf("could not create console log file, got: %v", c.err)
+			return
+		}
+		c.w = bufio.NewWriter(c.f)
+		s.infof("logging console messages to %s", c.filename)
+	}
+	_, _ = c.w.WriteString(strings.TrimRight(line, "\n") + "\n")
+}
+
+// close flushes and closes the console log file, if any.
+func (c *consoleSession) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return
+	}
+	_ = c.w.Flush()
+	_ = c.f.Close()
+	c.f = nil
+	c.err = os.ErrClosed
+}
+
+// formatConsoleArgs formats the arguments of a console API call as plain
+// text, applying the format specifiers of the first argument (ie, %s or %d),
+// as the browser's console does.
+func formatConsoleArgs(args []remoteObject) string {
+	if len(args) == 0 {
+		return ""
+	}
+	var parts []string
+	rest := args
+	if args[0].Type == "string" && strings.Contains(formatRemoteObject(args[0]), "%") {
+		var s string
+		s, rest = formatSpecifiers(formatRemoteObject(args[0]), args[1:])
+		parts = append(parts, s)
+	}
+	for _, arg := range rest {
+		parts = append(parts, formatRemoteObject(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatSpecifiers applies the format specifiers of the format to the args,
+// returning the formatted string and the args left.
+func formatSpecifiers(format string, args []remoteObject) (string, []remoteObject) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			b.WriteByte(format[i])
+			continue
+		}
+		switch c := format[i+1]; c {
+		case '%':
+			b.WriteByte('%')
+		case 's', 'd', 'i', 'f', 'o', 'O', 'c':
+			if len(args) == 0 {
+				b.WriteByte('%')
+				b.WriteByte(c)
+				break
+			}
+			// %c applies css styles, which have no plain text
+			if c != 'c' {
+				b.WriteString(formatRemoteObject(args[0]))
+			}
+			args = args[1:]
+		default:
+			b.WriteByte('%')
+			b.WriteByte(c)
+		}
+		i++
+	}
+	return b.String(), args
+}
+
+// formatRemoteObject formats a remote object as plain text: strings as is,
+// primitives by their value, and objects by their preview or description.
+func formatRemoteObject(o remoteObject) string {
+	switch {
+	case o.Type == "string":
+		var s string
+		if json.Unmarshal(o.Value, &s) == nil {
+			return s
+		}
+	case o.Type == "undefined":
+		return "undefined"
+	case o.UnserializableValue != "":
+		return o.UnserializableValue
+	case o.Subtype == "null":
+		return "null"
+	case o.Type != "object" && o.Type != "function" && len(o.Value) != 0:
+		return string(o.Value)
+	case o.Preview != nil && (o.Subtype == "" || o.Subtype == "array"):
+		return formatPreview(o.Preview)
+	case o.Description != "":
+		return o.Description
+	case len(o.Value) != 0:
+		return string(o.Value)
+	}
+	return o.Type
+}
+
+// formatPreview formats the preview of an object or array (ie, {a: 1, b: "x"}
+// or [1, 2, 3]).
+func formatPreview(p *objectPreview) string {
+	var parts []string
+	for _, prop := range p.Properties {
+		v := prop.Value
+		if prop.Type == "string" {
+			v = strconv.Quote(v)
+		}
+		if p.Subtype == "array" {
+			parts = append(parts, v)
+		} else {
+			parts = append(parts, prop.Name+": "+v)
+		}
+	}
+	if p.Overflow {
+		parts = append(parts, "...")
+	}
+	if p.Subtype == "array" {
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// consoleFilename returns the name of the session's console log file, named by
+// its devtools id and start time.
+func (s *session) consoleFilename() string {
+	return filepath.Join(s.p.consoleDir, fmt.Sprintf("%s-%s.console.log", CleanLogName(s.id), s.stats.start.Format(screencastTimeFormat)))
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// dbDriver is the database/sql driver of the capture database, registered
+	// when the proxy is built with the sqlite build tag.
+	dbDriver = "sqlite3"
+	// dbBuffer is the number of messages buffered for the capture database.
+	// Messages proxied while the buffer is full are dropped.
+	dbBuffer = 4096
+	// dbBatch is the maximum number of messages inserted per transaction.
+	dbBatch = 512
+	// dbFlush is the maximum time a message is held before its transaction
+	// is committed.
+	dbFlush = 200 * time.Millisecond
+	// dbTimeout is the timeout of the final inserts when the proxy is closed.
+	dbTimeout = 10 * time.Second
+)
+
+// dbSchema creates the capture database's frames table, when needed.
+const dbSchema = `CREATE TABLE IF NOT EXISTS frames (
+	session_id TEXT NOT NULL,
+	conn TEXT NOT NULL,
+	ts INTEGER NOT NULL,
+	direction TEXT NOT NULL,
+	method TEXT,
+	id INTEGER,
+	cdp_session TEXT,
+	size INTEGER NOT NULL,
+	payload BLOB
+);
+CREATE INDEX IF NOT EXISTS frames_session_id ON frames (session_id, conn, id);
+CREATE INDEX IF NOT EXISTS frames_method ON frames (method);`
+
+// dbInsert inserts a frame in the capture database.
+const dbInsert = `INSERT INTO frames (session_id, conn, ts, direction, method, id, cdp_session, size, payload)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// dbFrame is a proxied message to insert in the capture database.
+type dbFrame struct {
+	session    string
+	conn       string
+	ts         int64
+	dir        Direction
+	method     string
+	id         *int64
+	cdpSession string
+	binary     bool
+	buf        []byte
+}
+
+// captureDB inserts the proxied messages of all sessions in a SQLite database
+// (see WithDB). Messages are buffered and inserted by a single writer
+// goroutine in batched transactions, so that the database never delays the
+// proxied messages: messages are dropped for the database instead.
+type captureDB struct {
+	filename string
+	db       *sql.DB
+	// err is the error opening the database, returned by Serve
+	err     error
+	frames  chan dbFrame
+	dropped atomic.Int64
+	once    sync.Once
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// newCaptureDB opens the capture database file, creating its frames table,
+// and starts its writer.
+func newCaptureDB(filename string) *captureDB {
+	d := &captureDB{
+		filename: filename,
+		frames:   make(chan dbFrame, dbBuffer),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if d.err = d.open(); d.err != nil {
+		close(d.done)
+		return d
+	}
+	go d.run()
+	return d
+}
+
+// open opens the capture database, creating its frames table.
+func (d *captureDB) open() error {
+	if !slices.Contains(sql.Drivers(), dbDriver) {
+		return errors.New("capturing to a database requires the proxy to be built with the sqlite build tag (go build -tags sqlite)")
+	}
+	db, err := sql.Open(dbDriver, d.filename)
+	if err != nil {
+		return fmt.Errorf("could not open database %s: %w", d.filename, err)
+	}
+	// sqlite only has a single writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(dbSchema); err != nil {
+		db.Close()
+		return fmt.Errorf("could not create the frames table of database %s: %w", d.filename, err)
+	}
+	d.db = db
+	return nil
+}
+
+// record queues a proxied frame of the session for the database, without
+// blocking.
+func (d *captureDB) record(s *session, f *frame) {
+	if d.err != nil {
+		return
+	}
+	msg := f.message()
+	frame := dbFrame{
+		session:    s.id,
+		conn:       s.conn.id,
+		ts:         time.Now().UnixMicro(),
+		dir:        f.dir,
+		method:     msg.Method,
+		id:         msg.ID,
+		cdpSession: msg.cdpSession(),
+		binary:     f.typ == websocket.BinaryMessage,
+		buf:        f.buf,
+	}
+	select {
+	case d.frames <- frame:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// run inserts the queued frames in batched transactions, until the database
+// is closed.
+func (d *captureDB) run() {
+	defer close(d.done)
+	defer d.db.Close()
+	var batch []dbFrame
+	t := time.NewTimer(dbFlush)
+	defer t.Stop()
+	for {
+		select {
+		case f := <-d.frames:
+			if len(batch) == 0 {
+				t.Reset(dbFlush)
+			}
+			if batch = append(batch, f); len(batch) >= dbBatch {
+				batch = d.insert(batch)
+			}
+		case <-t.C:
+			batch = d.insert(batch)
+		case <-d.quit:
+			for {
+				select {
+				case f := <-d.frames:
+					if batch = append(batch, f); len(batch) >= dbBatch {
+						batch = d.insert(batch)
+					}
+				default:
+					d.insert(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// insert inserts the batch of frames in a single transaction, returning the
+// emptied batch. Frames that could not be inserted are dropped.
+func (d *captureDB) insert(batch []dbFrame) []dbFrame {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := d.insertTx(batch); err != nil {
+		log.Printf("could not insert %d messages in database %s: %v", len(batch), d.filename, err)
+	}
+	if n := d.dropped.Swap(0); n != 0 {
+		log.Printf("dropped %d messages for database %s, as inserts fell behind", n, d.filename)
+	}
+	return batch[:0]
+}
+
+// insertTx inserts the frames in a transaction.
+func (d *captureDB) insertTx(frames []dbFrame) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(dbInsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, f := range frames {
+		var method, cdpSession sql.NullString
+		if f.method != "" {
+			method = sql.NullString{String: f.method, Valid: true}
+		}
+		if f.cdpSession != "" {
+			cdpSession = sql.NullString{String: f.cdpSession, Valid: true}
+		}
+		var id sql.NullInt64
+		if f.id != nil {
+			id = sql.NullInt64{Int64: *f.id, Valid: true}
+		}
+		// text messages are stored as text, to be queried with the json
+		// functions
+		var payload any = string(f.buf)
+		if f.binary {
+			payload = f.buf
+		}
+		if _, err := stmt.Exec(f.session, f.conn, f.ts, f.dir.String(), method, id, cdpSession, len(f.buf), payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close inserts the queued frames, and closes the database.
+func (d *captureDB) Close() error {
+	if d.err != nil {
+		return nil
+	}
+	d.once.Do(func() {
+		close(d.quit)
+	})
+	select {
+	case <-d.done:
+		return nil
+	case <-time.After(dbTimeout):
+		return fmt.Errorf("timed out inserting messages in database %s", d.filename)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package proxy
+
+// the sqlite driver needs cgo, so it is only built in with the sqlite build
+// tag (see WithDB)
+import _ "github.com/mattn/go-sqlite3"
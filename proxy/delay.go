@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"math/rand/v2"
+	"path"
+	"time"
+)
+
+// methodRule matches the messages of the directions with a CDP method
+// matching the glob, for the rules injecting faults (see WithInjectDelay and
+// WithDropRate). A rule without directions matches both directions.
+type methodRule struct {
+	glob string
+	dirs []Direction
+}
+
+// String returns the rule as passed to the flags, with the direction prefix.
+func (r methodRule) String() string {
+	if len(r.dirs) == 1 {
+		return r.dirs[0].String() + ":" + r.glob
+	}
+	return r.glob
+}
+
+// match returns true when the rule applies to the messages of the direction
+// with the method.
+func (r methodRule) match(dir Direction, method string) bool {
+	if len(r.dirs) != 0 && !containsDirection(r.dirs, dir) {
+		return false
+	}
+	ok, _ := path.Match(r.glob, method)
+	return ok
+}
+
+// containsDirection returns true when dirs contains the direction.
+func containsDirection(dirs []Direction, dir Direction) bool {
+	for _, d := range dirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleMethod returns the method of the frame matched by the method rules: the
+// message's method, or the method of its command for a response. The method
+// of a response is looked up before its command is no longer pending.
+func (s *session) ruleMethod(f *frame) string {
+	method := f.message().Method
+	if method == "" && f.dir == Outgoing {
+		method = s.pending.method(f)
+	}
+	return method
+}
+
+// delayRule is a delay injected before forwarding the messages matching the
+// rule (see WithInjectDelay).
+type delayRule struct {
+	methodRule
+	min, max time.Duration
+}
+
+// String returns the rule as passed to the -inject-delay flag.
+func (r delayRule) String() string {
+	s := r.methodRule.String() + "=" + r.min.String()
+	if r.max > r.min {
+		s += "-" + r.max.String()
+	}
+	return s
+}
+
+// delay returns the delay to inject before forwarding the frame, by the first
+// of the proxy's delay rules matching the frame's method.
+func (s *session) delay(f *frame) time.Duration {
+	method := s.ruleMethod(f)
+	for _, r := range s.p.delays {
+		if !r.match(f.dir, method) {
+			continue
+		}
+		if r.max <= r.min {
+			return r.min
+		}
+		return r.min + rand.N(r.max-r.min+1)
+	}
+	return 0
+}
+
+// injectDelay waits for the delay injected before forwarding a message (see
+// WithInjectDelay), returning an error when the context is closed first.
+func (s *session) injectDelay(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// detachedEvent is the event sent to the client before the proxy closes a
+// session (see WithDetachOnClose), as sent by the browser when a target is
+// closed.
+var detachedEvent = []byte(`{"method":"Inspector.detached","params":{"reason":"target_closed"}}`)
+
+// detach sends the Inspector.detached event to the client, once per session.
+// The event is not sent once a close frame has been sent to the client.
+func (s *session) detach() {
+	s.detachOnce.Do(func() {
+		s.writeMu[Outgoing].Lock()
+		defer s.writeMu[Outgoing].Unlock()
+		c := s.out[Outgoing].Load()
+		_ = c.SetWriteDeadline(time.Now().Add(closeTimeout))
+		err := c.WriteMessage(websocket.TextMessage, detachedEvent)
+		_ = c.SetWriteDeadline(time.Time{})
+		switch {
+		case errors.Is(err, websocket.ErrCloseSent):
+		case err != nil:
+			s.logf("could not send Inspector.detached to the client: %v", err)
+		default:
+			s.logf("detached client")
+			s.logFrame(&frame{dir: Outgoing, typ: websocket.TextMessage, buf: detachedEvent})
+		}
+	})
+}
+
+// detachSessions sends the Inspector.detached event to the clients of all
+// active sessions.
+func (p *Proxy) detachSessions() {
+	p.active.mu.Lock()
+	defer p.active.mu.Unlock()
+	for s := range p.active.sessions {
+		go s.detach()
+	}
+}
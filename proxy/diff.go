@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// diffLookahead is the number of commands searched ahead for a command
+// missing from the other log, when the logs' commands differ.
+const diffLookahead = 50
+
+// diffIgnoredParams are the params of commands that are not compared, as
+// they are ids or times that vary between runs.
+var diffIgnoredParams = map[string]bool{
+	"backendNodeId":      true,
+	"browserContextId":   true,
+	"contextId":          true,
+	"executionContextId": true,
+	"frameId":            true,
+	"loaderId":           true,
+	"nodeId":             true,
+	"objectId":           true,
+	"requestId":          true,
+	"scriptId":           true,
+	"sessionId":          true,
+	"targetId":           true,
+	"timestamp":          true,
+}
+
+// diffCommand is a client command read from a log, with the outcome of its
+// response.
+type diffCommand struct {
+	e      *LogEntry
+	method string
+	// params are the command's params, without the ignored params, or nil
+	// when not logged
+	params []byte
+	// outcome is the outcome of the command's response (ok, or the error's
+	// message), when logged
+	outcome string
+}
+
+// Diff reads two logs written by the proxy (in either the text or jsonl
+// format) of supposedly identical runs, and writes the first divergence of
+// the logs to w, returning true when the logs diverge. The logs' client
+// commands are aligned in order, and diverge at the first command with a
+// different method or different params, with a different response outcome
+// (success, or the error's message), or that is missing from the other log.
+//
+// The params that are ids or times varying between runs (ie, frameId, or
+// timestamp) are not compared, nor are the events sent by the browser, as
+// their order varies between runs. When the proxy has include or exclude
+// globs (see WithInclude and WithExclude), only the commands matching the
+// globs are compared. The logs are named by their Name method (ie, for an
+// *os.File), or a and b.
+func (p *Proxy) Diff(a, b io.Reader, w io.Writer) (bool, error) {
+	nameA, nameB := diffName(a, "a"), diffName(b, "b")
+	ca, err := p.diffCommands(a)
+	if err != nil {
+		return false, fmt.Errorf("unable to read %s: %w", nameA, err)
+	}
+	cb, err := p.diffCommands(b)
+	if err != nil {
+		return false, fmt.Errorf("unable to read %s: %w", nameB, err)
+	}
+	fmt.Fprintf(w, "commands: %d in %s, %d in %s\n", len(ca), nameA, len(cb), nameB)
+	i, j := 0, 0
+	for ; i < len(ca) && j < len(cb); i, j = i+1, j+1 {
+		x, y := ca[i], cb[j]
+		if x.method != y.method {
+			break
+		}
+		if x.params != nil && y.params != nil && !bytes.Equal(x.params, y.params) {
+			fmt.Fprintf(w, "%s: different params for %s\n", diffAt(i, nameA, j, nameB), x.method)
+			writeDiffCommand(w, nameA, x)
+			writeDiffCommand(w, nameB, y)
+			return true, nil
+		}
+		if x.outcome != "" && y.outcome != "" && x.outcome != y.outcome {
+			fmt.Fprintf(w, "%s: different response to %s\n", diffAt(i, nameA, j, nameB), x.method)
+			fmt.Fprintf(w, "  %s: %s\n  %s: %s\n", nameA, x.outcome, nameB, y.outcome)
+			return true, nil
+		}
+	}
+	switch {
+	case i == len(ca) && j == len(cb):
+		fmt.Fprintf(w, "no divergence\n")
+		return false, nil
+	case i == len(ca):
+		fmt.Fprintf(w, "first divergence after command %d of %s: %s has %d extra commands\n", i, nameA, nameB, len(cb)-j)
+		writeDiffCommand(w, nameB, cb[j])
+		return true, nil
+	case j == len(cb):
+		fmt.Fprintf(w, "first divergence after command %d of %s: %s is missing %d commands\n", j, nameB, nameB, len(ca)-i)
+		writeDiffCommand(w, nameA, ca[i])
+		return true, nil
+	}
+	// tell extra commands from missing ones by the nearest realignment
+	at := diffAt(i, nameA, j, nameB)
+	extra, missing := diffFind(cb[j:], ca[i].method), diffFind(ca[i:], cb[j].method)
+	switch {
+	case extra > 0 && (missing < 0 || extra <= missing):
+		fmt.Fprintf(w, "%s: %s has %d extra commands before %s\n", at, nameB, extra, ca[i].method)
+		writeDiffCommand(w, nameB, cb[j])
+	case missing > 0:
+		fmt.Fprintf(w, "%s: %s is missing %d commands before %s\n", at, nameB, missing, cb[j].method)
+		writeDiffCommand(w, nameA, ca[i])
+	default:
+		fmt.Fprintf(w, "%s: different commands\n", at)
+		writeDiffCommand(w, nameA, ca[i])
+		writeDiffCommand(w, nameB, cb[j])
+	}
+	return true, nil
+}
+
+// diffAt returns the position of a divergence at the commands with the
+// indexes i and j of the named logs.
+func diffAt(i int, nameA string, j int, nameB string) string {
+	return fmt.Sprintf("first divergence at command %d of %s and %d of %s", i+1, nameA, j+1, nameB)
+}
+
+// diffName returns the name of the log, by its Name method, or def.
+func diffName(r io.Reader, def string) string {
+	if n, ok := r.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return def
+}
+
+// diffFind returns the index of the first of the commands with the method,
+// searching up to diffLookahead commands, or -1.
+func diffFind(cmds []diffCommand, method string) int {
+	for i := 0; i < len(cmds) && i < diffLookahead; i++ {
+		if cmds[i].method == method {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeDiffCommand writes the logged command of the named log.
+func writeDiffCommand(w io.Writer, name string, c diffCommand) {
+	msg := c.e.Msg
+	if c.e.Metadata {
+		msg = []byte("[" + c.method + "]")
+	}
+	fmt.Fprintf(w, "  %s: %s\n", name, msg)
+}
+
+// diffCommands reads the client commands of the log, in order, with the
+// outcome of their responses.
+func (p *Proxy) diffCommands(rd io.Reader) ([]diffCommand, error) {
+	var cmds []diffCommand
+	// the index of the logged commands, by session and id
+	index := make(map[string]int)
+	lr := NewLogReader(rd)
+	for {
+		e, err := lr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return cmds, nil
+		case err != nil:
+			return nil, err
+		case !e.IsMessage() || e.Binary:
+			continue
+		}
+		var msg struct {
+			cdpMessage
+			Params json.RawMessage `json:"params"`
+		}
+		if e.Metadata {
+			msg.Method, msg.ID = e.Method, e.ID
+		} else if json.Unmarshal(e.Msg, &msg) != nil {
+			// truncated messages are not valid json
+			msg.cdpMessage = truncatedMessage(e.Msg)
+		}
+		if msg.ID == nil {
+			continue
+		}
+		key := e.Session + "/" + msg.SessionID + "/" + strconv.FormatInt(*msg.ID, 10)
+		switch {
+		case e.Dir == Incoming && msg.Method != "":
+			if p.filter != nil && !p.filter.match(msg.Method) {
+				continue
+			}
+			c := diffCommand{e: e, method: msg.Method}
+			if !e.Metadata && !e.Truncated {
+				c.params = diffParams(msg.Params)
+			}
+			index[key] = len(cmds)
+			cmds = append(cmds, c)
+		case e.Dir == Outgoing && msg.Method == "":
+			n, ok := index[key]
+			if !ok {
+				continue
+			}
+			delete(index, key)
+			switch {
+			case msg.Error != nil:
+				var cerr cdpError
+				_ = json.Unmarshal(msg.Error, &cerr)
+				cmds[n].outcome = "error: " + cerr.Message
+			case !e.Metadata:
+				cmds[n].outcome = "ok"
+			}
+		}
+	}
+}
+
+// diffParams returns the params of a command for comparison, as compact json
+// without the ignored params.
+func diffParams(params json.RawMessage) []byte {
+	var v any
+	if len(params) == 0 || json.Unmarshal(params, &v) != nil {
+		return []byte("{}")
+	}
+	buf, err := json.Marshal(diffStrip(v))
+	if err != nil {
+		return params
+	}
+	return buf
+}
+
+// diffStrip removes the ignored params from the decoded json value.
+func diffStrip(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			if diffIgnoredParams[k] {
+				delete(v, k)
+				continue
+			}
+			v[k] = diffStrip(x)
+		}
+	case []any:
+		for i, x := range v {
+			v[i] = diffStrip(x)
+		}
+	}
+	return v
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"strconv"
+)
+
+// dropRule is the rate of the messages matching the rule that are dropped
+// instead of forwarded (see WithDropRate).
+type dropRule struct {
+	methodRule
+	rate float64
+}
+
+// String returns the rule as passed to the -drop-rate flag.
+func (r dropRule) String() string {
+	return r.methodRule.String() + "=" + strconv.FormatFloat(r.rate, 'g', -1, 64)
+}
+
+// drop drops the frame by the first of the proxy's drop rules matching the
+// frame's method, logging the dropped frame. Returns true when the frame is
+// dropped.
+func (s *session) drop(f *frame) bool {
+	method := s.ruleMethod(f)
+	for _, r := range s.p.drops {
+		if !r.match(f.dir, method) {
+			continue
+		}
+		if rand.Float64() >= r.rate {
+			return false
+		}
+		s.logDropped(f, method)
+		return true
+	}
+	return false
+}
+
+// logDropped logs a message dropped by the drop rules, with the method of its
+// command for a response.
+func (s *session) logDropped(f *frame, method string) {
+	peer := "client"
+	if f.dir == Outgoing {
+		peer = "remote"
+	}
+	tag := f.tag()
+	switch {
+	case tag == "":
+		tag = "(" + strconv.Itoa(len(f.buf)) + " bytes)"
+	case f.message().Method == "" && method != "":
+		tag += " (response to " + method + ")"
+	}
+	s.logf("[chaos] dropped message from the %s: %s", peer, tag)
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// The stages of a devtools session that can fail before the client's
+// websocket is upgraded, as reported in error responses.
+const (
+	stageLimit   = "limit"
+	stageTarget  = "target"
+	stageOrigin  = "origin"
+	stageVersion = "version"
+	stageDial    = "dial"
+)
+
+// errorResponse is the json body of an error response (see WithJSONErrors).
+type errorResponse struct {
+	Error  string `json:"error"`
+	Remote string `json:"remote"`
+	Stage  string `json:"stage"`
+}
+
+// sessionError logs and responds with the error of a devtools session that
+// failed at the stage, as a json body when json errors are enabled.
+func (p *Proxy) sessionError(s *session, res http.ResponseWriter, r *remote, stage string, status int, msg string) {
+	s.logf("%s (stage %s)", msg, stage)
+	s.failed.Store(true)
+	if s.span != nil {
+		s.span.err = msg
+		s.span.str("cdp.stage", stage)
+	}
+	p.writeError(res, r, stage, status, msg)
+}
+
+// writeError responds with the error of a devtools request that failed at the
+// stage, as a json body when json errors are enabled, and as plain text
+// otherwise.
+func (p *Proxy) writeError(res http.ResponseWriter, r *remote, stage string, status int, msg string) {
+	if !p.jsonErrors {
+		http.Error(res, msg+" (stage "+stage+")", status)
+		return
+	}
+	body, err := json.Marshal(errorResponse{Error: msg, Remote: r.host, Stage: stage})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+	res.Write(body)
+}
@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// execBuffer is the number of messages buffered for the exec program in
+	// observer mode. Messages are dropped while the buffer is full.
+	execBuffer = 1024
+	// execTimeout is the time to wait for the exec program's reply to a
+	// message in intercept mode.
+	execTimeout = 5 * time.Second
+)
+
+// errExecExited is the error of messages passed to an exec program that has
+// exited, in intercept mode.
+var errExecExited = errors.New("exec program exited")
+
+// execHook pipes the proxied messages to an external program (see WithExec).
+//
+// Each message is written to the program's stdin as a header line with the
+// message's direction ("in" or "out"), session id and byte length, followed by
+// the message and a newline. In intercept mode, the program must reply to each
+// message on its stdout with a line holding the byte length of the rewritten
+// message, followed by the message and a newline, or with a length of -1 to
+// drop the message.
+type execHook struct {
+	command   []string
+	intercept bool
+
+	once  sync.Once
+	err   error
+	cmd   *exec.Cmd
+	stdin io.Closer
+	w     *bufio.Writer
+	// dead is closed once the program has exited
+	dead chan struct{}
+
+	// msgs are the messages buffered for the program, in observer mode,
+	// closed by Close. sendMu guards the sends on msgs against the close
+	msgs     chan execMessage
+	sendMu   sync.RWMutex
+	closed   bool
+	dropping atomic.Bool
+	// written is closed once run has written (or discarded) the buffered
+	// messages
+	written chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+
+	// mu serializes the messages written to the program in intercept mode,
+	// with their replies read from the replies channel
+	mu      sync.Mutex
+	replies chan execReply
+}
+
+// execMessage is a message written to the exec program.
+type execMessage struct {
+	dir Direction
+	id  string
+	buf []byte
+}
+
+// execReply is the exec program's reply to a message, in intercept mode.
+type execReply struct {
+	buf  []byte
+	drop bool
+	err  error
+}
+
+// newExecHook creates an exec hook for the command, starting the program on
+// first use.
+func newExecHook(command []string, intercept bool) *execHook {
+	return &execHook{command: command, intercept: intercept}
+}
+
+// start starts the program, once.
+func (h *execHook) start() error {
+	h.once.Do(func() {
+		cmd := exec.Command(h.command[0], h.command[1:]...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			h.err = err
+			return
+		}
+		var stdout io.Reader
+		if h.intercept {
+			if stdout, err = cmd.StdoutPipe(); err != nil {
+				h.err = err
+				return
+			}
+		} else {
+			cmd.Stdout = os.Stdout
+		}
+		if err := cmd.Start(); err != nil {
+			h.err = fmt.Errorf("could not start exec program %s: %w", h.command[0], err)
+			return
+		}
+		h.cmd, h.stdin, h.w, h.dead = cmd, stdin, bufio.NewWriter(stdin), make(chan struct{})
+		if h.intercept {
+			h.replies = make(chan execReply)
+			go h.readReplies(bufio.NewReader(stdout))
+		} else {
+			h.msgs, h.written = make(chan execMessage, execBuffer), make(chan struct{})
+			go h.run()
+		}
+		go func() {
+			err := cmd.Wait()
+			log.Printf("exec program %s exited: %v", h.command[0], err)
+			close(h.dead)
+		}()
+	})
+	return h.err
+}
+
+// process passes a message to the program. In observer mode, the message is
+// buffered for the program and returned as is. In intercept mode, the
+// program's rewritten message is returned, or ErrDropMessage when the program
+// dropped the message.
+func (h *execHook) process(s *session, dir Direction, buf []byte) ([]byte, error) {
+	if err := h.start(); err != nil {
+		if h.intercept {
+			return nil, err
+		}
+		return buf, nil
+	}
+	if !h.intercept {
+		h.sendMu.RLock()
+		defer h.sendMu.RUnlock()
+		if h.closed {
+			return buf, nil
+		}
+		select {
+		case h.msgs <- execMessage{dir: dir, id: s.id, buf: buf}:
+			h.dropping.Store(false)
+		default:
+			if !h.dropping.Swap(true) {
+				log.Printf("exec program %s is not keeping up, dropping messages", h.command[0])
+			}
+		}
+		return buf, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.dead:
+		return nil, errExecExited
+	default:
+	}
+	if err := writeExecMessage(h.w, execMessage{dir: dir, id: s.id, buf: buf}); err != nil {
+		return nil, fmt.Errorf("could not write to exec program: %w", err)
+	}
+	t := time.NewTimer(execTimeout)
+	defer t.Stop()
+	select {
+	case r := <-h.replies:
+		switch {
+		case r.err != nil:
+			return nil, r.err
+		case r.drop:
+			return nil, ErrDropMessage
+		}
+		return r.buf, nil
+	case <-h.dead:
+		return nil, errExecExited
+	case <-t.C:
+		// the replies of later messages would be out of step
+		_ = h.cmd.Process.Kill()
+		return nil, fmt.Errorf("no reply from exec program within %v", execTimeout)
+	}
+}
+
+// run writes the buffered messages to the program, in observer mode, until
+// the messages are closed by Close. Once a write fails (ie, the program
+// exited), the later messages are discarded.
+func (h *execHook) run() {
+	defer close(h.written)
+	var failed bool
+	for m := range h.msgs {
+		if failed {
+			continue
+		}
+		if err := writeExecMessage(h.w, m); err != nil {
+			log.Printf("could not write to exec program %s, discarding messages: %v", h.command[0], err)
+			failed = true
+		}
+	}
+}
+
+// readReplies reads the program's replies, in intercept mode, until the
+// program's stdout is closed.
+func (h *execHook) readReplies(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		var reply execReply
+		switch {
+		case err != nil || n < -1:
+			// the replies of later messages would be out of step
+			_ = h.cmd.Process.Kill()
+			reply.err = fmt.Errorf("invalid exec program reply %q", strings.TrimSpace(line))
+		case n == -1:
+			reply.drop = true
+		default:
+			reply.buf = make([]byte, n+1)
+			if _, err := io.ReadFull(r, reply.buf); err != nil {
+				return
+			}
+			reply.buf = reply.buf[:n]
+		}
+		select {
+		case h.replies <- reply:
+		case <-h.dead:
+			return
+		}
+	}
+}
+
+// writeExecMessage writes a message to the program's stdin.
+func writeExecMessage(w *bufio.Writer, m execMessage) error {
+	fmt.Fprintf(w, "%s %s %d\n", m.dir, m.id, len(m.buf))
+	w.Write(m.buf)
+	w.WriteByte('\n')
+	return w.Flush()
+}
+
+// execCloseTimeout is the time to wait for the exec program to exit once its
+// stdin is closed, before killing it.
+const execCloseTimeout = 5 * time.Second
+
+// Close closes the program's stdin once the buffered messages are written to
+// it, waiting for the program to exit, and killing it after a timeout.
+func (h *execHook) Close() error {
+	// keep the program from being started by a later message
+	h.once.Do(func() {})
+	if h.cmd == nil {
+		return nil
+	}
+	h.closeOnce.Do(func() {
+		h.closeErr = h.close()
+	})
+	return h.closeErr
+}
+
+// close closes the program's stdin, once.
+func (h *execHook) close() error {
+	timeout := time.NewTimer(execCloseTimeout)
+	defer timeout.Stop()
+	if h.msgs != nil {
+		h.sendMu.Lock()
+		h.closed = true
+		close(h.msgs)
+		h.sendMu.Unlock()
+		select {
+		case <-h.written:
+		case <-timeout.C:
+			// the program is not reading its stdin
+			_ = h.cmd.Process.Kill()
+			<-h.written
+		}
+	}
+	err := h.stdin.Close()
+	select {
+	case <-h.dead:
+	case <-timeout.C:
+		_ = h.cmd.Process.Kill()
+		<-h.dead
+	}
+	return err
+}
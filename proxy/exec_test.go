@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExecHookClose(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "messages")
+	h := newExecHook([]string{"sh", "-c", "cat > " + name}, false)
+	s := &session{id: "P1"}
+	for _, msg := range []string{`{"id":1}`, `{"id":2}`, `{"id":3}`} {
+		if _, err := h.process(s, Incoming, []byte(msg)); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	// the buffered messages are written before the program's stdin is closed
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	exp := "in P1 8\n{\"id\":1}\nin P1 8\n{\"id\":2}\nin P1 8\n{\"id\":3}\n"
+	if string(buf) != exp {
+		t.Errorf("expected %q, got: %q", exp, buf)
+	}
+	// messages after the close are not sent
+	if _, err := h.process(s, Incoming, []byte(`{"id":4}`)); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
+
+func TestExecHookExited(t *testing.T) {
+	h := newExecHook([]string{"true"}, false)
+	s := &session{id: "P1"}
+	if _, err := h.process(s, Incoming, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	select {
+	case <-h.dead:
+	case <-time.After(testTimeout):
+		t.Fatal("expected the program to exit")
+	}
+	// the messages to the exited program are discarded
+	for i := 0; i < 10; i++ {
+		if _, err := h.process(s, Incoming, []byte(`{"id":2}`)); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+	start := time.Now()
+	_ = h.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the close to not wait for the exited program, took: %v", d)
+	}
+	select {
+	case <-h.written:
+	default:
+		t.Error("expected the messages to be written, or discarded, once closed")
+	}
+}
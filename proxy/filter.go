@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// methodFilter filters CDP messages by method name globs.
+type methodFilter struct {
+	include []string
+	exclude []string
+}
+
+// newMethodFilter creates a method filter from the include and exclude globs.
+// Include globs prefixed with "-" are treated as exclude globs. Returns nil
+// when there are no globs.
+func newMethodFilter(include, exclude []string) *methodFilter {
+	f := new(methodFilter)
+	for _, glob := range include {
+		if strings.HasPrefix(glob, "-") {
+			f.exclude = append(f.exclude, glob[1:])
+		} else if glob != "" {
+			f.include = append(f.include, glob)
+		}
+	}
+	for _, glob := range exclude {
+		if glob = strings.TrimPrefix(glob, "-"); glob != "" {
+			f.exclude = append(f.exclude, glob)
+		}
+	}
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil
+	}
+	return f
+}
+
+// match returns true when the method is matched by the filter. Messages
+// without a method (ie, command responses) only match when there are no
+// include globs.
+func (f *methodFilter) match(method string) bool {
+	if len(f.include) != 0 && !matchGlobs(f.include, method) {
+		return false
+	}
+	return !matchGlobs(f.exclude, method)
+}
+
+// matchGlobs returns true when s matches any of the globs.
+func matchGlobs(globs []string, s string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// logSession returns true when the proxied messages of the session for the
+// devtools path should be logged, based on the proxy's session and target
+// type globs. A session is logged when it matches all of the configured globs,
+// and sessions whose target type cannot be determined are always logged.
+func (p *Proxy) logSession(ctx context.Context, r *remote, urlpath string) (bool, error) {
+	if len(p.logSessions) != 0 && !matchGlobs(p.logSessions, path.Base(urlpath)) {
+		return false, nil
+	}
+	if len(p.logTargetTypes) == 0 {
+		return true, nil
+	}
+	typ, err := p.targetType(ctx, r, urlpath)
+	if err != nil {
+		return true, err
+	}
+	return matchGlobs(p.logTargetTypes, typ), nil
+}
+
+// rootCDPSession is the name the messages of a connection's own session (ie,
+// without a sessionId) match, when only logging the messages of flattened
+// target sessions (see WithLogCDPSessions).
+const rootCDPSession = "root"
+
+// logCDPSession returns true when the frame's message belongs to a flattened
+// target session whose sessionId matches the proxy's CDP session globs.
+// Messages without a sessionId are matched as the root session.
+func (p *Proxy) logCDPSession(f *frame) bool {
+	id := rootCDPSession
+	if f.typ != websocket.BinaryMessage {
+		if sid := f.message().SessionID; sid != "" {
+			id = sid
+		}
+	}
+	return matchGlobs(p.logCDPSessions, id)
+}
+
+// IDRange is an inclusive range of CDP message ids (see WithLogIDs).
+type IDRange struct {
+	Min, Max int64
+}
+
+// ParseIDRanges parses a comma-separated list of CDP message ids and id ranges
+// (ie, "42,100-120").
+func ParseIDRanges(s string) ([]IDRange, error) {
+	var ranges []IDRange
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(v, "-")
+		if !ok {
+			last = first
+		}
+		lo, err1 := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+		hi, err2 := strconv.ParseInt(strings.TrimSpace(last), 10, 64)
+		if err1 != nil || err2 != nil || lo < 0 || hi < lo {
+			return nil, fmt.Errorf("invalid id range %q", v)
+		}
+		ranges = append(ranges, IDRange{Min: lo, Max: hi})
+	}
+	return ranges, nil
+}
+
+// logID returns true when the frame's message has a CDP id within the proxy's
+// id ranges. Commands and their responses share their id, and events have no
+// id.
+func (p *Proxy) logID(f *frame) bool {
+	if f.typ == websocket.BinaryMessage {
+		return false
+	}
+	id := f.message().ID
+	if id == nil {
+		return false
+	}
+	for _, r := range p.logIDs {
+		if r.Min <= *id && *id <= r.Max {
+			return true
+		}
+	}
+	return false
+}
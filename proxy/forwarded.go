@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwardedForHeader is the header carrying the addresses of the client and
+// the proxies a request was forwarded through, leftmost first.
+const forwardedForHeader = "X-Forwarded-For"
+
+// trusted returns true when the address is one of the proxy's trusted proxies
+// (see WithTrustedProxies).
+func (p *Proxy) trusted(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range p.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerHost returns the host of the request's peer address.
+func peerHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor returns the addresses of the request's X-Forwarded-For headers,
+// leftmost first, when the request's peer is a trusted proxy.
+func (p *Proxy) forwardedFor(req *http.Request) []string {
+	if !p.trusted(peerHost(req)) {
+		return nil
+	}
+	var addrs []string
+	for _, v := range req.Header.Values(forwardedForHeader) {
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// clientAddr returns the address of the request's client: the rightmost
+// X-Forwarded-For address that is not a trusted proxy, when the request was
+// forwarded by a trusted proxy, and the request's peer address otherwise.
+func (p *Proxy) clientAddr(req *http.Request) string {
+	addrs := p.forwardedFor(req)
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if !p.trusted(addrs[i]) || i == 0 {
+			return addrs[i]
+		}
+	}
+	return req.RemoteAddr
+}
+
+// dialHeader returns the header of the request's websocket handshakes with the
+// remote: the proxy's remote header, with the X-Forwarded-For addresses of the
+// request and its peer when forwarding them (see WithForwardedFor).
+func (p *Proxy) dialHeader(req *http.Request) http.Header {
+	if !p.forwardFor {
+		return p.remoteHeader
+	}
+	header := p.remoteHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	addrs := append(p.forwardedFor(req), peerHost(req))
+	header.Set(forwardedForHeader, strings.Join(addrs, ", "))
+	return header
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Direction is the direction of a proxied message.
+type Direction int
+
+// Directions.
+const (
+	// Incoming is a message sent from the client to the browser.
+	Incoming Direction = iota
+	// Outgoing is a message sent from the browser to the client.
+	Outgoing
+)
+
+// String satisfies the fmt.Stringer interface.
+func (d Direction) String() string {
+	if d == Incoming {
+		return "in"
+	}
+	return "out"
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface.
+func (d *Direction) UnmarshalText(buf []byte) error {
+	switch string(buf) {
+	case "in":
+		*d = Incoming
+	case "out":
+		*d = Outgoing
+	default:
+		return fmt.Errorf("invalid direction %q", string(buf))
+	}
+	return nil
+}
+
+// prefix returns the text log prefix for the direction.
+func (d Direction) prefix() string {
+	if d == Incoming {
+		return "<-"
+	}
+	return "->"
+}
+
+// frame is a proxied websocket message.
+type frame struct {
+	dir Direction
+	typ int
+	buf []byte
+	msg *cdpMessage
+	// seq is the session's sequence number for the frame, in order of
+	// arrival across both directions
+	seq int64
+	// elapsed is the time since the start of the session the frame was read
+	// at
+	elapsed time.Duration
+}
+
+// cdpMessage is the subset of a CDP message's fields used by the proxy.
+type cdpMessage struct {
+	ID        *int64          `json:"id"`
+	Method    string          `json:"method"`
+	SessionID string          `json:"sessionId"`
+	Error     json.RawMessage `json:"error"`
+}
+
+// message returns the frame's parsed CDP message. The message is parsed only
+// on first use, and is empty when the frame is not a valid CDP message.
+func (f *frame) message() *cdpMessage {
+	if f.msg == nil {
+		f.msg = new(cdpMessage)
+		_ = json.Unmarshal(f.buf, f.msg)
+	}
+	return f.msg
+}
+
+// sessionIDRE matches the sessionId of a flattened target session, as the
+// sessionId of messages from the client is logged as is.
+var sessionIDRE = regexp.MustCompile(`^[\w.-]{1,128}$`)
+
+// cdpSession returns the sessionId of the message's flattened target session,
+// or "" for messages of the connection's own (root) session, or with an
+// invalid sessionId.
+func (msg *cdpMessage) cdpSession() string {
+	if !sessionIDRE.MatchString(msg.SessionID) {
+		return ""
+	}
+	return msg.SessionID
+}
+
+// tag returns the text log tag for the frame's CDP message: the method and id
+// of commands (ie, "[Page.navigate #7]"), the method of events (ie,
+// "[Network.requestWillBeSent]"), and the id of responses (ie, "[#7]" or
+// "[#7 error]"), followed by the sessionId of messages of flattened target
+// sessions (ie, "[Page.navigate #7 @<sessionId>]"). Returns an empty string
+// when the frame is not a CDP message.
+func (f *frame) tag() string {
+	msg := f.message()
+	var tag string
+	switch {
+	case msg.Method != "" && msg.ID != nil:
+		tag = fmt.Sprintf("%s #%d", msg.Method, *msg.ID)
+	case msg.Method != "":
+		tag = msg.Method
+	case msg.ID != nil && msg.Error != nil:
+		tag = fmt.Sprintf("#%d error", *msg.ID)
+	case msg.ID != nil:
+		tag = fmt.Sprintf("#%d", *msg.ID)
+	default:
+		return ""
+	}
+	if id := msg.cdpSession(); id != "" {
+		tag += " @" + id
+	}
+	return "[" + tag + "]"
+}
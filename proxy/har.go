@@ -0,0 +1,515 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// harFile is a HAR file being written, collecting the entries of all
+// sessions. The file is rewritten each time a session is closed, so that it
+// is always a complete HAR document.
+type harFile struct {
+	mu       sync.Mutex
+	filename string
+	entries  []harEntry
+}
+
+// add adds the entries to the HAR file, and rewrites the file.
+func (h *harFile) add(entries []harEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entries...)
+	sort.SliceStable(h.entries, func(i, j int) bool {
+		return h.entries[i].StartedDateTime.Before(h.entries[j].StartedDateTime)
+	})
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(harDocument{
+		Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "chromedp-proxy", Version: "1.0"},
+			Entries: h.entries,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return replaceFile(h.filename, buf.Bytes())
+}
+
+// replaceFile replaces the contents of the named file, writing to a temporary
+// file that is renamed over the file, so that the file is never left
+// partially written.
+func replaceFile(filename string, buf []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// harSession reconstructs HAR entries from the Network domain events of a
+// session.
+type harSession struct {
+	mu       sync.Mutex
+	requests map[string]*harRequestState
+	order    []*harRequestState
+	bodies   map[string]harBody
+}
+
+// harBody is a captured response body.
+type harBody struct {
+	text   string
+	base64 bool
+}
+
+// newHarSession creates a new HAR session.
+func newHarSession() *harSession {
+	return &harSession{
+		requests: make(map[string]*harRequestState),
+		bodies:   make(map[string]harBody),
+	}
+}
+
+// setBody sets the captured response body for the request id of the
+// session's own target.
+func (h *harSession) setBody(requestID, text string, base64 bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bodies["/"+requestID] = harBody{text: text, base64: base64}
+}
+
+// harRequestState is the state of a request, collected from its Network
+// events.
+type harRequestState struct {
+	key       string
+	request   *cdpRequest
+	response  *cdpResponse
+	wallTime  float64
+	start     float64
+	responded float64
+	end       float64
+	dataSize  int64
+	encoded   int64
+	errorText string
+}
+
+// cdpEvent is a CDP Network event.
+type cdpEvent struct {
+	Method    string         `json:"method"`
+	SessionID string         `json:"sessionId"`
+	Params    cdpEventParams `json:"params"`
+}
+
+// cdpEventParams are the params of the handled Network events.
+type cdpEventParams struct {
+	RequestID         string       `json:"requestId"`
+	Request           *cdpRequest  `json:"request"`
+	RedirectResponse  *cdpResponse `json:"redirectResponse"`
+	Response          *cdpResponse `json:"response"`
+	Timestamp         float64      `json:"timestamp"`
+	WallTime          float64      `json:"wallTime"`
+	DataLength        int64        `json:"dataLength"`
+	EncodedDataLength int64        `json:"encodedDataLength"`
+	ErrorText         string       `json:"errorText"`
+	Canceled          bool         `json:"canceled"`
+}
+
+// cdpRequest is a CDP Network.Request.
+type cdpRequest struct {
+	URL      string            `json:"url"`
+	Method   string            `json:"method"`
+	Headers  map[string]string `json:"headers"`
+	PostData string            `json:"postData"`
+}
+
+// cdpResponse is a CDP Network.Response.
+type cdpResponse struct {
+	Status          int               `json:"status"`
+	StatusText      string            `json:"statusText"`
+	Headers         map[string]string `json:"headers"`
+	MimeType        string            `json:"mimeType"`
+	Protocol        string            `json:"protocol"`
+	RemoteIPAddress string            `json:"remoteIPAddress"`
+	Timing          *cdpTiming        `json:"timing"`
+}
+
+// cdpTiming is a CDP Network.ResourceTiming. All times except RequestTime are
+// milliseconds relative to RequestTime, with -1 for unavailable times.
+type cdpTiming struct {
+	RequestTime       float64 `json:"requestTime"`
+	DNSStart          float64 `json:"dnsStart"`
+	DNSEnd            float64 `json:"dnsEnd"`
+	ConnectStart      float64 `json:"connectStart"`
+	ConnectEnd        float64 `json:"connectEnd"`
+	SSLStart          float64 `json:"sslStart"`
+	SSLEnd            float64 `json:"sslEnd"`
+	SendStart         float64 `json:"sendStart"`
+	SendEnd           float64 `json:"sendEnd"`
+	ReceiveHeadersEnd float64 `json:"receiveHeadersEnd"`
+}
+
+// record records the frame when it is a Network event sent by the browser.
+func (h *harSession) record(f *frame, buf []byte) {
+	if f.dir != Outgoing || !strings.HasPrefix(f.message().Method, "Network.") {
+		return
+	}
+	var ev cdpEvent
+	if err := json.Unmarshal(buf, &ev); err != nil || ev.Params.RequestID == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := ev.SessionID + "/" + ev.Params.RequestID
+	r := h.requests[key]
+	switch ev.Method {
+	case "Network.requestWillBeSent":
+		if ev.Params.Request == nil {
+			return
+		}
+		// redirects reuse the request id, so finish the previous request
+		// with the redirect response and start a new one
+		if r != nil && ev.Params.RedirectResponse != nil {
+			r.response, r.responded, r.end = ev.Params.RedirectResponse, ev.Params.Timestamp, ev.Params.Timestamp
+		}
+		r = &harRequestState{
+			key:      key,
+			request:  ev.Params.Request,
+			wallTime: ev.Params.WallTime,
+			start:    ev.Params.Timestamp,
+		}
+		h.requests[key] = r
+		h.order = append(h.order, r)
+	case "Network.responseReceived":
+		if r != nil {
+			r.response, r.responded = ev.Params.Response, ev.Params.Timestamp
+		}
+	case "Network.dataReceived":
+		if r != nil {
+			r.dataSize += ev.Params.DataLength
+			r.encoded += ev.Params.EncodedDataLength
+		}
+	case "Network.loadingFinished":
+		if r != nil {
+			r.end = ev.Params.Timestamp
+			if ev.Params.EncodedDataLength != 0 {
+				r.encoded = ev.Params.EncodedDataLength
+			}
+		}
+	case "Network.loadingFailed":
+		if r != nil {
+			r.end, r.errorText = ev.Params.Timestamp, ev.Params.ErrorText
+			if r.errorText == "" && ev.Params.Canceled {
+				r.errorText = "canceled"
+			}
+		}
+	}
+}
+
+// entries returns the HAR entries for the session's requests. Requests that
+// have not finished, or have no response, are included with best-effort
+// timings.
+func (h *harSession) entries() []harEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var entries []harEntry
+	for _, r := range h.order {
+		e := r.entry()
+		// redirects reuse the request id, so the body is only for the last
+		// request
+		if body, ok := h.bodies[r.key]; ok && h.requests[r.key] == r {
+			e.Response.Content.Text = body.text
+			if body.base64 {
+				e.Response.Content.Encoding = "base64"
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// entry returns the HAR entry for the request.
+func (r *harRequestState) entry() harEntry {
+	e := harEntry{
+		StartedDateTime: wallTime(r.wallTime),
+		Request: harRequest{
+			Method:      r.request.Method,
+			URL:         r.request.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harCookie{},
+			Headers:     harHeaders(r.request.Headers),
+			QueryString: harQueryString(r.request.URL),
+			HeadersSize: -1,
+			BodySize:    int64(len(r.request.PostData)),
+		},
+		Response: harResponse{
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harCookie{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+			Content:     harContent{Size: r.dataSize, MimeType: "x-unknown"},
+		},
+		Cache: struct{}{},
+		Timings: harTimings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			SSL:     -1,
+		},
+		Error: r.errorText,
+	}
+	if r.request.PostData != "" {
+		mimeType := headerValue(r.request.Headers, "Content-Type")
+		e.Request.PostData = &harPostData{MimeType: mimeType, Text: r.request.PostData}
+	}
+	end := r.end
+	if res := r.response; res != nil {
+		httpVersion := harHTTPVersion(res.Protocol)
+		e.Request.HTTPVersion = httpVersion
+		e.Response.Status = res.Status
+		e.Response.StatusText = res.StatusText
+		e.Response.HTTPVersion = httpVersion
+		e.Response.Headers = harHeaders(res.Headers)
+		e.Response.RedirectURL = headerValue(res.Headers, "Location")
+		if res.MimeType != "" {
+			e.Response.Content.MimeType = res.MimeType
+		}
+		if r.encoded != 0 {
+			e.Response.BodySize = r.encoded
+		}
+		e.ServerIPAddress = strings.Trim(res.RemoteIPAddress, "[]")
+		if end == 0 {
+			end = r.responded
+		}
+		if t := res.Timing; t != nil {
+			if t.DNSStart >= 0 {
+				e.Timings.DNS = ms(t.DNSEnd - t.DNSStart)
+			}
+			if t.ConnectStart >= 0 {
+				e.Timings.Connect = ms(t.ConnectEnd - t.ConnectStart)
+			}
+			if t.SSLStart >= 0 {
+				e.Timings.SSL = ms(t.SSLEnd - t.SSLStart)
+			}
+			for _, v := range []float64{t.DNSStart, t.ConnectStart, t.SendStart} {
+				if v >= 0 {
+					e.Timings.Blocked = ms(v)
+					break
+				}
+			}
+			e.Timings.Send = ms(math.Max(0, t.SendEnd-t.SendStart))
+			e.Timings.Wait = ms(math.Max(0, t.ReceiveHeadersEnd-t.SendEnd))
+			if end != 0 {
+				e.Timings.Receive = ms(math.Max(0, (end-t.RequestTime)*1000-t.ReceiveHeadersEnd))
+			}
+		} else if r.responded != 0 {
+			e.Timings.Wait = ms(math.Max(0, (r.responded-r.start)*1000))
+			if end != 0 {
+				e.Timings.Receive = ms(math.Max(0, (end-r.responded)*1000))
+			}
+		}
+	} else if end != 0 {
+		e.Timings.Wait = ms(math.Max(0, (end-r.start)*1000))
+	}
+	if end != 0 {
+		e.Time = ms(math.Max(0, (end-r.start)*1000))
+	}
+	return e
+}
+
+// wallTime converts a CDP wall time (seconds since the epoch) to a time,
+// using the current time when not available.
+func wallTime(t float64) time.Time {
+	if t == 0 {
+		return time.Now()
+	}
+	sec, frac := math.Modf(t)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// ms rounds a duration in milliseconds to microseconds.
+func ms(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// harHTTPVersion returns the HAR http version for a CDP protocol.
+func harHTTPVersion(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "":
+		return "HTTP/1.1"
+	case "h2":
+		return "HTTP/2.0"
+	case "h3", "h3-29", "quic":
+		return "HTTP/3.0"
+	}
+	return strings.ToUpper(protocol)
+}
+
+// harHeaders converts CDP headers to sorted HAR headers. CDP joins multiple
+// values of a header with newlines.
+func harHeaders(headers map[string]string) []harNameValue {
+	v := []harNameValue{}
+	for name, value := range headers {
+		for _, s := range strings.Split(value, "\n") {
+			v = append(v, harNameValue{Name: name, Value: s})
+		}
+	}
+	sort.SliceStable(v, func(i, j int) bool {
+		return v[i].Name < v[j].Name
+	})
+	return v
+}
+
+// headerValue returns the value of the named header, matched
+// case-insensitively.
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// harQueryString returns the HAR query string of the url.
+func harQueryString(urlstr string) []harNameValue {
+	v := []harNameValue{}
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return v
+	}
+	for _, s := range strings.Split(u.RawQuery, "&") {
+		if s == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(s, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if val, err := url.QueryUnescape(value); err == nil {
+			value = val
+		}
+		v = append(v, harNameValue{Name: name, Value: value})
+	}
+	return v
+}
+
+// harDocument is a HAR 1.2 document.
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+// harLog is the HAR log.
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+// harCreator is the HAR creator.
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// harEntry is a HAR entry.
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+// harRequest is a HAR request.
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// harResponse is a HAR response.
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// harCookie is a HAR cookie.
+type harCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harNameValue is a HAR header or query string parameter.
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harPostData is HAR post data.
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// harContent is HAR response content.
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// harTimings are HAR timings, in milliseconds, with -1 for unavailable
+// timings.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+// heartbeat logs a heartbeat line each time the session has proxied no
+// message in either direction for the proxy's heartbeat interval (see
+// WithHeartbeat), until the context is closed. Heartbeats are repeated every
+// interval while the session stays idle.
+func (s *session) heartbeat(ctx context.Context) {
+	interval := s.p.heartbeat
+	t := time.NewTimer(interval)
+	defer t.Stop()
+	var beat time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		next := time.Unix(0, s.last.Load()).Add(interval)
+		if b := beat.Add(interval); b.After(next) {
+			next = b
+		}
+		if d := time.Until(next); d > 0 {
+			t.Reset(d)
+			continue
+		}
+		beat = time.Now()
+		st := s.stats.stats()
+		s.infof("[heartbeat] session idle for %v, %d frames so far",
+			beat.Sub(time.Unix(0, s.last.Load())).Round(time.Second), st.Messages[Incoming]+st.Messages[Outgoing])
+		t.Reset(interval)
+	}
+}
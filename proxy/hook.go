@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDropMessage is returned by a MessageHook to drop a message instead of
+// forwarding it.
+var ErrDropMessage = errors.New("drop message")
+
+// MessageHook is a func to intercept and rewrite proxied messages before they
+// are forwarded to the peer.
+//
+// The hook is passed the direction and raw message, and returns the message
+// to forward. Returning raw itself forwards the original message without any
+// copying. Returning ErrDropMessage drops the message, and returning any other
+// error closes the session.
+type MessageHook func(dir Direction, raw []byte) ([]byte, error)
+
+// Info is the information about a proxied devtools session.
+type Info struct {
+	// ID is the devtools id of the session's target.
+	ID string
+	// Path is the devtools path of the session's target (ie,
+	// /devtools/page/<id>), for use with Dial.
+	Path string
+	// Remote is the name of the remote the session is proxied to, empty for
+	// the default remote.
+	Remote string
+	// RemoteAddr is the client address of the session.
+	RemoteAddr string
+	// Browser is the browser's version, as reported by the remote (ie,
+	// HeadlessChrome/120.0.6099.109).
+	Browser string
+	// Start is the time the session connected.
+	Start time.Time
+	// Conn is the ULID of the client's TCP connection, as logged when the
+	// connection is accepted and closed.
+	Conn string
+}
+
+// Stats are the statistics of a closed devtools session.
+type Stats struct {
+	// Duration is the duration of the session.
+	Duration time.Duration
+	// Messages are the number of messages proxied in each direction, indexed
+	// by direction.
+	Messages [2]int64
+	// Bytes are the number of message bytes proxied in each direction, indexed
+	// by direction.
+	Bytes [2]int64
+	// Methods are the number of messages proxied for each CDP method.
+	Methods map[string]int64
+}
+
+// ConnectHook is a func called when a devtools session has connected to both
+// the client and the remote.
+type ConnectHook func(info Info)
+
+// DisconnectHook is a func called when a devtools session has been closed,
+// with the session's statistics.
+type DisconnectHook func(info Info, stats Stats)
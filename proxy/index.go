@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// indexTemplate is the template of the proxy's target index page (see
+// WithTargetsPage).
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>chromedp-proxy{{with .Remote}} {{.}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: .3em .8em; border-bottom: 1px solid #ddd; }
+td.url, td.ws { font-family: monospace; font-size: .9em; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Targets{{with .Remote}} of {{.}}{{end}}</h1>
+{{if .Targets}}<table>
+<tr><th>Title</th><th>Type</th><th>URL</th><th>WebSocket</th></tr>
+{{range .Targets}}<tr>
+<td>{{if .DevtoolsFrontendURL}}<a href="{{.DevtoolsFrontendURL}}">{{or .Title .ID}}</a>{{else}}{{or .Title .ID}}{{end}}</td>
+<td>{{.Type}}</td>
+<td class="url">{{.URL}}</td>
+<td class="ws">{{.WebSocketDebuggerURL}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>No targets.</p>
+{{end}}</body>
+</html>
+`))
+
+// indexHandler wraps the remote's handler, serving an index page of the
+// remote's targets at the remote's root, with links to the devtools frontend
+// and websocket urls of each target that go through the proxy.
+func (p *Proxy) indexHandler(r *remote, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			h.ServeHTTP(res, req)
+			return
+		}
+		targets, err := p.remoteTargets(req.Context(), r)
+		if err != nil {
+			p.writeError(res, r, stageTarget, http.StatusBadGateway, fmt.Sprintf("could not list targets: %v", err))
+			return
+		}
+		fe := frontend{host: req.Host, secure: req.TLS != nil, prefix: r.prefix()}
+		for i, t := range targets {
+			if t.WebSocketDebuggerURL != "" {
+				targets[i].WebSocketDebuggerURL = rewriteWebsocketURL(r, t.WebSocketDebuggerURL, fe)
+			}
+			if t.DevtoolsFrontendURL != "" {
+				targets[i].DevtoolsFrontendURL = rewriteFrontendURL(r, t.DevtoolsFrontendURL, fe)
+			}
+		}
+		var buf bytes.Buffer
+		err = indexTemplate.Execute(&buf, struct {
+			Remote  string
+			Targets []Target
+		}{r.name, targets})
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		res.Header().Set("Cache-Control", "no-store")
+		_, _ = res.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Command is a CDP command written to the remote by the proxy (see
+// WithOnConnectCommands).
+type Command struct {
+	// Method is the command's CDP method (ie, Network.enable).
+	Method string `json:"method"`
+	// Params are the command's parameters, if any.
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// injectedCommand is a command written by the proxy, with the id assigned by
+// the proxy.
+type injectedCommand struct {
+	ID     int64           `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// maxInjectedID is the id of the first command written by the proxy. Ids are
+// assigned counting down from the largest id accepted by the browser, so that
+// they do not collide with the ids of the client's commands, which count up.
+const maxInjectedID = math.MaxInt32
+
+// injectedCommands tracks the commands written by the proxy that are waiting
+// for a response from the browser.
+type injectedCommands struct {
+	mu  sync.Mutex
+	ids map[int64]bool
+}
+
+// injectCommands writes the proxy's on connect commands to the remote,
+// logging each command. The responses are logged but not forwarded to the
+// client (see injectedResponse).
+func (s *session) injectCommands() error {
+	s.injected.mu.Lock()
+	s.injected.ids = make(map[int64]bool, len(s.p.connectCommands))
+	s.injected.mu.Unlock()
+	for i, cmd := range s.p.connectCommands {
+		id := int64(maxInjectedID - i)
+		buf, err := json.Marshal(injectedCommand{ID: id, Method: cmd.Method, Params: cmd.Params})
+		if err != nil {
+			return err
+		}
+		s.injected.mu.Lock()
+		s.injected.ids[id] = true
+		s.injected.mu.Unlock()
+		s.logf("injected %s", cmd.Method)
+		f := &frame{dir: Incoming, typ: websocket.TextMessage, buf: buf}
+		s.logFrame(f)
+		if err := s.write(Incoming, websocket.TextMessage, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injectedResponse returns true when the frame is the browser's response to
+// a command written by the proxy, which is not forwarded to the client.
+func (s *session) injectedResponse(f *frame) bool {
+	if len(s.p.connectCommands) == 0 {
+		return false
+	}
+	msg := f.message()
+	if msg.ID == nil || msg.Method != "" || msg.SessionID != "" {
+		return false
+	}
+	s.injected.mu.Lock()
+	defer s.injected.mu.Unlock()
+	if !s.injected.ids[*msg.ID] {
+		return false
+	}
+	delete(s.injected.ids, *msg.ID)
+	return true
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// chainHash returns the chained hash of an entry's sha256 following the
+// chained hash of the previous entries (all zeros for a session's first entry).
+func chainHash(prev, sum [sha256.Size]byte) [sha256.Size]byte {
+	return sha256.Sum256(append(prev[:], sum[:]...))
+}
+
+// hashEntry returns the marshaled entry, with the sha256 of the entry marshaled
+// without its hashes, and the chained hash of the log's entries so far. The
+// log must be locked, so that entries are chained in the order they are
+// written.
+func (l *sessionLog) hashEntry(entry logEntry) ([]byte, error) {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf)
+	l.chain = chainHash(l.chain, sum)
+	entry.SHA256, entry.Chain = hex.EncodeToString(sum[:]), hex.EncodeToString(l.chain[:])
+	return json.Marshal(entry)
+}
+
+// logHashesRE matches the hashes ending a hashed jsonl log entry.
+var logHashesRE = regexp.MustCompile(`,"sha256":"([0-9a-f]{64})","chain":"([0-9a-f]{64})"}$`)
+
+// LogVerification is the result of verifying a log's hashes.
+type LogVerification struct {
+	// Entries is the number of verified entries.
+	Entries int
+	// Sessions is the number of verified sessions.
+	Sessions int
+}
+
+// VerifyLog verifies the hashes of a jsonl log written with hashes (see
+// WithLogHashes), checking that each entry matches its sha256, and that the
+// entries of each session follow each other in the chain. An error is returned
+// for the first entry that was altered, or that follows entries that were
+// removed, reordered or altered. Entries removed from the end of a session's
+// log cannot be detected.
+func VerifyLog(rd io.Reader) (LogVerification, error) {
+	s := bufio.NewScanner(rd)
+	s.Buffer(nil, 64*1024*1024)
+	var res LogVerification
+	chains := make(map[string][sha256.Size]byte)
+	for n := 1; s.Scan(); n++ {
+		line := s.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		m := logHashesRE.FindSubmatchIndex(line)
+		if m == nil {
+			return res, fmt.Errorf("line %d: not a hashed jsonl log entry", n)
+		}
+		buf := append(append([]byte(nil), line[:m[0]]...), '}')
+		var entry logEntry
+		if err := json.Unmarshal(buf, &entry); err != nil {
+			return res, fmt.Errorf("line %d: invalid log entry: %w", n, err)
+		}
+		sum := sha256.Sum256(buf)
+		if hex.EncodeToString(sum[:]) != string(line[m[2]:m[3]]) {
+			return res, fmt.Errorf("line %d: entry does not match its sha256 (altered)", n)
+		}
+		key := entry.Run + "/" + entry.Session
+		prev, ok := chains[key]
+		chain := chainHash(prev, sum)
+		if hex.EncodeToString(chain[:]) != string(line[m[4]:m[5]]) {
+			// a reconnected session with the same id starts a new chain
+			chain, ok = chainHash([sha256.Size]byte{}, sum), false
+			if hex.EncodeToString(chain[:]) != string(line[m[4]:m[5]]) {
+				return res, fmt.Errorf("line %d: entry does not follow the chain of session %s (earlier entries removed, reordered or altered)", n, entry.Session)
+			}
+		}
+		if !ok {
+			res.Sessions++
+		}
+		chains[key] = chain
+		res.Entries++
+	}
+	return res, s.Err()
+}
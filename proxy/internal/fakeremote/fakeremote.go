@@ -0,0 +1,301 @@
+// Package fakeremote provides a fake CDP remote, for exercising the proxy end
+// to end without a browser.
+//
+// The remote serves /json/version, the /json target list (and /json/new,
+// /json/close/<id>), and a websocket on /devtools/page/<id> and
+// /devtools/browser/<id> replying to each message (by default, echoing the
+// message back).
+package fakeremote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Browser is the browser version reported by the remote.
+const Browser = "HeadlessChrome/120.0.0.0"
+
+// Target is a target listed by the remote.
+type Target struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// Remote is a fake CDP remote.
+type Remote struct {
+	*httptest.Server
+
+	// Addr is the host:port address of the remote, for use as the proxy's
+	// remote.
+	Addr string
+
+	// Reply returns the replies to a websocket message received by the
+	// remote. Defaults to echoing the message back. Must be set before any
+	// session connects.
+	Reply func(target string, msg []byte) [][]byte
+
+	// prefix is the path prefix the remote is served under, if any
+	prefix   string
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	targets  []Target
+	next     int
+	received []Message
+	conns    map[*websocket.Conn]bool
+}
+
+// Message is a message received by the remote.
+type Message struct {
+	// Target is the id of the target the message was sent to.
+	Target string
+	// Msg is the message.
+	Msg []byte
+}
+
+// New starts a fake remote listing a single page target with the id "P1",
+// which must be shut down with Close.
+func New() *Remote {
+	return start(httptest.NewServer, "")
+}
+
+// NewTLS starts a fake remote like New, served over TLS with a self-signed
+// certificate (see httptest.NewTLSServer).
+func NewTLS() *Remote {
+	return start(httptest.NewTLSServer, "")
+}
+
+// NewPrefix starts a fake remote like New, served under the path prefix (ie,
+// /chrome) and reporting urls under it, as a remote behind a reverse proxy
+// would.
+func NewPrefix(prefix string) *Remote {
+	return start(httptest.NewServer, prefix)
+}
+
+// start starts a fake remote served by the server started by newServer, under
+// the path prefix.
+func start(newServer func(http.Handler) *httptest.Server, prefix string) *Remote {
+	r := &Remote{
+		prefix:  prefix,
+		targets: []Target{{ID: "P1", Type: "page", Title: "Example", URL: "https://example.com/"}},
+		next:    2,
+		conns:   make(map[*websocket.Conn]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/version", r.serveVersion)
+	mux.HandleFunc("/json", r.serveTargets)
+	mux.HandleFunc("/json/list", r.serveTargets)
+	mux.HandleFunc("/json/new", r.serveNew)
+	mux.HandleFunc("/json/close/", r.serveClose)
+	mux.HandleFunc("/devtools/", r.serveWS)
+	var h http.Handler = mux
+	if prefix != "" {
+		h = http.StripPrefix(prefix, mux)
+	}
+	r.Server = newServer(h)
+	r.Addr = r.Server.Listener.Addr().String()
+	return r
+}
+
+// Close closes the remote's websocket connections and shuts it down.
+func (r *Remote) Close() {
+	r.CloseConns()
+	r.Server.Close()
+}
+
+// CloseConns closes the remote's websocket connections, without a close
+// message, as a crashed browser would.
+func (r *Remote) CloseConns() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for conn := range r.conns {
+		conn.Close()
+	}
+}
+
+// AddTarget adds a target to the remote's target list.
+func (r *Remote) AddTarget(t Target) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.targets = append(r.targets, t)
+}
+
+// Targets returns the remote's targets.
+func (r *Remote) Targets() []Target {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Target(nil), r.targets...)
+}
+
+// Received returns the websocket messages received by the remote, in order.
+func (r *Remote) Received() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.received...)
+}
+
+// serveVersion serves /json/version.
+func (r *Remote) serveVersion(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, map[string]string{
+		"Browser":              Browser,
+		"Protocol-Version":     "1.3",
+		"User-Agent":           "Mozilla/5.0 " + Browser,
+		"V8-Version":           "12.0.267.8",
+		"WebKit-Version":       "537.36",
+		"webSocketDebuggerUrl": wsScheme(req) + req.Host + r.prefix + "/devtools/browser/B1",
+	})
+}
+
+// target is a listed target, with its urls.
+type target struct {
+	Target
+	DevtoolsFrontendURL  string `json:"devtoolsFrontendUrl"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// listTarget returns the listed target for the request's host.
+func (r *Remote) listTarget(t Target, req *http.Request) target {
+	ws := req.Host + r.prefix + "/devtools/page/" + t.ID
+	key := "ws"
+	if req.TLS != nil {
+		key = "wss"
+	}
+	return target{
+		Target:               t,
+		DevtoolsFrontendURL:  r.prefix + "/devtools/inspector.html?" + key + "=" + ws,
+		WebSocketDebuggerURL: wsScheme(req) + ws,
+	}
+}
+
+// wsScheme returns the scheme of websocket urls for the request, with the
+// separator.
+func wsScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "wss://"
+	}
+	return "ws://"
+}
+
+// serveTargets serves the /json target list.
+func (r *Remote) serveTargets(res http.ResponseWriter, req *http.Request) {
+	var list []target
+	for _, t := range r.Targets() {
+		list = append(list, r.listTarget(t, req))
+	}
+	writeJSON(res, list)
+}
+
+// serveNew serves /json/new, creating a page target for the url passed as the
+// raw query.
+func (r *Remote) serveNew(res http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	t := Target{ID: "P" + strconv.Itoa(r.next), Type: "page", URL: req.URL.RawQuery}
+	if t.URL == "" {
+		t.URL = "about:blank"
+	}
+	r.next++
+	r.targets = append(r.targets, t)
+	r.mu.Unlock()
+	writeJSON(res, r.listTarget(t, req))
+}
+
+// serveClose serves /json/close/<id>.
+func (r *Remote) serveClose(res http.ResponseWriter, req *http.Request) {
+	id := path.Base(req.URL.Path)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range r.targets {
+		if t.ID == id {
+			r.targets = append(r.targets[:i], r.targets[i+1:]...)
+			fmt.Fprint(res, "Target is closing")
+			return
+		}
+	}
+	http.Error(res, "No such target id: "+id, http.StatusNotFound)
+}
+
+// serveWS serves the websocket of a page or browser target.
+func (r *Remote) serveWS(res http.ResponseWriter, req *http.Request) {
+	id := path.Base(req.URL.Path)
+	switch path.Base(path.Dir(req.URL.Path)) {
+	case "browser":
+	case "page":
+		if !r.listed(id) {
+			http.Error(res, "No such target id: "+id, http.StatusNotFound)
+			return
+		}
+	default:
+		http.NotFound(res, req)
+		return
+	}
+	conn, err := r.upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	r.conns[conn] = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, conn)
+		r.mu.Unlock()
+		conn.Close()
+	}()
+	reply := r.Reply
+	if reply == nil {
+		reply = func(_ string, msg []byte) [][]byte {
+			return [][]byte{msg}
+		}
+	}
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeErr.Code, ""))
+			}
+			return
+		}
+		r.mu.Lock()
+		r.received = append(r.received, Message{Target: id, Msg: msg})
+		r.mu.Unlock()
+		for _, buf := range reply(id, msg) {
+			if err := conn.WriteMessage(mt, buf); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// listed returns true when the target with the id is listed.
+func (r *Remote) listed(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.targets {
+		if t.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes the json encoded value.
+func writeJSON(res http.ResponseWriter, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	res.Write(buf)
+}
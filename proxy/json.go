@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// frontendKey is the context key for the frontend a client used to reach the
+// proxy.
+type frontendKey struct{}
+
+// frontend is the host, scheme, and path prefix a client used to reach the
+// proxy.
+type frontend struct {
+	host   string
+	secure bool
+	prefix string
+}
+
+// withFrontend wraps the remote's handler, saving the request's frontend on its
+// context so it is available when rewriting the remote's response.
+func withFrontend(r *remote, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		fe := frontend{host: req.Host, secure: req.TLS != nil, prefix: r.prefix()}
+		h.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), frontendKey{}, fe)))
+	})
+}
+
+// targetRewriter returns the func to rewrite the response of the remote's
+// endpoint at the path, or nil when the endpoint's response is not rewritten.
+// The target list endpoints return a list of targets, while /json/new returns
+// the created target and /json/version the browser target's websocket url.
+func targetRewriter(urlpath string) func(*remote, []byte, frontend) ([]byte, error) {
+	switch strings.TrimSuffix(urlpath, "/") {
+	case "/json", "/json/list":
+		return rewriteTargets
+	case "/json/new", "/json/version":
+		return rewriteSingleTarget
+	}
+	return nil
+}
+
+// modifyResponse rewrites the targets returned by the remote so that the
+// websocket urls point at the proxy instead of the remote, and likewise for
+// the Location of the remote's redirects.
+func (p *Proxy) modifyResponse(r *remote, res *http.Response) error {
+	fe, _ := res.Request.Context().Value(frontendKey{}).(frontend)
+	if fe.host == "" {
+		fe.host, fe.secure, fe.prefix = p.listen, p.listenTLS(), r.prefix()
+	}
+	rewriteLocation(r, res, fe)
+	// the request was sent under the remote's path prefix, if any
+	urlpath := r.trimPath(false, res.Request.URL.Path)
+	rewrite := targetRewriter(urlpath)
+	if rewrite == nil || res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if buf, err := rewrite(r, body, fe); err == nil {
+		body = buf
+	}
+	if len(p.versionOverride) != 0 && strings.TrimSuffix(urlpath, "/") == "/json/version" {
+		if buf, err := overrideVersion(body, p.versionOverride); err == nil {
+			body = buf
+		}
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// rewriteTargets rewrites the webSocketDebuggerUrl and devtoolsFrontendUrl of
+// each target in the json encoded target list so that they point at the
+// frontend instead of the remote.
+func rewriteTargets(r *remote, body []byte, fe frontend) ([]byte, error) {
+	var targets []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &targets); err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		rewriteTarget(r, target, fe)
+	}
+	return json.Marshal(targets)
+}
+
+// rewriteSingleTarget rewrites the webSocketDebuggerUrl and
+// devtoolsFrontendUrl of the json encoded target so that they point at the
+// frontend instead of the remote.
+func rewriteSingleTarget(r *remote, body []byte, fe frontend) ([]byte, error) {
+	var target map[string]json.RawMessage
+	if err := json.Unmarshal(body, &target); err != nil {
+		return nil, err
+	}
+	rewriteTarget(r, target, fe)
+	return json.Marshal(target)
+}
+
+// overrideVersion overrides the fields of the json encoded /json/version
+// output (see WithVersionOverride), removing the fields overridden with an
+// empty value.
+func overrideVersion(body []byte, overrides map[string]string) ([]byte, error) {
+	var version map[string]json.RawMessage
+	if err := json.Unmarshal(body, &version); err != nil {
+		return nil, err
+	}
+	for k, v := range overrides {
+		if v == "" {
+			delete(version, k)
+			continue
+		}
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		version[k] = buf
+	}
+	return json.Marshal(version)
+}
+
+// rewriteTarget rewrites the urls of a single target.
+func rewriteTarget(r *remote, target map[string]json.RawMessage, fe frontend) {
+	rewrite := func(key string, f func(string) string) {
+		var s string
+		if err := json.Unmarshal(target[key], &s); err != nil || s == "" {
+			return
+		}
+		if buf, err := json.Marshal(f(s)); err == nil {
+			target[key] = buf
+		}
+	}
+	rewrite("webSocketDebuggerUrl", func(s string) string {
+		return rewriteWebsocketURL(r, s, fe)
+	})
+	// newer browsers also list a frontend url for older hosted frontends
+	for _, key := range []string{"devtoolsFrontendUrl", "devtoolsFrontendUrlCompat"} {
+		rewrite(key, func(s string) string {
+			return rewriteFrontendURL(r, s, fe)
+		})
+	}
+}
+
+// rewriteWebsocketURL rewrites a webSocketDebuggerUrl to point at the
+// frontend, using wss:// when the frontend is served over TLS. The remote's
+// path prefix is replaced with the frontend's.
+func rewriteWebsocketURL(r *remote, s string, fe frontend) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	u.Host, u.Scheme, u.Path, u.RawPath = fe.host, "ws", fe.prefix+r.trimPath(true, u.Path), ""
+	if fe.secure {
+		u.Scheme = "wss"
+	}
+	return u.String()
+}
+
+// rewriteFrontendURL rewrites the ws= (or wss=) query parameter of a
+// devtoolsFrontendUrl (ie, /devtools/inspector.html?ws=host/devtools/page/ID,
+// or a hosted frontend such as
+// https://chrome-devtools-frontend.appspot.com/...?ws=host/devtools/page/ID)
+// to point at the frontend, using wss= when the frontend is served over TLS.
+// The remote's path prefix is replaced with the frontend's, likewise for
+// relative frontend urls.
+func rewriteFrontendURL(r *remote, s string, fe frontend) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.Host == "" && strings.HasPrefix(u.Path, "/") {
+		u.Path, u.RawPath = fe.prefix+r.trimPath(false, u.Path), ""
+	}
+	params := strings.Split(u.RawQuery, "&")
+	key := "ws"
+	if fe.secure {
+		key = "wss"
+	}
+	for i, param := range params {
+		k, value, ok := strings.Cut(param, "=")
+		if !ok || (k != "ws" && k != "wss") {
+			continue
+		}
+		// the value may be escaped (ie, host%3A9222%2Fdevtools%2Fpage%2FID)
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		if _, rest, ok := strings.Cut(value, "/"); ok {
+			params[i] = key + "=" + fe.host + fe.prefix + r.trimPath(true, "/"+rest)
+		}
+	}
+	u.RawQuery = strings.Join(params, "&")
+	return u.String()
+}
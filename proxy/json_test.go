@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestRewriteURLs(t *testing.T) {
+	tests := []struct {
+		remote    string
+		fe        frontend
+		ws, wsExp string
+		fr, frExp string
+	}{
+		{
+			"localhost:9222", frontend{host: "proxy:9223"},
+			"ws://localhost:9222/devtools/page/P1", "ws://proxy:9223/devtools/page/P1",
+			"/devtools/inspector.html?ws=localhost:9222/devtools/page/P1", "/devtools/inspector.html?ws=proxy:9223/devtools/page/P1",
+		},
+		{
+			"http://localhost:9222/chrome", frontend{host: "proxy:9223"},
+			"ws://localhost:9222/chrome/devtools/page/P1", "ws://proxy:9223/devtools/page/P1",
+			"/chrome/devtools/inspector.html?ws=localhost:9222/chrome/devtools/page/P1", "/devtools/inspector.html?ws=proxy:9223/devtools/page/P1",
+		},
+		{
+			"https://localhost:9222/chrome/", frontend{host: "proxy:9223", secure: true, prefix: "/b"},
+			"wss://localhost:9222/chrome/devtools/page/P1", "wss://proxy:9223/b/devtools/page/P1",
+			"https://chrome-devtools-frontend.appspot.com/serve_file/inspector.html?wss=localhost:9222%2Fchrome%2Fdevtools%2Fpage%2FP1", "https://chrome-devtools-frontend.appspot.com/serve_file/inspector.html?wss=proxy:9223/b/devtools/page/P1",
+		},
+		{
+			// only a whole path segment is trimmed
+			"http://localhost:9222/chrome", frontend{host: "proxy:9223"},
+			"ws://localhost:9222/chromium/devtools/page/P1", "ws://proxy:9223/chromium/devtools/page/P1",
+			"/chromium/devtools/inspector.html", "/chromium/devtools/inspector.html",
+		},
+	}
+	for i, test := range tests {
+		r := newRemote("", test.remote)
+		if s := rewriteWebsocketURL(r, test.ws, test.fe); s != test.wsExp {
+			t.Errorf("test %d: expected %q, got: %q", i, test.wsExp, s)
+		}
+		if s := rewriteFrontendURL(r, test.fr, test.fe); s != test.frExp {
+			t.Errorf("test %d: expected %q, got: %q", i, test.frExp, s)
+		}
+	}
+}
+
+func TestPrefixedRemote(t *testing.T) {
+	remote := fakeremote.NewPrefix("/chrome")
+	defer remote.Close()
+	tests := []struct {
+		prefix string
+		opt    Option
+	}{
+		{"", WithRemote("http://" + remote.Addr + "/chrome")},
+		{"/b", WithNamedRemote("b", "http://"+remote.Addr+"/chrome/")},
+	}
+	for i, test := range tests {
+		stdout := new(logBuffer)
+		p := New(WithListen("127.0.0.1:0"), test.opt, WithNoLog(true), WithStdout(stdout))
+		ln, err := p.Listen()
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		serve(t, p, ln)
+		addr, prefix := ln.Addr().String(), test.prefix
+		cl := &http.Client{Timeout: testTimeout}
+		res, err := cl.Get("http://" + addr + prefix + "/json")
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		var targets []struct {
+			DevtoolsFrontendURL  string `json:"devtoolsFrontendUrl"`
+			WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&targets)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		ws := "ws://" + addr + prefix + "/devtools/page/P1"
+		fr := prefix + "/devtools/inspector.html?ws=" + addr + prefix + "/devtools/page/P1"
+		if len(targets) != 1 || targets[0].WebSocketDebuggerURL != ws || targets[0].DevtoolsFrontendURL != fr {
+			t.Fatalf("test %d: expected target %s (%s), got: %v", i, ws, fr, targets)
+		}
+		// the rewritten url reaches the devtools handler, so the session is
+		// logged
+		d := &websocket.Dialer{HandshakeTimeout: testTimeout}
+		c, _, err := d.Dial(ws, nil)
+		if err != nil {
+			t.Fatalf("test %d: expected no error, got: %v", i, err)
+		}
+		if buf := roundTrip(t, c, `{"id":1,"method":"Page.enable"}`); string(buf) != `{"id":1,"method":"Page.enable"}` {
+			t.Errorf("test %d: expected the echoed command, got: %s", i, buf)
+		}
+		closeClient(t, c)
+		c.Close()
+		waitLog(t, stdout, "connected to ws://"+remote.Addr+"/chrome/devtools/page/P1")
+	}
+}
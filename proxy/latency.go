@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPendingCommands is the maximum number of commands tracked per session
+// while waiting for their response. Commands sent while the limit is reached
+// are not tracked.
+const maxPendingCommands = 10000
+
+// pendingCommands tracks the commands sent by the client that are waiting for
+// a response from the browser.
+type pendingCommands struct {
+	mu       sync.Mutex
+	commands map[string]pendingCommand
+}
+
+// pendingCommand is a command waiting for a response.
+type pendingCommand struct {
+	method string
+	start  time.Time
+	size   int
+}
+
+// newPendingCommands creates a new pending commands tracker.
+func newPendingCommands() *pendingCommands {
+	return &pendingCommands{commands: make(map[string]pendingCommand)}
+}
+
+// key returns the key for the command id on the CDP session.
+func (*pendingCommands) key(sessionID string, id int64) string {
+	return sessionID + "/" + strconv.FormatInt(id, 10)
+}
+
+// sent tracks a command frame sent to the browser.
+func (pc *pendingCommands) sent(f *frame) {
+	msg := f.message()
+	if msg.ID == nil || msg.Method == "" {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if len(pc.commands) >= maxPendingCommands {
+		return
+	}
+	pc.commands[pc.key(msg.SessionID, *msg.ID)] = pendingCommand{
+		method: msg.Method,
+		start:  time.Now(),
+		size:   len(f.buf),
+	}
+}
+
+// received returns the pending command for a response frame received from
+// the browser, removing it from the pending commands.
+func (pc *pendingCommands) received(f *frame) (pendingCommand, bool) {
+	msg := f.message()
+	if msg.ID == nil || msg.Method != "" {
+		return pendingCommand{}, false
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	key := pc.key(msg.SessionID, *msg.ID)
+	cmd, ok := pc.commands[key]
+	if ok {
+		delete(pc.commands, key)
+	}
+	return cmd, ok
+}
+
+// method returns the method of the pending command of a response frame, or
+// "", without removing the command from the pending commands.
+func (pc *pendingCommands) method(f *frame) string {
+	msg := f.message()
+	if msg.ID == nil || msg.Method != "" {
+		return ""
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.commands[pc.key(msg.SessionID, *msg.ID)].method
+}
+
+// trackCommand tracks the frame's command, or logs the latency (see
+// WithLatency), traces the call (see WithTraceCalls) and adds the trace event
+// (see WithTraceEvents) of the command when the frame is a response. Events
+// are added to the session's trace events.
+func (s *session) trackCommand(f *frame) {
+	if f.dir == Incoming {
+		s.pending.sent(f)
+		return
+	}
+	if s.timeline != nil && f.message().Method != "" {
+		s.timeline.event(f)
+		return
+	}
+	cmd, ok := s.pending.received(f)
+	if !ok {
+		return
+	}
+	if s.timeline != nil {
+		s.timeline.command(cmd, f)
+	}
+	if s.p.latency {
+		s.logf("[id %d] %s completed in %v", *f.message().ID, cmd.method, time.Since(cmd.start).Round(time.Microsecond))
+	}
+	if s.span != nil && s.p.traceCalls {
+		s.traceCall(cmd, f)
+	}
+}
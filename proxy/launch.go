@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// launchTimeout is the maximum time to wait for a launched browser to start
+// listening.
+const launchTimeout = 30 * time.Second
+
+// Browser is a browser process launched by the proxy.
+type Browser struct {
+	// Addr is the host:port address the browser's remote debugging port is
+	// listening on, for use as the proxy's remote.
+	Addr string
+
+	cmd  *exec.Cmd
+	dir  string
+	done chan struct{}
+}
+
+// Launch starts a browser with its remote debugging port enabled, waiting for
+// the browser to write the port it chose to its DevToolsActivePort file. When
+// path is empty, a Chrome or Chromium binary is searched for in the usual
+// locations. The browser is started with a temporary profile, and the args
+// are passed to the browser after the proxy's own flags (ie, "--headless").
+//
+// The browser must be shut down with Close.
+func Launch(ctx context.Context, path string, args ...string) (*Browser, error) {
+	if path == "" {
+		var err error
+		if path, err = findChrome(); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.MkdirTemp("", "chromedp-proxy-")
+	if err != nil {
+		return nil, err
+	}
+	args = append([]string{
+		"--remote-debugging-port=0",
+		"--user-data-dir=" + dir,
+		"--no-first-run",
+		"--no-default-browser-check",
+	}, args...)
+	b := &Browser{
+		cmd:  exec.Command(path, args...),
+		dir:  dir,
+		done: make(chan struct{}),
+	}
+	if err := b.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	go func() {
+		_ = b.cmd.Wait()
+		close(b.done)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, launchTimeout)
+	defer cancel()
+	if b.Addr, err = b.waitPort(ctx); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// waitPort waits for the browser to write its DevToolsActivePort file,
+// returning the address of the browser's remote debugging port.
+func (b *Browser) waitPort(ctx context.Context) (string, error) {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		if port, _, ok := readActivePort(filepath.Join(b.dir, "DevToolsActivePort")); ok {
+			return net.JoinHostPort("127.0.0.1", port), nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("browser did not start listening: %w", ctx.Err())
+		case <-b.done:
+			return "", fmt.Errorf("browser exited before listening: %v", b.cmd.ProcessState)
+		case <-t.C:
+		}
+	}
+}
+
+// readActivePort reads the port and the browser target's path from a
+// DevToolsActivePort file, which contains the port on the first line and the
+// browser target's path on the second. Returns false until the file has been
+// completely written.
+func readActivePort(name string) (string, string, bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", "", false
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, strings.TrimSpace(s.Text()))
+	}
+	if len(lines) < 2 || lines[0] == "" {
+		return "", "", false
+	}
+	return lines[0], lines[1], true
+}
+
+// Close shuts down the browser, killing it when it does not exit promptly,
+// and removes its temporary profile.
+func (b *Browser) Close() error {
+	// windows does not support SIGTERM, so fall back to killing the browser
+	if err := b.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		_ = b.cmd.Process.Kill()
+	}
+	select {
+	case <-b.done:
+	case <-time.After(5 * time.Second):
+		_ = b.cmd.Process.Kill()
+		<-b.done
+	}
+	return os.RemoveAll(b.dir)
+}
+
+// findChrome returns the path of a Chrome or Chromium binary.
+func findChrome() (string, error) {
+	var names []string
+	switch runtime.GOOS {
+	case "darwin":
+		names = []string{
+			"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+			"/Applications/Chromium.app/Contents/MacOS/Chromium",
+		}
+	case "windows":
+		names = []string{
+			"chrome",
+			"chrome.exe",
+			filepath.Join(os.Getenv("ProgramFiles"), `Google\Chrome\Application\chrome.exe`),
+			filepath.Join(os.Getenv("ProgramFiles(x86)"), `Google\Chrome\Application\chrome.exe`),
+			filepath.Join(os.Getenv("LocalAppData"), `Google\Chrome\Application\chrome.exe`),
+		}
+	}
+	names = append(names,
+		"google-chrome",
+		"google-chrome-stable",
+		"chromium",
+		"chromium-browser",
+		"headless_shell",
+		"chrome",
+	)
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("could not find a chrome or chromium binary")
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// limiter limits the proxy's concurrent sessions, and the rate of new
+// sessions.
+type limiter struct {
+	maxConns int64
+	active   atomic.Int64
+
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter creates a limiter for at most maxConns concurrent sessions, and
+// rate new sessions per second. A maxConns or rate of 0 disables the
+// respective limit.
+func newLimiter(maxConns int, rate float64) *limiter {
+	burst := math.Max(1, math.Ceil(rate))
+	return &limiter{
+		maxConns: int64(maxConns),
+		rate:     rate,
+		burst:    burst,
+		tokens:   burst,
+	}
+}
+
+// acquire acquires a session slot, returning the reason the session was
+// rejected when a limit has been reached. The slot must be released with
+// release when acquire succeeds. The concurrent sessions are checked first, so
+// that sessions rejected for them do not take a token from the rate limiter.
+func (l *limiter) acquire() (string, bool) {
+	for {
+		n := l.active.Load()
+		if l.maxConns > 0 && n >= l.maxConns {
+			return "max-conns", false
+		}
+		if l.active.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	if !l.allow() {
+		l.release()
+		return "rate", false
+	}
+	return "", true
+}
+
+// release releases a session slot.
+func (l *limiter) release() {
+	l.active.Add(-1)
+}
+
+// allow takes a token from the rate limiter's token bucket, which is refilled
+// at the rate, and holds at most burst tokens.
+func (l *limiter) allow() bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
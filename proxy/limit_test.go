@@ -0,0 +1,37 @@
+package proxy
+
+import "testing"
+
+func TestLimiter(t *testing.T) {
+	// a burst of 10 sessions per second, with at most 1 concurrent session
+	l := newLimiter(1, 10)
+	if reason, ok := l.acquire(); !ok {
+		t.Fatalf("expected the session to be allowed, got: %s", reason)
+	}
+	// sessions rejected for max-conns do not take tokens
+	for i := 0; i < 20; i++ {
+		if reason, ok := l.acquire(); ok || reason != "max-conns" {
+			t.Fatalf("test %d: expected a max-conns rejection, got: %q", i, reason)
+		}
+	}
+	l.release()
+	if reason, ok := l.acquire(); !ok {
+		t.Fatalf("expected the session to be allowed, got: %s", reason)
+	}
+	l.release()
+	// the remaining tokens of the burst (the refill while the test runs is
+	// negligible)
+	for i := 0; i < 8; i++ {
+		if reason, ok := l.acquire(); !ok {
+			t.Fatalf("test %d: expected the session to be allowed, got: %s", i, reason)
+		}
+		l.release()
+	}
+	if reason, ok := l.acquire(); ok || reason != "rate" {
+		t.Fatalf("expected a rate rejection, got: %q", reason)
+	}
+	// sessions rejected for the rate do not hold a slot
+	if n := l.active.Load(); n != 0 {
+		t.Errorf("expected no active sessions, got: %d", n)
+	}
+}
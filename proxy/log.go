@@ -0,0 +1,597 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Format is a log format.
+type Format string
+
+// Log formats.
+const (
+	// FormatText logs messages as plain text lines.
+	FormatText Format = "text"
+	// FormatJSONL logs messages as one JSON object per line.
+	FormatJSONL Format = "jsonl"
+)
+
+// String satisfies the fmt.Stringer interface.
+func (f Format) String() string {
+	return string(f)
+}
+
+// Set satisfies the flag.Value interface.
+func (f *Format) Set(s string) error {
+	switch v := Format(s); v {
+	case FormatText, FormatJSONL:
+		*f = v
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q", s)
+}
+
+// logOutput is a log destination of a session, with its log format.
+type logOutput struct {
+	w      io.Writer
+	format Format
+	// split is set for the session's log file, when its messages are logged
+	// to per-domain files instead (see WithSplitByDomain)
+	split bool
+}
+
+// stdoutWriter serializes the writes of all sessions to the proxy's stdout
+// writer, so that each log line is written whole (ie, lines larger than a
+// pipe's buffer are not interleaved with the lines of other sessions), and so
+// that writers that are not safe for concurrent use (ie, a bytes.Buffer passed
+// to WithStdout) can be shared by the sessions. Each session's logger writes
+// a line per write.
+type stdoutWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write satisfies the io.Writer interface.
+func (w *stdoutWriter) Write(buf []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(buf)
+}
+
+// createLog creates the log outputs for the specified id based on the proxy's
+// settings, returning the name of the log file (empty when not logging to a
+// file, or when the file is only written for failed sessions, see
+// WithLogOnError). The returned closer is nil when there is no file to be
+// closed by the session (ie, when logging to the shared log file). When the
+// log file cannot be opened, the error is logged and the session is only
+// logged to stdout.
+//
+// Stdout, the log stream and syslog are written in the proxy's stdout format,
+// and log files in the proxy's format. Destinations with the same format share
+// a single output, so that each line is only formatted once. Stdout is left
+// out when the proxy does not mirror file logs to stdout (see WithNoStdout)
+// and the session is logged to a file.
+func (p *Proxy) createLog(id string) (io.Closer, []logOutput, string) {
+	var f io.Closer
+	var filename string
+	var fw io.Writer
+	switch {
+	case p.noLog:
+	case p.single != nil:
+		l, err := p.single.open(&p.logFiles, p.logMaxSize, p.logBackups, p.logGzip)
+		if err != nil {
+			log.Printf("could not open log file %s, logging session %s to stdout only: %v", p.single.filename, id, err)
+			break
+		}
+		filename, fw = p.single.filename, singleWriter{f: l, session: id, text: p.format != FormatJSONL}
+	case p.logMask != "":
+		name := expandLogMask(p.logMask, p.logNames.name(id, p.logNameFunc), time.Now(), p.logSeq.Add(1))
+		if p.logOnError > 0 {
+			// the file is only written when the session fails
+			el := p.newErrorLog(name)
+			f, fw = el, el
+			break
+		}
+		l, err := p.logFiles.open(name, p.logMaxSize, p.logBackups, p.logGzip)
+		if err != nil {
+			log.Printf("could not open log file %s, logging session %s to stdout only: %v", name, id, err)
+			break
+		}
+		filename, f, fw = name, l, l
+		p.pruneLogs()
+	}
+	stdoutFormat := p.stdoutLogFormat()
+	w := io.Discard
+	// with no log files, not logging to stdout discards the session's logs
+	if !p.noStdout || fw == nil && !p.noLog {
+		w = p.stdout
+		if p.color && stdoutFormat != FormatJSONL && w != io.Discard {
+			w = colorWriter{w: p.stdout}
+		}
+	}
+	if p.logStream {
+		w = io.MultiWriter(w, hubWriter{hub: &p.logHub, session: id})
+	}
+	if p.syslog {
+		if l := p.openSyslog(); l != nil {
+			w = io.MultiWriter(w, syslogWriter{l: l, session: id})
+		}
+	}
+	// the messages of split logs are written to the domain files instead
+	split := p.splitDomains && filename != ""
+	switch {
+	case fw == nil:
+		return f, []logOutput{{w: w, format: stdoutFormat}}, filename
+	case w == io.Discard:
+		return f, []logOutput{{w: fw, format: p.format, split: split}}, filename
+	case p.format == stdoutFormat && !split:
+		return f, []logOutput{{w: io.MultiWriter(w, fw), format: p.format}}, filename
+	}
+	return f, []logOutput{{w: w, format: stdoutFormat}, {w: fw, format: p.format, split: split}}, filename
+}
+
+// stdoutLogFormat returns the log format for stdout, which defaults to the
+// proxy's format.
+func (p *Proxy) stdoutLogFormat() Format {
+	if p.stdoutFormat != "" {
+		return p.stdoutFormat
+	}
+	return p.format
+}
+
+// logMaskTimeFormat is the format of the %t log mask token.
+const logMaskTimeFormat = "20060102T150405"
+
+// expandLogMask expands the tokens in the log mask: %s is replaced with the
+// session id, %t with the session's start time, %n with the session's
+// sequence number, and %% with a literal %.
+func expandLogMask(mask, id string, start time.Time, seq int64) string {
+	var b strings.Builder
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '%' || i == len(mask)-1 {
+			b.WriteByte(mask[i])
+			continue
+		}
+		switch i++; mask[i] {
+		case 's':
+			b.WriteString(id)
+		case 't':
+			b.WriteString(start.Format(logMaskTimeFormat))
+		case 'n':
+			b.WriteString(strconv.FormatInt(seq, 10))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(mask[i])
+		}
+	}
+	return b.String()
+}
+
+var cleanRE = regexp.MustCompile(`[^a-zA-Z0-9_\-\.]`)
+
+// CleanLogName returns the devtools id with the characters that are not
+// letters, digits, '_', '-' or '.' removed, for use as the %s token of the log
+// mask. This is the default log name function (see WithLogNameFunc).
+func CleanLogName(id string) string {
+	return cleanRE.ReplaceAllString(id, "")
+}
+
+// ReplaceLogName returns a log name function (see WithLogNameFunc) replacing
+// the characters of the devtools id that are not letters, digits, '_', '-' or
+// '.' with repl, instead of removing them, so that the names stay readable (ie,
+// "a:b" is named "a_b" with a repl of "_"). The characters of repl that are
+// not allowed are removed.
+func ReplaceLogName(repl string) func(id string) string {
+	repl = CleanLogName(repl)
+	return func(id string) string {
+		return cleanRE.ReplaceAllLiteralString(id, repl)
+	}
+}
+
+// logNames maps the cleaned names of the log files' devtools ids back to their
+// ids, so that distinct ids cleaned to the same name are logged to distinct
+// files instead of interleaving in one. Names are held while a session of the
+// id is logged, and released when its log is closed (see release), so that the
+// names of short-lived targets are not kept for the lifetime of the proxy.
+type logNames struct {
+	mu sync.Mutex
+	// ids are the ids of the names in use
+	ids map[string]string
+	// names are the names of the ids, with their number of sessions
+	names map[string]*logName
+}
+
+// logName is the name of a devtools id, and the number of sessions of the id
+// using it.
+type logName struct {
+	name     string
+	sessions int
+}
+
+// name returns the cleaned name of the devtools id, with a "-2", "-3", etc
+// suffix when the name is already used by a different id. Sessions with the
+// same id (ie, reconnecting to the same target) keep the same name while any
+// of them is logged. Each call must be followed by a release of the id.
+func (n *logNames) name(id string, clean func(string) string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if l, ok := n.names[id]; ok {
+		l.sessions++
+		return l.name
+	}
+	if n.ids == nil {
+		n.ids, n.names = make(map[string]string), make(map[string]*logName)
+	}
+	base := clean(id)
+	name := base
+	for i := 2; ; i++ {
+		if _, ok := n.ids[name]; !ok {
+			n.ids[name], n.names[id] = id, &logName{name: name, sessions: 1}
+			return name
+		}
+		name = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// release releases the name of the devtools id held by a session, once the
+// session's log is closed, removing the name when no other session of the id
+// holds it. Releasing an id without a name is a no-op.
+func (n *logNames) release(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	l, ok := n.names[id]
+	if !ok {
+		return
+	}
+	if l.sessions--; l.sessions == 0 {
+		delete(n.ids, l.name)
+		delete(n.names, id)
+	}
+}
+
+// logEntry is a JSON-lines log entry, with the sessionId of messages of
+// flattened target sessions as cdp_session. The hashes of the entry (see
+// WithLogHashes) are its last fields, so that they can be stripped from the
+// marshaled entry when verifying it.
+type logEntry struct {
+	Time       time.Time       `json:"time"`
+	Dir        string          `json:"dir,omitempty"`
+	Seq        int64           `json:"seq,omitempty"`
+	Elapsed    string          `json:"elapsed,omitempty"`
+	Run        string          `json:"run,omitempty"`
+	Remote     string          `json:"remote"`
+	Session    string          `json:"session"`
+	Conn       string          `json:"conn,omitempty"`
+	Binary     bool            `json:"binary,omitempty"`
+	Method     string          `json:"method,omitempty"`
+	ID         *int64          `json:"id,omitempty"`
+	CDPSession string          `json:"cdp_session,omitempty"`
+	Size       int             `json:"size,omitempty"`
+	Msg        json.RawMessage `json:"msg,omitempty"`
+	Log        string          `json:"log,omitempty"`
+	SHA256     string          `json:"sha256,omitempty"`
+	Chain      string          `json:"chain,omitempty"`
+}
+
+// logf logs a session lifecycle message.
+func (s *session) logf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if s.ordered != nil && s.ordered.push(func() { s.writeLog(msg) }) {
+		return
+	}
+	s.writeLog(msg)
+}
+
+// writeLog writes a session lifecycle message to the session's logs.
+func (s *session) writeLog(msg string) {
+	for _, l := range s.logs {
+		if l.format != FormatJSONL {
+			l.logger.Println(msg)
+		} else {
+			l.writeEntry(s, logEntry{Log: msg})
+		}
+	}
+}
+
+// sensitiveHeaders are the headers whose values are masked when logging the
+// handshake headers.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"Set-Cookie":          true,
+}
+
+// logHeaders logs the handshake headers, one line per value, in sorted order,
+// with the values of sensitive headers masked (see WithLogHandshake).
+func (s *session) logHeaders(desc string, h http.Header) {
+	s.logf("%s", desc)
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			if sensitiveHeaders[k] {
+				v = redacted
+			}
+			s.logf("  %s: %s", k, v)
+		}
+	}
+}
+
+// infof logs a session lifecycle banner message (ie, the connection and
+// closing lines), unless the proxy is quiet.
+func (s *session) infof(format string, v ...interface{}) {
+	if !s.p.quiet {
+		s.logf(format, v...)
+	}
+}
+
+// logFrame logs a proxied message, when permitted by the proxy's method
+// filter and sample rules. Only the logged copy of the message is redacted
+// and truncated.
+//
+// Text log lines are tagged with the message's CDP method and id (see
+// frame.tag), after the direction prefix.
+func (s *session) logFrame(f *frame) {
+	// skip parsing and formatting when the session's messages are not logged
+	if s.discard {
+		return
+	}
+	if s.p.filter != nil && !s.p.filter.match(f.message().Method) {
+		return
+	}
+	if len(s.p.logCDPSessions) != 0 && !s.p.logCDPSession(f) {
+		return
+	}
+	if len(s.p.logIDs) != 0 && !s.p.logID(f) {
+		return
+	}
+	if len(s.p.samples) != 0 && !s.logSample(f) {
+		return
+	}
+	if s.p.headFrames > 0 && !s.logHead(f) {
+		return
+	}
+	if s.ordered != nil && s.ordered.pushFrame(f, s.writeFrame) {
+		return
+	}
+	if f.seq == 0 {
+		f.seq = s.seq.Add(1)
+	}
+	if f.elapsed == 0 {
+		f.elapsed = time.Since(s.stats.start)
+	}
+	s.writeFrame(f)
+}
+
+// logHead returns true when the frame is among the first messages of its
+// direction to log (see WithHeadFrames), logging a notice on the first frame
+// past the cap.
+func (s *session) logHead(f *frame) bool {
+	n := s.logged[f.dir].Add(1)
+	if n == s.p.headFrames+1 {
+		peer := "client"
+		if f.dir == Outgoing {
+			peer = "remote"
+		}
+		s.logf("logged the first %d messages from the %s, no longer logging them", s.p.headFrames, peer)
+	}
+	return n <= s.p.headFrames
+}
+
+// writeFrame writes a proxied message to the session's logs.
+func (s *session) writeFrame(f *frame) {
+	if s.p.metadataOnly {
+		s.logMetadata(f)
+		return
+	}
+	if f.typ == websocket.BinaryMessage {
+		s.logBinary(f)
+		return
+	}
+	buf := s.p.redact(f.buf)
+	for _, l := range s.frameLogs(f) {
+		if l.format == FormatJSONL {
+			l.writeEntry(s, logEntry{Dir: f.dir.String(), Seq: s.logSeq(f), Elapsed: s.logElapsed(f), Msg: rawMessage(s.p.truncate(buf, len(f.buf))), CDPSession: f.message().cdpSession()})
+			continue
+		}
+		v := s.textFields(f)
+		if tag := f.tag(); tag != "" {
+			v = append(v, tag)
+		}
+		l.logger.Println(append(v, string(s.p.truncate(s.p.textBytes(buf), len(f.buf))))...)
+	}
+}
+
+// seqField is the text log field of a message's sequence number.
+const seqField = "seq="
+
+// logSeq returns the sequence number of the frame to log, or 0 when sequence
+// numbers are not logged.
+func (s *session) logSeq(f *frame) int64 {
+	if !s.p.logSeqs() {
+		return 0
+	}
+	return f.seq
+}
+
+// textFields returns the leading text log fields of a frame: its direction
+// prefix, sequence number and elapsed time, when logged.
+func (s *session) textFields(f *frame) []interface{} {
+	v := []interface{}{f.dir.prefix()}
+	if s.p.logSeqs() {
+		v = append(v, seqField+strconv.FormatInt(f.seq, 10))
+	}
+	if elapsed := s.logElapsed(f); elapsed != "" {
+		v = append(v, elapsed)
+	}
+	return v
+}
+
+// logElapsed returns the elapsed time since the start of the session of the
+// frame to log (ie, "+00:00:01.234"), or "" when elapsed times are not logged
+// (see WithTimestampFrames).
+func (s *session) logElapsed(f *frame) string {
+	if !s.p.frameTimes {
+		return ""
+	}
+	d := f.elapsed.Truncate(time.Millisecond)
+	h, d := d/time.Hour, d%time.Hour
+	m, d := d/time.Minute, d%time.Minute
+	sec, ms := d/time.Second, d%time.Second/time.Millisecond
+	return fmt.Sprintf("+%02d:%02d:%02d.%03d", h, m, sec, ms)
+}
+
+// logSeqs returns true when the sequence numbers of messages are logged (see
+// WithLogMicros and WithOrderedLog).
+func (p *Proxy) logSeqs() bool {
+	return p.logMicros || p.orderedLog
+}
+
+// sizeField is the text log field of the byte size of a message logged
+// without its payload.
+const sizeField = "size="
+
+// logMetadata logs the direction, CDP method and id, and byte size of a
+// message, without its payload (see WithMetadataOnly).
+func (s *session) logMetadata(f *frame) {
+	var method, cdpSession string
+	var id *int64
+	if f.typ != websocket.BinaryMessage {
+		msg := f.message()
+		method, id, cdpSession = msg.Method, msg.ID, msg.cdpSession()
+	}
+	for _, l := range s.frameLogs(f) {
+		if l.format == FormatJSONL {
+			l.writeEntry(s, logEntry{
+				Dir:        f.dir.String(),
+				Seq:        s.logSeq(f),
+				Elapsed:    s.logElapsed(f),
+				Binary:     f.typ == websocket.BinaryMessage,
+				Method:     method,
+				ID:         id,
+				Size:       len(f.buf),
+				CDPSession: cdpSession,
+			})
+			continue
+		}
+		v := s.textFields(f)
+		if f.typ == websocket.BinaryMessage {
+			v = append(v, binaryTag)
+		} else if tag := f.tag(); tag != "" {
+			v = append(v, tag)
+		}
+		l.logger.Println(append(v, sizeField+strconv.Itoa(len(f.buf)))...)
+	}
+}
+
+// binaryTag is the text log tag for binary messages.
+const binaryTag = "[binary]"
+
+// logBinary logs a binary message, base64 encoded, with a [binary] tag in the
+// text format, or with the binary field set in the jsonl format.
+func (s *session) logBinary(f *frame) {
+	buf := s.p.truncate([]byte(base64.StdEncoding.EncodeToString(f.buf)), len(f.buf))
+	for _, l := range s.frameLogs(f) {
+		if l.format != FormatJSONL {
+			l.logger.Println(append(s.textFields(f), binaryTag, string(buf))...)
+			continue
+		}
+		msg, _ := json.Marshal(string(buf))
+		l.writeEntry(s, logEntry{Dir: f.dir.String(), Seq: s.logSeq(f), Elapsed: s.logElapsed(f), Binary: true, Msg: msg})
+	}
+}
+
+// textBytes returns the bytes of a message for the text log, re-indenting it
+// when pretty printing is enabled and the message is valid JSON.
+func (p *Proxy) textBytes(buf []byte) []byte {
+	if !p.pretty {
+		return buf
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, buf, "", "  "); err != nil {
+		return buf
+	}
+	return b.Bytes()
+}
+
+// sessionLog is a session's logger for a log output.
+type sessionLog struct {
+	logger *log.Logger
+	format Format
+	// split is set when the log's messages are written to the session's
+	// domain logs instead (see WithSplitByDomain)
+	split bool
+
+	// mu guards the chained hash of the log's entries (see WithLogHashes)
+	mu    sync.Mutex
+	chain [sha256.Size]byte
+}
+
+// writeEntry writes a JSON-lines entry for the session to the log.
+func (l *sessionLog) writeEntry(s *session, entry logEntry) {
+	entry.Time, entry.Run, entry.Remote, entry.Session, entry.Conn = time.Now(), s.p.runID, s.remoteAddr, s.id, s.conn.id
+	if s.p.logHashes {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		buf, err := l.hashEntry(entry)
+		if err != nil {
+			l.logger.Printf(`{"log":%q}`, err.Error())
+			return
+		}
+		l.logger.Println(string(buf))
+		return
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.Printf(`{"log":%q}`, err.Error())
+		return
+	}
+	l.logger.Println(string(buf))
+}
+
+// truncate truncates buf to the proxy's max log bytes, appending a notice with
+// the total size of the message.
+func (p *Proxy) truncate(buf []byte, total int) []byte {
+	if p.maxLogBytes <= 0 || len(buf) <= p.maxLogBytes {
+		return buf
+	}
+	n := p.maxLogBytes
+	// do not split a utf-8 sequence
+	for n > 0 && !utf8.RuneStart(buf[n]) {
+		n--
+	}
+	b := make([]byte, n, n+64)
+	copy(b, buf)
+	return append(b, fmt.Sprintf("…(truncated, total=%d bytes)", total)...)
+}
+
+// rawMessage returns buf as a json.RawMessage, quoting it as a JSON string
+// when it is not valid JSON.
+func rawMessage(buf []byte) json.RawMessage {
+	if json.Valid(buf) {
+		return json.RawMessage(buf)
+	}
+	b, _ := json.Marshal(string(buf))
+	return json.RawMessage(b)
+}
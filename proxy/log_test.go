@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestCleanLogName(t *testing.T) {
+	tests := []struct {
+		id, exp string
+	}{
+		{"", ""},
+		{"E2F1A3C0B91D", "E2F1A3C0B91D"},
+		{"page-1_a.b", "page-1_a.b"},
+		{"page/ABC123", "pageABC123"},
+		{"../../etc/passwd", "....etcpasswd"},
+		{"a:b c?d*e", "abcde"},
+		{"ümlaut", "mlaut"},
+	}
+	for i, test := range tests {
+		if s := CleanLogName(test.id); s != test.exp {
+			t.Errorf("test %d (%q): expected %q, got: %q", i, test.id, test.exp, s)
+		}
+	}
+}
+
+func TestLogNames(t *testing.T) {
+	tests := []struct {
+		ids []string
+		exp []string
+	}{
+		{[]string{"A", "B"}, []string{"A", "B"}},
+		{[]string{"A", "A"}, []string{"A", "A"}},
+		{[]string{"a:b", "a/b", "ab", "a:b"}, []string{"ab", "ab-2", "ab-3", "ab"}},
+		{[]string{"a:b", "ab-2", "a/b"}, []string{"ab", "ab-2", "ab-3"}},
+	}
+	for i, test := range tests {
+		var n logNames
+		for j, id := range test.ids {
+			if name := n.name(id, CleanLogName); name != test.exp[j] {
+				t.Errorf("test %d, id %d (%q): expected %q, got: %q", i, j, id, test.exp[j], name)
+			}
+		}
+	}
+}
+
+func TestLogNamesRelease(t *testing.T) {
+	var n logNames
+	for _, id := range []string{"a:b", "a:b", "a/b"} {
+		n.name(id, CleanLogName)
+	}
+	// the name of a:b is still held by its second session
+	n.release("a:b")
+	if name := n.name("ab", CleanLogName); name != "ab-3" {
+		t.Errorf("expected %q, got: %q", "ab-3", name)
+	}
+	n.release("ab")
+	// once released, the name is reused
+	n.release("a:b")
+	if name := n.name("ab", CleanLogName); name != "ab" {
+		t.Errorf("expected %q, got: %q", "ab", name)
+	}
+	n.release("ab")
+	n.release("a/b")
+	n.release("unknown")
+	if len(n.ids) != 0 || len(n.names) != 0 {
+		t.Errorf("expected no names, got: %v", n.ids)
+	}
+}
+
+func TestReplaceLogName(t *testing.T) {
+	tests := []struct {
+		repl, id, exp string
+	}{
+		{"_", "page/ABC123", "page_ABC123"},
+		{"_", "a:b c", "a_b_c"},
+		{"_", "page-1_a.b", "page-1_a.b"},
+		{"-", "page/ABC123", "page-ABC123"},
+		{"", "page/ABC123", "pageABC123"},
+		// the characters of repl that are not allowed are removed
+		{"/", "page/ABC123", "pageABC123"},
+		{"_/", "page/ABC123", "page_ABC123"},
+	}
+	for i, test := range tests {
+		if s := ReplaceLogName(test.repl)(test.id); s != test.exp {
+			t.Errorf("test %d (%q, %q): expected %q, got: %q", i, test.repl, test.id, test.exp, s)
+		}
+	}
+}
+
+func TestLogNameOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		exp  string
+	}{
+		{"default", nil, "P1ab.log"},
+		{"type", []Option{WithLogNameType(true)}, "page_P1ab.log"},
+		{"replace", []Option{WithLogNameFunc(ReplaceLogName("_"))}, "P1_a_b.log"},
+		{"type and replace", []Option{WithLogNameType(true), WithLogNameFunc(ReplaceLogName("_"))}, "page_P1_a_b.log"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := fakeremote.New()
+			defer remote.Close()
+			remote.AddTarget(fakeremote.Target{ID: "P1:a b", Type: "page"})
+			dir := t.TempDir()
+			opts := append([]Option{WithNoLog(false), WithLogMask(filepath.Join(dir, "%s.log"))}, test.opts...)
+			p, stdout := startProxy(t, remote, opts...)
+			c := dialPage(t, p, "P1:a%20b")
+			roundTrip(t, c, `{"id":1,"method":"Page.enable"}`)
+			closeClient(t, c)
+			waitLog(t, stdout, "closing")
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Name() != test.exp {
+				t.Errorf("expected log file %s, got: %v", test.exp, entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// responsesDomain is the domain of the file of messages without a method
+	// (ie, command responses), when splitting logs by domain.
+	responsesDomain = "_responses"
+	// otherDomain is the domain of the file of messages of domains past
+	// maxDomainLogs, as methods are chosen by the client.
+	otherDomain = "_other"
+	// maxDomainLogs is the maximum number of domain files of a session.
+	maxDomainLogs = 64
+)
+
+// domainLogs are the per-domain log files of a session, when splitting logs by
+// CDP domain (see WithSplitByDomain). The files are named after the session's
+// log file, with the domain before the extension (ie, cdp-<id>-Network.log),
+// and are opened on the first message of their domain.
+type domainLogs struct {
+	filename string
+
+	mu    sync.Mutex
+	logs  map[string]*sessionLog
+	files []*rotateFile
+}
+
+// newDomainLogs creates the domain logs next to the session's log file.
+func newDomainLogs(filename string) *domainLogs {
+	return &domainLogs{filename: filename, logs: make(map[string]*sessionLog)}
+}
+
+// domainFilename returns the name of the log file of the domain.
+func (d *domainLogs) domainFilename(domain string) string {
+	ext := filepath.Ext(d.filename)
+	return strings.TrimSuffix(d.filename, ext) + "-" + domain + ext
+}
+
+// log returns the log of the frame's domain, opening its file when needed, or
+// nil when the file could not be opened.
+func (d *domainLogs) log(s *session, f *frame) *sessionLog {
+	domain := responsesDomain
+	if method := f.message().Method; method != "" {
+		domain, _, _ = strings.Cut(method, ".")
+		if domain = CleanLogName(domain); domain == "" {
+			domain = otherDomain
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.logs[domain]; !ok && len(d.logs) >= maxDomainLogs {
+		domain = otherDomain
+	}
+	if l, ok := d.logs[domain]; ok {
+		return l
+	}
+	name := d.domainFilename(domain)
+	p := s.p
+	w, err := p.logFiles.open(name, p.logMaxSize, p.logBackups, p.logGzip)
+	if err != nil {
+		s.logf("could not open log file %s, not logging %s messages: %v", name, domain, err)
+		d.logs[domain] = nil
+		return nil
+	}
+	p.pruneLogs()
+	l := s.newLog(logOutput{w: w, format: p.format})
+	d.logs[domain], d.files = l, append(d.files, w)
+	return l
+}
+
+// close closes the domain log files.
+func (d *domainLogs) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.files {
+		w.Close()
+	}
+	d.files = nil
+}
+
+// frameLogs returns the logs to write a frame to: the session's logs, with the
+// domain log of the frame instead of the session's log file when splitting
+// logs by domain.
+func (s *session) frameLogs(f *frame) []*sessionLog {
+	if s.domains == nil {
+		return s.logs
+	}
+	logs := make([]*sessionLog, 0, len(s.logs))
+	for _, l := range s.logs {
+		if !l.split {
+			logs = append(logs, l)
+		}
+	}
+	if l := s.domains.log(s, f); l != nil {
+		logs = append(logs, l)
+	}
+	return logs
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultLogOnErrorLines is the default maximum number of log lines buffered
+// per session when only logging failed sessions (see WithLogOnError), as used
+// by the command.
+const DefaultLogOnErrorLines = 10000
+
+// errorLog is the log file of a session that is only written when the session
+// fails (see WithLogOnError). The session's log lines are buffered in a ring
+// of the proxy's maximum number of lines, discarding the oldest lines, and
+// written to the log file when the session is closed after failing.
+type errorLog struct {
+	p        *Proxy
+	filename string
+	// failed returns true when the session failed
+	failed func() bool
+
+	mu        sync.Mutex
+	lines     [][]byte
+	next      int
+	discarded int
+}
+
+// newErrorLog creates an error log for the log file.
+func (p *Proxy) newErrorLog(filename string) *errorLog {
+	return &errorLog{p: p, filename: filename}
+}
+
+// Write satisfies the io.Writer interface. Each write is a log line.
+func (l *errorLog) Write(buf []byte) (int, error) {
+	line := append([]byte(nil), buf...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.lines) < l.p.logOnError {
+		l.lines = append(l.lines, line)
+		return len(buf), nil
+	}
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % len(l.lines)
+	l.discarded++
+	return len(buf), nil
+}
+
+// Close satisfies the io.Closer interface, writing the buffered log lines to
+// the log file when the session failed.
+func (l *errorLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed == nil || !l.failed() {
+		return nil
+	}
+	f, err := l.p.logFiles.open(l.filename, l.p.logMaxSize, l.p.logBackups, l.p.logGzip)
+	if err != nil {
+		return err
+	}
+	for i := range l.lines {
+		if _, err := f.Write(l.lines[(l.next+i)%len(l.lines)]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if l.discarded != 0 {
+		log.Printf("session failed, logged to %s (%d earlier lines discarded)", l.filename, l.discarded)
+	} else {
+		log.Printf("session failed, logged to %s", l.filename)
+	}
+	err = f.Close()
+	l.p.pruneLogs()
+	return err
+}
+
+// cleanClose returns true when the error ending a session is a normal or going
+// away close frame from either peer.
+func cleanClose(err error) bool {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+	return closeErr.Code == websocket.CloseNormalClosure || closeErr.Code == websocket.CloseGoingAway
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"sync"
+)
+
+// orderedLogBuffer is the number of log lines of a session queued for the
+// session's log goroutine (see WithOrderedLog).
+const orderedLogBuffer = 1024
+
+// orderedLog serializes a session's log lines through a single goroutine, so
+// that the lines of both directions are logged in a total order, with the
+// logged messages numbered in that order (see WithOrderedLog).
+type orderedLog struct {
+	mu    sync.Mutex
+	q     chan func()
+	done  chan struct{}
+	index int64
+}
+
+// newOrderedLog creates and starts an ordered log.
+func newOrderedLog() *orderedLog {
+	o := &orderedLog{
+		q:    make(chan func(), orderedLogBuffer),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(o.done)
+		for fn := range o.q {
+			fn()
+		}
+	}()
+	return o
+}
+
+// push queues the log line written by fn. Returns false when the log is
+// closed, in which case the line must be written directly.
+func (o *orderedLog) push(fn func()) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.q == nil {
+		return false
+	}
+	o.q <- fn
+	return true
+}
+
+// pushFrame queues the logging of a copy of the frame by fn, numbering the
+// copy with the next index of the log. Returns false when the log is closed.
+func (o *orderedLog) pushFrame(f *frame, fn func(*frame)) bool {
+	// parse the message before copying, so that the copy shares the result
+	f.message()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.q == nil {
+		return false
+	}
+	o.index++
+	c := *f
+	c.seq = o.index
+	o.q <- func() {
+		fn(&c)
+	}
+	return true
+}
+
+// close writes the queued log lines, and closes the log.
+func (o *orderedLog) close() {
+	o.mu.Lock()
+	close(o.q)
+	o.q = nil
+	o.mu.Unlock()
+	<-o.done
+}
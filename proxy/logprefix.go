@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+)
+
+// prefixWriter is a writer for the text log lines of a session, prefixing each
+// line with the proxy's log prefix template (see WithLogPrefix).
+type prefixWriter struct {
+	w io.Writer
+	s *session
+}
+
+// Write satisfies the io.Writer interface. The log lines of proxied messages
+// start with their direction, which is moved to the prefix when the template
+// has a {dir} placeholder.
+func (w prefixWriter) Write(buf []byte) (int, error) {
+	tmpl, line, dir := w.s.p.logPrefix, buf, ""
+	withDir := strings.Contains(tmpl, "{dir}")
+	for _, d := range []Direction{Incoming, Outgoing} {
+		if bytes.HasPrefix(buf, []byte(d.prefix()+" ")) {
+			dir = d.prefix()
+			if withDir {
+				line = buf[len(dir)+1:]
+			}
+		}
+	}
+	layout := textTimeLayout
+	if w.s.p.logMicros {
+		layout += ".000000"
+	}
+	prefix := strings.NewReplacer(
+		"{time}", time.Now().Format(layout),
+		"{session}", w.s.id,
+		"{remote}", w.s.remoteAddr,
+		"{run}", w.s.p.runID,
+		"{dir}", dir,
+	).Replace(tmpl)
+	out := make([]byte, 0, len(prefix)+len(line))
+	out = append(append(out, prefix...), line...)
+	if _, err := w.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
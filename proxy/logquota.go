@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// logGlob returns the glob matching the log files of the log mask, replacing
+// each token of the mask (see expandLogMask) with a wildcard. Glob
+// metacharacters in the mask are escaped (except on windows).
+func logGlob(mask string) string {
+	var b strings.Builder
+	for i := 0; i < len(mask); i++ {
+		c := mask[i]
+		if c == '%' && i < len(mask)-1 {
+			switch i++; mask[i] {
+			case 's', 't', 'n':
+				b.WriteByte('*')
+				continue
+			case '%':
+			default:
+				b.WriteByte('%')
+			}
+			c = mask[i]
+		}
+		// backslashes are path separators, not escapes, on windows
+		if strings.IndexByte(`*?[\`, c) != -1 && filepath.Separator != '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// logMaskLiteral returns true when the file name of the log mask has a
+// literal part besides its tokens (ie, cdp-%s.log, but not %s or %s%n), so
+// that its glob (see logGlob) does not match every file in the log directory.
+func logMaskLiteral(mask string) bool {
+	name := filepath.Base(mask)
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i < len(name)-1 && strings.IndexByte("stn", name[i+1]) != -1 {
+			i++
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// pruneLogs removes the oldest log files matching the log mask (including
+// their rotated backups and gzipped files) while there are more than the
+// proxy's maximum number of log files, or their total size exceeds the
+// proxy's maximum total bytes (see WithLogQuota). Open log files are never
+// removed, but count toward the limits.
+func (p *Proxy) pruneLogs() {
+	if p.logMaxFiles <= 0 && p.logMaxTotal <= 0 {
+		return
+	}
+	p.logPrune.Lock()
+	defer p.logPrune.Unlock()
+	glob := logGlob(p.logMask)
+	names, _ := filepath.Glob(glob)
+	gzipped, _ := filepath.Glob(glob + ".gz")
+	type logFile struct {
+		name string
+		info os.FileInfo
+	}
+	var files []logFile
+	var total int64
+	for _, name := range append(names, gzipped...) {
+		info, err := os.Stat(name)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, logFile{name, info})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	open := p.logFiles.names()
+	count := len(files)
+	for _, f := range files {
+		if (p.logMaxFiles <= 0 || count <= p.logMaxFiles) && (p.logMaxTotal <= 0 || total <= p.logMaxTotal) {
+			return
+		}
+		if open[f.name] {
+			continue
+		}
+		if err := os.Remove(f.name); err != nil {
+			log.Printf("could not remove log file %s: %v", f.name, err)
+			continue
+		}
+		log.Printf("removed log file %s (%d bytes) to stay within the log quota", f.name, f.info.Size())
+		count, total = count-1, total-f.info.Size()
+	}
+}
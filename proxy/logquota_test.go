@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogMaskLiteral(t *testing.T) {
+	tests := []struct {
+		mask string
+		exp  bool
+	}{
+		{"logs/cdp-%s.log", true},
+		{"%s.log", true},
+		{"cdp-%s", true},
+		{"%s-%n", true},
+		{"100%%", true},
+		{"%s", false},
+		{"%n", false},
+		{"%s%n%t", false},
+		{"logs/%s", false},
+		{"logs/cdp-%s/%n", false},
+	}
+	for i, test := range tests {
+		if ok := logMaskLiteral(test.mask); ok != test.exp {
+			t.Errorf("test %d (%q): expected %t, got: %t", i, test.mask, test.exp, ok)
+		}
+	}
+}
+
+func TestServeLogQuotaMask(t *testing.T) {
+	p := New(WithListen(memoryListen), WithLogMask(filepath.Join(t.TempDir(), "%s")), WithLogQuota(1, 0))
+	ln, err := p.Listen()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := p.Serve(context.Background(), ln); err == nil || !strings.Contains(err.Error(), "log quota") {
+		t.Errorf("expected a log quota error, got: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEntry is an entry read from a log written by the proxy.
+type LogEntry struct {
+	// Time is the time the entry was logged.
+	Time time.Time
+	// Session is the devtools id of the session, when available.
+	Session string
+	// Conn is the ULID of the session's client connection, when available.
+	// Only read from the jsonl format.
+	Conn string
+	// Run is the run id of the proxy that wrote the entry, when available
+	// (see WithRunID). Only read from the jsonl format.
+	Run string
+	// Remote is the client address of the session, when available.
+	Remote string
+	// Dir is the direction of a logged message.
+	Dir Direction
+	// Msg is the logged message. Nil for lifecycle entries.
+	Msg []byte
+	// Seq is the session's sequence number of a logged message, when logged
+	// (see WithLogMicros).
+	Seq int64
+	// Elapsed is the time since the start of the session of a logged message,
+	// when logged (see WithTimestampFrames).
+	Elapsed time.Duration
+	// Binary is true when the logged message was a binary websocket message.
+	Binary bool
+	// Truncated is true when the logged message was truncated (see
+	// WithMaxLogBytes).
+	Truncated bool
+	// Metadata is true when the message was logged without its payload (see
+	// WithMetadataOnly), in which case Msg is nil, and Method and ID are the
+	// message's CDP method and id.
+	Metadata bool
+	// Method is the CDP method of a message logged without its payload.
+	Method string
+	// ID is the CDP id of a message logged without its payload, if any.
+	ID *int64
+	// CDPSession is the sessionId of a logged message of a flattened target
+	// session, if any.
+	CDPSession string
+	// Size is the byte size of a logged message, including any truncated
+	// part.
+	Size int
+	// Log is the text of a lifecycle entry (ie, "connecting to ...").
+	Log string
+}
+
+// IsMessage returns true when the entry is a logged message.
+func (e *LogEntry) IsMessage() bool {
+	return e.Msg != nil || e.Metadata
+}
+
+// LogReader reads entries from a log written by the proxy, in either the text
+// or jsonl format.
+type LogReader struct {
+	s    *bufio.Scanner
+	next []byte
+	err  error
+}
+
+// NewLogReader creates a new log reader.
+func NewLogReader(r io.Reader) *LogReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 64*1024*1024)
+	return &LogReader{s: s}
+}
+
+// textLineRE matches the timestamp of a text log line.
+var textLineRE = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) (.*)$`)
+
+// textTimeLayout is the layout of text log timestamps.
+const textTimeLayout = "2006/01/02 15:04:05"
+
+// textTagRE matches the CDP method and id tag of a logged message (see
+// frame.tag).
+var textTagRE = regexp.MustCompile(`^\[(?:([\w.]+)(?: #(-?\d+))?|#(-?\d+)(?: error)?)(?: @([\w.-]+))?\] `)
+
+// textSeqRE matches the sequence number of a logged message (see
+// WithLogMicros).
+var textSeqRE = regexp.MustCompile(`^seq=(\d+) `)
+
+// textElapsedRE matches the elapsed time of a logged message (see
+// WithTimestampFrames).
+var textElapsedRE = regexp.MustCompile(`^\+(\d{2,}):(\d{2}):(\d{2})\.(\d{3}) `)
+
+// parseElapsed parses the elapsed time matched by textElapsedRE.
+func parseElapsed(m []string) time.Duration {
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second, time.Millisecond} {
+		n, _ := strconv.ParseInt(m[i+1], 10, 64)
+		d += time.Duration(n) * unit
+	}
+	return d
+}
+
+// sizeRE matches the byte size of a message logged without its payload (see
+// WithMetadataOnly).
+var sizeRE = regexp.MustCompile(`^size=(\d+)$`)
+
+// truncatedRE matches the truncation notice of a logged message.
+var truncatedRE = regexp.MustCompile(`…\(truncated, total=(\d+) bytes\)$`)
+
+// Next returns the next entry in the log, or io.EOF when there are no more
+// entries.
+func (lr *LogReader) Next() (*LogEntry, error) {
+	line, err := lr.line()
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(line, []byte("{")) {
+		return parseJSONLEntry(line)
+	}
+	m := textLineRE.FindSubmatch(line)
+	if m == nil {
+		// not a log line, skip
+		return lr.Next()
+	}
+	e := new(LogEntry)
+	e.Time, _ = time.ParseInLocation(textTimeLayout, string(m[1]), time.Local)
+	rest := string(lineTagsRE.ReplaceAll(m[2], nil))
+	switch {
+	case strings.HasPrefix(rest, Incoming.prefix()+" "):
+		e.Dir = Incoming
+	case strings.HasPrefix(rest, Outgoing.prefix()+" "):
+		e.Dir = Outgoing
+	default:
+		e.Log = rest
+		return e, nil
+	}
+	rest = rest[3:]
+	if m := textSeqRE.FindStringSubmatchIndex(rest); m != nil {
+		e.Seq, _ = strconv.ParseInt(rest[m[2]:m[3]], 10, 64)
+		rest = rest[m[1]:]
+	}
+	if m := textElapsedRE.FindStringSubmatch(rest); m != nil {
+		e.Elapsed = parseElapsed(m)
+		rest = rest[len(m[0]):]
+	}
+	var method, id string
+	if strings.HasPrefix(rest, binaryTag+" ") {
+		rest, e.Binary = rest[len(binaryTag)+1:], true
+	} else if m := textTagRE.FindStringSubmatch(rest); m != nil {
+		method, id, e.CDPSession = m[1], m[2]+m[3], m[4]
+		rest = rest[len(m[0]):]
+	}
+	if m := sizeRE.FindStringSubmatch(rest); m != nil {
+		e.Size, _ = strconv.Atoi(m[1])
+		e.Metadata, e.Method = true, method
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			e.ID = &n
+		}
+		return e, nil
+	}
+	msg := []byte(rest)
+	// collect continuation lines of pretty printed messages
+	for {
+		next, err := lr.line()
+		if err != nil {
+			break
+		}
+		if textLineRE.Match(next) || bytes.HasPrefix(next, []byte("{\"time\"")) {
+			lr.next = next
+			break
+		}
+		msg = append(append(msg, '\n'), next...)
+	}
+	e.Msg = msg
+	if m := truncatedRE.FindSubmatchIndex(msg); m != nil {
+		e.Msg, e.Truncated = msg[:m[0]], true
+		e.Size, _ = strconv.Atoi(string(msg[m[2]:m[3]]))
+	}
+	if e.Binary {
+		e.Msg = decodeBinary(e.Msg)
+	}
+	if !e.Truncated {
+		e.Size = len(e.Msg)
+	}
+	return e, nil
+}
+
+// decodeBinary decodes a base64 encoded binary message. Truncated messages
+// are decoded up to the last complete base64 quantum.
+func decodeBinary(msg []byte) []byte {
+	msg = msg[:len(msg)/4*4]
+	buf := make([]byte, base64.StdEncoding.DecodedLen(len(msg)))
+	n, _ := base64.StdEncoding.Decode(buf, msg)
+	return buf[:n]
+}
+
+// line returns the next line of the log.
+func (lr *LogReader) line() ([]byte, error) {
+	if lr.next != nil {
+		line := lr.next
+		lr.next = nil
+		return line, nil
+	}
+	if lr.err != nil {
+		return nil, lr.err
+	}
+	if !lr.s.Scan() {
+		if lr.err = lr.s.Err(); lr.err == nil {
+			lr.err = io.EOF
+		}
+		return nil, lr.err
+	}
+	return append([]byte(nil), lr.s.Bytes()...), nil
+}
+
+// parseJSONLEntry parses a jsonl log line.
+func parseJSONLEntry(line []byte) (*LogEntry, error) {
+	var v logEntry
+	if err := json.Unmarshal(line, &v); err != nil {
+		return nil, err
+	}
+	e := &LogEntry{
+		Time:       v.Time,
+		Session:    v.Session,
+		Conn:       v.Conn,
+		Remote:     v.Remote,
+		Run:        v.Run,
+		Seq:        v.Seq,
+		Binary:     v.Binary,
+		Log:        v.Log,
+		CDPSession: v.CDPSession,
+	}
+	if m := textElapsedRE.FindStringSubmatch(v.Elapsed + " "); m != nil {
+		e.Elapsed = parseElapsed(m)
+	}
+	if v.Dir == "" {
+		return e, nil
+	}
+	if v.Dir == Outgoing.String() {
+		e.Dir = Outgoing
+	}
+	if v.Msg == nil {
+		e.Size, e.Metadata, e.Method, e.ID = v.Size, true, v.Method, v.ID
+		return e, nil
+	}
+	e.Msg = []byte(v.Msg)
+	// messages that were not valid JSON (ie, truncated) are logged as strings
+	var s string
+	if json.Unmarshal(v.Msg, &s) == nil {
+		e.Msg = []byte(s)
+		if m := truncatedRE.FindStringSubmatchIndex(s); m != nil {
+			e.Msg, e.Truncated = []byte(s[:m[0]]), true
+			e.Size, _ = strconv.Atoi(s[m[2]:m[3]])
+		}
+	}
+	if e.Binary {
+		e.Msg = decodeBinary(e.Msg)
+	}
+	if !e.Truncated {
+		e.Size = len(e.Msg)
+	}
+	return e, nil
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"sync"
+)
+
+// singleLog is a log file shared by all sessions, opened on first use.
+type singleLog struct {
+	mu       sync.Mutex
+	filename string
+	f        *rotateFile
+}
+
+// open returns the shared log file, opening it in files when it has not been
+// opened yet.
+func (l *singleLog) open(files *logFiles, maxSize int64, backups int, gzip bool) (*rotateFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		f, err := files.open(l.filename, maxSize, backups, gzip)
+		if err != nil {
+			return nil, err
+		}
+		l.f = f
+	}
+	return l.f, nil
+}
+
+// Close satisfies the io.Closer interface.
+func (l *singleLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// singleWriter is a writer for a session's log lines to the shared log file.
+// Text log lines are tagged with the session id after the timestamp, while
+// jsonl log lines already carry the session id.
+type singleWriter struct {
+	f       *rotateFile
+	session string
+	text    bool
+}
+
+// Write satisfies the io.Writer interface.
+func (w singleWriter) Write(buf []byte) (int, error) {
+	line := buf
+	if w.text {
+		n := len(logTimeRE.Find(buf))
+		line = make([]byte, 0, len(buf)+len(w.session)+3)
+		line = append(line, buf[:n]...)
+		line = append(line, "["+w.session+"] "...)
+		line = append(line, buf[n:]...)
+	}
+	// the file's mutex keeps the lines of concurrent sessions from interleaving
+	if _, err := w.f.Write(line); err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
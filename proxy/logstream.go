@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// logViewerBuffer is the number of log lines buffered for each log viewer.
+// Lines are dropped for viewers whose buffer is full.
+const logViewerBuffer = 256
+
+// logHub broadcasts log lines to the connected log viewers.
+type logHub struct {
+	mu      sync.Mutex
+	viewers map[*logViewer]bool
+}
+
+// logViewer is a connected log viewer.
+type logViewer struct {
+	session string
+	lines   chan []byte
+}
+
+// add adds a viewer for the session's log lines (all sessions when empty).
+func (h *logHub) add(session string) *logViewer {
+	v := &logViewer{session: session, lines: make(chan []byte, logViewerBuffer)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.viewers == nil {
+		h.viewers = make(map[*logViewer]bool)
+	}
+	h.viewers[v] = true
+	return v
+}
+
+// remove removes the viewer.
+func (h *logHub) remove(v *logViewer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.viewers, v)
+}
+
+// broadcast sends the session's log line to the viewers, without blocking.
+func (h *logHub) broadcast(session string, line []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for v := range h.viewers {
+		if v.session != "" && v.session != session {
+			continue
+		}
+		select {
+		case v.lines <- line:
+		default:
+		}
+	}
+}
+
+// hubWriter is a writer broadcasting the log lines of a session.
+type hubWriter struct {
+	hub     *logHub
+	session string
+}
+
+// Write satisfies the io.Writer interface.
+func (w hubWriter) Write(buf []byte) (int, error) {
+	// the logger reuses buf, so broadcast a copy
+	w.hub.broadcast(w.session, bytes.TrimSuffix(append([]byte(nil), buf...), []byte{'\n'}))
+	return len(buf), nil
+}
+
+// serveLogs streams the log lines of all sessions (or only the session passed
+// in the session query parameter) to a websocket log viewer.
+func (p *Proxy) serveLogs(res http.ResponseWriter, req *http.Request) {
+	conn, err := p.upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	v := p.logHub.add(req.URL.Query().Get("session"))
+	defer p.logHub.remove(v)
+	// read until the viewer closes the connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	ctx := req.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case line := <-v.lines:
+			if err := conn.WriteMessage(websocket.TextMessage, line); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+)
+
+// sysLogger is the subset of a syslog writer used by the proxy.
+type sysLogger interface {
+	Info(string) error
+	Notice(string) error
+}
+
+// logTimeRE matches the timestamp of a text log line.
+var logTimeRE = regexp.MustCompile(`^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d(?:\.\d+)? `)
+
+// lineTagsRE matches the run id and session id tags following the timestamp
+// of a text log line (see WithRunID and WithLogSingle).
+var lineTagsRE = regexp.MustCompile(`^(?:\[[^\] ]+\] )+`)
+
+// syslogWriter is a writer sending a session's log lines to syslog, tagged
+// with the session id. Proxied messages are sent with the info severity, and
+// all other lines (ie, the session lifecycle lines) with the notice severity.
+type syslogWriter struct {
+	l       sysLogger
+	session string
+}
+
+// Write satisfies the io.Writer interface.
+func (w syslogWriter) Write(buf []byte) (int, error) {
+	// drop the timestamp, as syslog adds its own
+	line := bytes.TrimSuffix(logTimeRE.ReplaceAll(buf, nil), []byte{'\n'})
+	msg := "[" + w.session + "] " + string(line)
+	var err error
+	if isFrameLine(line) {
+		err = w.l.Info(msg)
+	} else {
+		err = w.l.Notice(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// isFrameLine returns true when the text or jsonl log line is a proxied
+// message.
+func isFrameLine(line []byte) bool {
+	line = lineTagsRE.ReplaceAll(line, nil)
+	return bytes.HasPrefix(line, []byte(Incoming.prefix()+" ")) ||
+		bytes.HasPrefix(line, []byte(Outgoing.prefix()+" ")) ||
+		bytes.Contains(line, []byte(`"dir":"`))
+}
+
+// openSyslog returns the proxy's syslog connection, connecting on first use.
+// When syslog is unavailable, the error is logged once, and the proxy logs
+// without syslog.
+func (p *Proxy) openSyslog() sysLogger {
+	p.syslogOnce.Do(func() {
+		var err error
+		if p.sysLogger, err = dialSyslog(p.syslogAddr); err != nil {
+			log.Printf("syslog unavailable, logging without syslog: %v", err)
+		}
+	})
+	return p.sysLogger
+}
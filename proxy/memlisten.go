@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// memoryListen is the listen address of the proxy's in-memory listener.
+const memoryListen = "memory:"
+
+// memoryListener is an in-memory net.Listener, accepting the connections
+// dialed in-process (see Proxy.DialClient), without opening a port.
+type memoryListener struct {
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+// newMemoryListener creates an in-memory listener.
+func newMemoryListener() *memoryListener {
+	return &memoryListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept satisfies the net.Listener interface.
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close satisfies the net.Listener interface.
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr satisfies the net.Listener interface.
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr{}
+}
+
+// dial dials a connection to the listener, as a buffered in-memory pipe (see
+// newMemoryConns).
+func (l *memoryListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := newMemoryConns()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// memoryAddr is the address of the in-memory listener.
+type memoryAddr struct{}
+
+// Network satisfies the net.Addr interface.
+func (memoryAddr) Network() string {
+	return "memory"
+}
+
+// String satisfies the net.Addr interface.
+func (memoryAddr) String() string {
+	return memoryListen
+}
+
+// DialClient opens a client websocket connection to the proxy for the
+// devtools path (ie, /devtools/page/<id>), as a client of the proxy would,
+// through the proxy's in-memory listener (see WithListen), with the header
+// sent with the websocket handshake. The proxy must be served on the listener
+// returned by Listen (ie, with ListenAndServe).
+//
+// This allows driving the proxy end to end (ie, in tests) without opening a
+// port for it.
+func (p *Proxy) DialClient(ctx context.Context, urlpath string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	if p.memory == nil {
+		return nil, nil, errors.New("the proxy does not listen in memory")
+	}
+	d := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return p.memory.dial(ctx)
+		},
+		ReadBufferSize:  p.readBufferSize,
+		WriteBufferSize: p.writeBufferSize,
+	}
+	scheme := "ws://"
+	if p.listenTLS() {
+		// the in-process connection needs no verification
+		scheme = "wss://"
+		d.NetDialTLSContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			c, err := p.memory.dial(ctx)
+			if err != nil {
+				return nil, err
+			}
+			tc := tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+			if err := tc.HandshakeContext(ctx); err != nil {
+				tc.Close()
+				return nil, err
+			}
+			return tc, nil
+		}
+	}
+	return d.DialContext(ctx, scheme+"memory"+urlpath, header)
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestDialClient(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(t *testing.T) []Option
+	}{
+		{"ws", func(*testing.T) []Option { return nil }},
+		{"wss", func(t *testing.T) []Option { return []Option{WithTLS(writeTestCert(t))} }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := fakeremote.New()
+			defer remote.Close()
+			p, stdout := startProxy(t, remote, test.opts(t)...)
+			c := dialPage(t, p, "P1")
+			if buf := roundTrip(t, c, `{"id":1,"method":"Page.enable"}`); string(buf) != `{"id":1,"method":"Page.enable"}` {
+				t.Errorf("expected the echoed command, got: %s", buf)
+			}
+			start := time.Now()
+			closeClient(t, c)
+			log := waitLog(t, stdout, "---------- closing")
+			if !strings.Contains(log, "<- [Page.enable #1]") {
+				t.Errorf("expected the command to be logged, got:\n%s", log)
+			}
+			// both ends of a tls connection write a close_notify alert
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("expected the session to close promptly, took: %v", d)
+			}
+		})
+	}
+}
+
+func TestDialClientNotInMemory(t *testing.T) {
+	p := New(WithListen("localhost:0"))
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, _, err := p.DialClient(ctx, "/devtools/page/P1", nil); err == nil || !strings.Contains(err.Error(), "in memory") {
+		t.Errorf("expected an error, got: %v", err)
+	}
+}
+
+// writeTestCert writes a self-signed certificate for localhost, 127.0.0.1 and
+// ::1, and its key, returning the certificate and key files.
+func writeTestCert(t testing.TB) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return certFile, keyFile
+}
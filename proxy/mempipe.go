@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// memoryPipeSize is the number of bytes buffered by each direction of an
+// in-memory connection, after which writes block until the peer reads.
+const memoryPipeSize = 64 * 1024
+
+// memoryPipe is a direction of an in-memory connection: a bounded buffer
+// written by one end and read by the other. Unlike net.Pipe, writes do not
+// wait for a read, so that both ends can write at once (ie, the close_notify
+// alerts of TLS connections closed by both ends).
+type memoryPipe struct {
+	mu  sync.Mutex
+	buf []byte
+	// rclosed and wclosed are set when the reading and writing ends are
+	// closed
+	rclosed bool
+	wclosed bool
+	// rdl and wdl are the read and write deadlines
+	rdl time.Time
+	wdl time.Time
+	// changed is closed and replaced on every change of the pipe
+	changed chan struct{}
+}
+
+// newMemoryPipe creates an in-memory pipe.
+func newMemoryPipe() *memoryPipe {
+	return &memoryPipe{changed: make(chan struct{})}
+}
+
+// notify wakes the reads and writes waiting on the pipe. The pipe must be
+// locked.
+func (mp *memoryPipe) notify() {
+	close(mp.changed)
+	mp.changed = make(chan struct{})
+}
+
+// wait waits for a change of the pipe, or the deadline, with the pipe
+// locked, returning with the pipe locked.
+func (mp *memoryPipe) wait(deadline time.Time) {
+	changed := mp.changed
+	mp.mu.Unlock()
+	defer mp.mu.Lock()
+	if deadline.IsZero() {
+		<-changed
+		return
+	}
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-changed:
+	case <-t.C:
+	}
+}
+
+// read reads from the pipe, waiting for the writing end.
+func (mp *memoryPipe) read(b []byte) (int, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for {
+		switch {
+		case mp.rclosed:
+			return 0, io.ErrClosedPipe
+		case len(mp.buf) != 0:
+			n := copy(b, mp.buf)
+			mp.buf = mp.buf[n:]
+			mp.notify()
+			return n, nil
+		case mp.wclosed:
+			return 0, io.EOF
+		case !mp.rdl.IsZero() && !time.Now().Before(mp.rdl):
+			return 0, os.ErrDeadlineExceeded
+		}
+		mp.wait(mp.rdl)
+	}
+}
+
+// write writes to the pipe, waiting for the reading end when the buffer is
+// full.
+func (mp *memoryPipe) write(b []byte) (int, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	var n int
+	for n < len(b) {
+		switch {
+		case mp.wclosed || mp.rclosed:
+			return n, io.ErrClosedPipe
+		case !mp.wdl.IsZero() && !time.Now().Before(mp.wdl):
+			return n, os.ErrDeadlineExceeded
+		case len(mp.buf) < memoryPipeSize:
+			m := min(len(b)-n, memoryPipeSize-len(mp.buf))
+			mp.buf = append(mp.buf, b[n:n+m]...)
+			n += m
+			mp.notify()
+			continue
+		}
+		mp.wait(mp.wdl)
+	}
+	return n, nil
+}
+
+// set sets a field of the pipe, waking the waiting reads and writes.
+func (mp *memoryPipe) set(f func()) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	f()
+	mp.notify()
+}
+
+// memoryConn is an end of an in-memory connection (see newMemoryConns).
+type memoryConn struct {
+	rd *memoryPipe
+	wr *memoryPipe
+}
+
+// newMemoryConns creates the two ends of an in-memory connection.
+func newMemoryConns() (net.Conn, net.Conn) {
+	a, b := newMemoryPipe(), newMemoryPipe()
+	return &memoryConn{rd: a, wr: b}, &memoryConn{rd: b, wr: a}
+}
+
+// Read satisfies the net.Conn interface.
+func (c *memoryConn) Read(b []byte) (int, error) {
+	return c.rd.read(b)
+}
+
+// Write satisfies the net.Conn interface.
+func (c *memoryConn) Write(b []byte) (int, error) {
+	return c.wr.write(b)
+}
+
+// Close satisfies the net.Conn interface.
+func (c *memoryConn) Close() error {
+	c.rd.set(func() { c.rd.rclosed = true })
+	c.wr.set(func() { c.wr.wclosed = true })
+	return nil
+}
+
+// LocalAddr satisfies the net.Conn interface.
+func (c *memoryConn) LocalAddr() net.Addr {
+	return pipeAddr{}
+}
+
+// RemoteAddr satisfies the net.Conn interface.
+func (c *memoryConn) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+// SetDeadline satisfies the net.Conn interface.
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	c.rd.set(func() { c.rd.rdl = t })
+	c.wr.set(func() { c.wr.wdl = t })
+	return nil
+}
+
+// SetReadDeadline satisfies the net.Conn interface.
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.rd.set(func() { c.rd.rdl = t })
+	return nil
+}
+
+// SetWriteDeadline satisfies the net.Conn interface.
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	c.wr.set(func() { c.wr.wdl = t })
+	return nil
+}
+
+// pipeAddr is the address of both ends of an in-memory connection.
+type pipeAddr struct{}
+
+// Network satisfies the net.Addr interface.
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+// String satisfies the net.Addr interface.
+func (pipeAddr) String() string {
+	return "pipe"
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the proxy's counters.
+type metrics struct {
+	conns           atomic.Int64
+	active          atomic.Int64
+	msgs            [2]atomic.Int64
+	bytes           [2]atomic.Int64
+	dialFailures    atomic.Int64
+	versionFailures atomic.Int64
+	rejectedConns   atomic.Int64
+	rejectedRate    atomic.Int64
+	evictedFrames   atomic.Int64
+	evictedBytes    atomic.Int64
+	evictedRemote   atomic.Int64
+}
+
+// record records a proxied frame.
+func (m *metrics) record(f *frame) {
+	m.recordSize(f.dir, int64(len(f.buf)))
+}
+
+// recordSize records a proxied message of the direction by its size.
+func (m *metrics) recordSize(dir Direction, n int64) {
+	m.msgs[dir].Add(1)
+	m.bytes[dir].Add(n)
+}
+
+// rejected records a connection rejected for the limiter reason.
+func (m *metrics) rejected(reason string) {
+	if reason == "rate" {
+		m.rejectedRate.Add(1)
+	} else {
+		m.rejectedConns.Add(1)
+	}
+}
+
+// evicted records a session evicted for the eviction reason.
+func (m *metrics) evicted(reason string) {
+	switch reason {
+	case evictMaxFrames:
+		m.evictedFrames.Add(1)
+	case evictMaxBytes:
+		m.evictedBytes.Add(1)
+	case evictMaxRemoteBytes:
+		m.evictedRemote.Add(1)
+	}
+}
+
+// runStats logs the proxy's throughput at the proxy's stats interval, until the
+// context is done (see WithStatsInterval).
+func (p *Proxy) runStats(ctx context.Context) {
+	ticker := time.NewTicker(p.statsInterval)
+	defer ticker.Stop()
+	m := &p.metrics
+	var msgs, bytes [2]int64
+	for dir := range msgs {
+		msgs[dir], bytes[dir] = m.msgs[dir].Load(), m.bytes[dir].Load()
+	}
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			secs := now.Sub(last).Seconds()
+			var msgRates, byteRates [2]float64
+			for dir := range msgs {
+				n, b := m.msgs[dir].Load(), m.bytes[dir].Load()
+				msgRates[dir], byteRates[dir] = float64(n-msgs[dir])/secs, float64(b-bytes[dir])/secs
+				msgs[dir], bytes[dir] = n, b
+			}
+			last = now
+			log.Printf(
+				"stats: %d active connections, in %.1f messages/s (%.0f bytes/s), out %.1f messages/s (%.0f bytes/s)",
+				m.active.Load(),
+				msgRates[Incoming], byteRates[Incoming],
+				msgRates[Outgoing], byteRates[Outgoing],
+			)
+		}
+	}
+}
+
+// Collector returns a prometheus collector for the proxy's metrics.
+func (p *Proxy) Collector() prometheus.Collector {
+	return &collector{p: p}
+}
+
+// metric descriptions.
+var (
+	connsDesc = prometheus.NewDesc(
+		"chromedp_proxy_connections_total",
+		"Total number of proxied devtools connections.",
+		nil, nil,
+	)
+	activeDesc = prometheus.NewDesc(
+		"chromedp_proxy_active_connections",
+		"Number of active devtools connections.",
+		nil, nil,
+	)
+	msgsDesc = prometheus.NewDesc(
+		"chromedp_proxy_messages_total",
+		"Total number of proxied messages.",
+		[]string{"direction"}, nil,
+	)
+	bytesDesc = prometheus.NewDesc(
+		"chromedp_proxy_bytes_total",
+		"Total number of proxied message bytes.",
+		[]string{"direction"}, nil,
+	)
+	dialFailuresDesc = prometheus.NewDesc(
+		"chromedp_proxy_dial_failures_total",
+		"Total number of failed websocket dials to the remote.",
+		nil, nil,
+	)
+	versionFailuresDesc = prometheus.NewDesc(
+		"chromedp_proxy_version_check_failures_total",
+		"Total number of failed version checks against the remote.",
+		nil, nil,
+	)
+	rejectedDesc = prometheus.NewDesc(
+		"chromedp_proxy_rejected_connections_total",
+		"Total number of devtools connections rejected by the connection limits.",
+		[]string{"reason"}, nil,
+	)
+	evictedDesc = prometheus.NewDesc(
+		"chromedp_proxy_evicted_sessions_total",
+		"Total number of devtools sessions closed for exceeding their budgets.",
+		[]string{"reason"}, nil,
+	)
+)
+
+// collector is a prometheus collector for a proxy's metrics.
+type collector struct {
+	p *Proxy
+}
+
+// Describe satisfies the prometheus.Collector interface.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connsDesc
+	ch <- activeDesc
+	ch <- msgsDesc
+	ch <- bytesDesc
+	ch <- dialFailuresDesc
+	ch <- versionFailuresDesc
+	ch <- rejectedDesc
+	ch <- evictedDesc
+}
+
+// Collect satisfies the prometheus.Collector interface.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	m := &c.p.metrics
+	ch <- prometheus.MustNewConstMetric(connsDesc, prometheus.CounterValue, float64(m.conns.Load()))
+	ch <- prometheus.MustNewConstMetric(activeDesc, prometheus.GaugeValue, float64(m.active.Load()))
+	for _, dir := range []Direction{Incoming, Outgoing} {
+		ch <- prometheus.MustNewConstMetric(msgsDesc, prometheus.CounterValue, float64(m.msgs[dir].Load()), dir.String())
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(m.bytes[dir].Load()), dir.String())
+	}
+	ch <- prometheus.MustNewConstMetric(dialFailuresDesc, prometheus.CounterValue, float64(m.dialFailures.Load()))
+	ch <- prometheus.MustNewConstMetric(versionFailuresDesc, prometheus.CounterValue, float64(m.versionFailures.Load()))
+	ch <- prometheus.MustNewConstMetric(rejectedDesc, prometheus.CounterValue, float64(m.rejectedConns.Load()), "max-conns")
+	ch <- prometheus.MustNewConstMetric(rejectedDesc, prometheus.CounterValue, float64(m.rejectedRate.Load()), "rate")
+	ch <- prometheus.MustNewConstMetric(evictedDesc, prometheus.CounterValue, float64(m.evictedFrames.Load()), evictMaxFrames)
+	ch <- prometheus.MustNewConstMetric(evictedDesc, prometheus.CounterValue, float64(m.evictedBytes.Load()), evictMaxBytes)
+	ch <- prometheus.MustNewConstMetric(evictedDesc, prometheus.CounterValue, float64(m.evictedRemote.Load()), evictMaxRemoteBytes)
+}
+
+// metricsHandler returns a http.Handler serving the proxy's metrics, along
+// with the standard go and process metrics.
+func (p *Proxy) metricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		p.Collector(),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	return mux
+}
@@ -0,0 +1,1308 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// Option is a proxy option.
+type Option func(*Proxy)
+
+// WithListen is a proxy option to set the listen address. Addresses prefixed
+// with "unix:" (ie, "unix:/tmp/cdp.sock") listen on a unix socket, and the
+// "memory:" address listens in memory, for clients connected in-process with
+// Proxy.DialClient (ie, in tests).
+func WithListen(listen string) Option {
+	return func(p *Proxy) {
+		p.listen = listen
+	}
+}
+
+// WithRemote is a proxy option to set the default remote address, served at
+// the root of the proxy. An empty remote removes the default remote.
+//
+// The remote can be either a host:port address, or a full URL (ie,
+// https://host:port or wss://host:port) when the remote is served over TLS.
+// The path of a full URL (ie, https://host/chrome, for a remote behind a
+// reverse proxy) is the prefix of all of the remote's endpoints, both the
+// http endpoints and the websockets. A comma-separated list of addresses (ie,
+// localhost:9222,localhost:9232) sets fallback remotes: when the version check
+// or websocket connection for a session fails, the session fails over to the
+// next address, in order.
+func WithRemote(remote string) Option {
+	return func(p *Proxy) {
+		p.setRemote("", remote)
+	}
+}
+
+// WithRemoteHTTP is a proxy option to set the address of the default remote's
+// http endpoints (ie, /json and /json/version), for remotes whose discovery
+// endpoints are not served by their websocket backend (see WithRemoteWS). The
+// websocket address is unchanged. Has no effect without a default remote.
+func WithRemoteHTTP(remote string) Option {
+	return func(p *Proxy) {
+		if r := p.remoteByName(""); r != nil {
+			nr := newRemote("", remote)
+			r.host, r.secure, r.path = nr.host, nr.secure, nr.path
+		}
+	}
+}
+
+// WithRemoteWS is a proxy option to set the address the default remote's
+// websockets are dialed on, when not served by the same host as the remote's
+// http endpoints (see WithRemoteHTTP). Has no effect without a default remote.
+func WithRemoteWS(remote string) Option {
+	return func(p *Proxy) {
+		if r := p.remoteByName(""); r != nil {
+			r.ws = newRemote("", remote)
+		}
+	}
+}
+
+// WithNamedRemote is a proxy option to add a named remote, served under the
+// /<name>/ path prefix of the proxy (ie, /<name>/json and
+// /<name>/devtools/...). Sessions for a named remote are logged with the id
+// <name>-<id>.
+func WithNamedRemote(name, remote string) Option {
+	return func(p *Proxy) {
+		p.setRemote(name, remote)
+	}
+}
+
+// WithWSPath is a proxy option to set the path prefix of the remote's
+// websocket endpoints (ie, "/ws/" for a remote serving targets at
+// /ws/page/<id>), for remotes other than Chrome exposing the devtools protocol
+// under a different path than DefaultWSPath. The prefix is served the same
+// way as /devtools/, with the remote's endpoint built from the request's path.
+func WithWSPath(wsPath string) Option {
+	return func(p *Proxy) {
+		p.wsPath = wsPath
+	}
+}
+
+// WithNoNormalizeHost is a proxy option to use the websocket urls reported by
+// the remote (the webSocketDebuggerUrl of /json/version and /json/new) as is.
+// By default, the scheme and host of the urls are replaced with the remote's,
+// as remotes can report a host that is not reachable from the proxy (ie, a
+// container-internal hostname).
+func WithNoNormalizeHost(noNormalizeHost bool) Option {
+	return func(p *Proxy) {
+		p.noNormalizeHost = noNormalizeHost
+	}
+}
+
+// WithNoVersionCheck is a proxy option to skip checking the remote's
+// /json/version before connecting a session, for remotes that only expose the
+// websocket endpoints (ie, minimal CDP implementations). Sessions are then
+// not tagged with the browser's version, and the health endpoint only checks
+// that the remote accepts connections.
+func WithNoVersionCheck(noVersionCheck bool) Option {
+	return func(p *Proxy) {
+		p.noVersionCheck = noVersionCheck
+	}
+}
+
+// WithMinProtocol is a proxy option to refuse sessions to a remote whose
+// /json/version reports a devtools protocol version older than the version
+// (ie, 1.3), compared numerically, component by component. Cannot be combined
+// with WithNoVersionCheck.
+func WithMinProtocol(version string) Option {
+	return func(p *Proxy) {
+		p.minProtocol = version
+	}
+}
+
+// WithRemoteInsecure is a proxy option to skip verification of the remote's
+// TLS certificate.
+func WithRemoteInsecure(remoteInsecure bool) Option {
+	return func(p *Proxy) {
+		p.remoteInsecure = remoteInsecure
+	}
+}
+
+// WithRemoteFile is a proxy option to read the address of the default remote
+// from the file (ie, written by a browser launcher), re-read on each request,
+// so that the proxy follows a relaunched browser. The address is the file's
+// first non-empty line, with the same format as WithRemote. Requests are
+// rejected while the file does not exist or is empty.
+func WithRemoteFile(filename string) Option {
+	return func(p *Proxy) {
+		p.remoteFile = filename
+	}
+}
+
+// WithDevToolsActivePort is a proxy option to read the default remote from a
+// browser's DevToolsActivePort file (ie, <user-data-dir>/DevToolsActivePort),
+// which the browser writes with the port it listens on, and the browser
+// target's path. This finds a browser launched with an ephemeral port (ie,
+// --remote-debugging-port=0) by a launcher out of the proxy's control. The
+// file is re-read on each request, like the file of WithRemoteFile, and the
+// browser is reached on 127.0.0.1, so it must run on the proxy's host.
+func WithDevToolsActivePort(filename string) Option {
+	return func(p *Proxy) {
+		p.activePortFile = filename
+	}
+}
+
+// WithRemoteSelect is a proxy option to let each request select the remote it
+// is served by with the X-CDP-Remote header (ie, X-CDP-Remote: host:port), for
+// a single proxy serving many browsers chosen by its callers. The address has
+// the same format as WithRemote, and must match one of the rules, otherwise
+// the request is rejected. Rules are a host and an exact port, the host being
+// an IP address, a CIDR, or a glob matching each of the host's dot-separated
+// labels (ie, 10.0.0.5:9222, 10.0.0.0/24:9222, 10.0.0.*:9222, or
+// *.browsers.internal:9222). Requests with the header are served at the root,
+// both the http endpoints and the websockets, and the header is not sent to
+// the remote. When the proxy has trusted proxies (see WithTrustedProxies), the
+// header is only accepted from them, and otherwise only from peers on the
+// proxy's host (the header of other peers is ignored).
+func WithRemoteSelect(rules ...string) Option {
+	return func(p *Proxy) {
+		p.remoteSelect = append(p.remoteSelect, rules...)
+	}
+}
+
+// WithSRV is a proxy option to replace the default remote with the pool of
+// backends listed by the DNS SRV record with the name (ie,
+// _cdp._tcp.example.com), re-resolved at the interval. Sessions are balanced
+// across the backends by their active sessions, and stay on their backend for
+// their lifetime. The name can be prefixed with https:// for backends served
+// over TLS.
+func WithSRV(name string, interval time.Duration) Option {
+	return func(p *Proxy) {
+		p.srv = name
+		if interval > 0 {
+			p.srvInterval = interval
+		}
+	}
+}
+
+// WithVersionFile is a proxy option to write the default remote's
+// /json/version output, as returned by the remote, to the file when the proxy
+// starts, for a record of the browser build used by a run (ie, in CI). The
+// file is rewritten whenever the remote reports a different version (ie,
+// after a relaunched browser is read from the remote file, see
+// WithRemoteFile). When the remote is unavailable at startup, the file is
+// written once the remote's version is first checked for a session.
+func WithVersionFile(filename string) Option {
+	return func(p *Proxy) {
+		p.versionFile = filename
+	}
+}
+
+// WithVersionOverride is a proxy option to override fields of the remote's
+// /json/version output as proxied to clients (ie, "User-Agent" or "Browser"),
+// for masking or masquerading the browser's identity. Fields overridden with
+// an empty value are removed. The proxy's own checks of the remote's version
+// use the remote's actual fields.
+func WithVersionOverride(overrides map[string]string) Option {
+	return func(p *Proxy) {
+		if p.versionOverride == nil {
+			p.versionOverride = make(map[string]string)
+		}
+		for k, v := range overrides {
+			p.versionOverride[k] = v
+		}
+	}
+}
+
+// WithRemoteHeader is a proxy option to send the header (ie, Authorization or
+// Cookie) with the requests to the remote: its websocket handshakes, and its
+// http endpoints (including /json/version for the version check), replacing
+// any client values of the same headers.
+func WithRemoteHeader(header http.Header) Option {
+	return func(p *Proxy) {
+		p.remoteHeader = header
+	}
+}
+
+// WithResponseHeaders is a proxy option to copy the headers with the names
+// (ie, "Set-Cookie") from the remote's websocket handshake response to the
+// client's, so that the client sees the headers set by the remote. The
+// websocket handshake headers (Upgrade, Connection, Sec-WebSocket-*) are set
+// by the proxy, and are never copied. By default, no header is copied.
+func WithResponseHeaders(names ...string) Option {
+	return func(p *Proxy) {
+		p.resHeaders = append(p.resHeaders, names...)
+	}
+}
+
+// WithForwardedFor is a proxy option to send the client's address with the
+// websocket handshakes to the remote in a X-Forwarded-For header, appended to
+// the addresses forwarded by a trusted proxy (see WithTrustedProxies), so that
+// the client's address is preserved through a chain of proxies.
+func WithForwardedFor(forwardFor bool) Option {
+	return func(p *Proxy) {
+		p.forwardFor = forwardFor
+	}
+}
+
+// WithTrustedProxies is a proxy option to trust the X-Forwarded-For header of
+// websocket connections from the addresses (ie, an outer chromedp-proxy), using
+// the forwarded client address in the session's logs, hooks and admin endpoint
+// instead of the proxy's address. The client address is the rightmost
+// forwarded address that is not itself trusted. By default, no proxy is
+// trusted, and the header is ignored.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(p *Proxy) {
+		p.trustedProxies = append(p.trustedProxies, prefixes...)
+	}
+}
+
+// WithRemoteProxy is a proxy option to connect to the remote (both its http
+// endpoints and websockets) through the http proxy at the url, instead of the
+// proxy set by the environment (HTTP_PROXY, HTTPS_PROXY, and NO_PROXY).
+func WithRemoteProxy(u *url.URL) Option {
+	return func(p *Proxy) {
+		p.remoteProxy = u
+	}
+}
+
+// WithNoLog is a proxy option to disable logging to file.
+func WithNoLog(noLog bool) Option {
+	return func(p *Proxy) {
+		p.noLog = noLog
+	}
+}
+
+// WithLogMask is a proxy option to set the log file mask. A "%s" in the mask
+// is replaced with the devtools id of the session, a "%t" with the session's
+// start time (ie, 20240102T150405), and a "%n" with a sequence number
+// increasing with each session. An empty mask disables logging to file.
+func WithLogMask(logMask string) Option {
+	return func(p *Proxy) {
+		p.logMask = logMask
+	}
+}
+
+// WithLogOnError is a proxy option to only write the log files of sessions
+// that fail (ie, a session whose remote could not be reached, or that closed
+// without a normal or going away close frame), so that successful sessions
+// leave no log file. The log lines of each session are buffered in memory,
+// keeping up to maxLines of the latest lines, and written to the session's log
+// file when the session is closed. A maxLines of 0 (the default) writes all log
+// files as the session goes.
+//
+// Only the per-session log files are buffered (see WithLogMask), not the
+// shared log file (see WithLogSingle), nor stdout.
+func WithLogOnError(maxLines int) Option {
+	return func(p *Proxy) {
+		p.logOnError = maxLines
+	}
+}
+
+// WithLogNameFunc is a proxy option to set the function returning the %s token
+// of the log mask for a session's devtools id (CleanLogName, by default). When
+// two distinct ids are given the same name, the later one is suffixed with a
+// counter (ie, "-2"), so that their sessions are not logged to the same file.
+func WithLogNameFunc(f func(id string) string) Option {
+	return func(p *Proxy) {
+		p.logNameFunc = f
+	}
+}
+
+// WithLogNameType is a proxy option to prefix the devtools id of the %s token
+// of the log mask with the session's target type from the devtools path (ie,
+// "page_<id>" for /devtools/page/<id>, or "browser_<id>"), so that the log
+// files of the distinct target types are told apart by name.
+func WithLogNameType(logNameType bool) Option {
+	return func(p *Proxy) {
+		p.logNameType = logNameType
+	}
+}
+
+// WithLogSingle is a proxy option to log all sessions to a single shared log
+// file instead of a file per session, so that the log lines of concurrent
+// sessions are kept in chronological order. Text log lines are tagged with the
+// session id (ie, "[<id>] <- {...}"), and jsonl log lines already carry the
+// session id. The shared log file is rotated and gzipped like per-session log
+// files (see WithLogRotate and WithLogGzip).
+func WithLogSingle(logSingle string) Option {
+	return func(p *Proxy) {
+		p.logSingle = logSingle
+	}
+}
+
+// WithLogRotate is a proxy option to rotate log files once they reach
+// maxSize bytes, keeping at most backups old files (named cdp-<id>.1.log,
+// cdp-<id>.2.log, ...). A maxSize of 0 disables rotation.
+func WithLogRotate(maxSize int64, backups int) Option {
+	return func(p *Proxy) {
+		p.logMaxSize, p.logBackups = maxSize, backups
+	}
+}
+
+// WithLogGzip is a proxy option to gzip log files once they have been closed
+// or rotated. Log files are written uncompressed while open, and are renamed
+// to <name>.gz when compressed.
+func WithLogGzip(logGzip bool) Option {
+	return func(p *Proxy) {
+		p.logGzip = logGzip
+	}
+}
+
+// WithLogS3 is a proxy option to upload each log file to the s3 bucket once it
+// has been closed (ie, when its session closes), as the object
+// <prefix>/<file name>, removing the local file. Rotated backups of the file
+// are uploaded with it, and log files are uploaded after they have been
+// gzipped (see WithLogGzip). A file failing to upload is kept, and the error
+// logged. Requests are signed with AWS Signature Version 4.
+func WithLogS3(cfg S3Config) Option {
+	return func(p *Proxy) {
+		p.logS3 = &cfg
+	}
+}
+
+// WithLogQuota is a proxy option to remove the oldest per-session log files
+// (those matching the log mask, including their rotated backups and gzipped
+// files) when a session's log file is opened and there are more than
+// maxFiles log files, or their total size exceeds maxTotalBytes. Open log
+// files are never removed. A limit of 0 disables it. The file name of the log
+// mask must have a literal part (ie, cdp-%s.log, but not %s), so that the
+// quota does not remove other files of the log directory.
+func WithLogQuota(maxFiles int, maxTotalBytes int64) Option {
+	return func(p *Proxy) {
+		p.logMaxFiles, p.logMaxTotal = maxFiles, maxTotalBytes
+	}
+}
+
+// WithNoStdout is a proxy option to not mirror the logs of sessions logged to
+// a file to stdout (see WithStdout). Sessions whose log file cannot be opened
+// are still logged to stdout. With WithNoLog, the session logs are discarded,
+// and the messages of sessions are copied through without being read in full,
+// when no other option needs them.
+func WithNoStdout(noStdout bool) Option {
+	return func(p *Proxy) {
+		p.noStdout = noStdout
+	}
+}
+
+// WithStdout is a proxy option to set the writer that logs are mirrored to
+// (defaults to os.Stdout). The writes of all sessions to the writer are
+// serialized, a log line per write, so the writer need not be safe for
+// concurrent use.
+func WithStdout(stdout io.Writer) Option {
+	return func(p *Proxy) {
+		p.stdout = stdout
+	}
+}
+
+// WithSyslog is a proxy option to also send the log lines of all sessions to
+// syslog, tagged with the session id. The logs are sent to the syslog daemon
+// at addr (ie, udp://host:514 or tcp://host:514), or to the local syslog
+// daemon when addr is empty. Proxied messages are sent with the info severity,
+// and the session lifecycle lines with the notice severity.
+//
+// When syslog is not available (ie, on Windows), an error is logged and the
+// proxy logs without syslog.
+func WithSyslog(syslog bool, addr string) Option {
+	return func(p *Proxy) {
+		p.syslog, p.syslogAddr = syslog, addr
+	}
+}
+
+// WithQuiet is a proxy option to not log the session lifecycle banner lines
+// (ie, the connection, endpoint version, connecting and upgrading lines).
+// Proxied messages, errors and session summaries are still logged.
+func WithQuiet(quiet bool) Option {
+	return func(p *Proxy) {
+		p.quiet = quiet
+	}
+}
+
+// WithDetachOnClose is a proxy option to send an Inspector.detached event
+// (with the target_closed reason, as sent by the browser) to the client before
+// the proxy closes its session: when the proxy is shut down, so that clients
+// can close their sessions while the proxy drains them (see
+// WithShutdownTimeout), and when a session is closed by the proxy (ie, on an
+// idle timeout). The event is not sent once the session's close has been
+// forwarded to the client.
+func WithDetachOnClose(detachOnClose bool) Option {
+	return func(p *Proxy) {
+		p.detachOnClose = detachOnClose
+	}
+}
+
+// WithAdmin is a proxy option to serve a /admin/sessions endpoint, listing the
+// active devtools sessions as json (see Proxy.Sessions), with their client
+// address, devtools id, start time, message and byte counts in each direction,
+// and time of last activity. The forwarding of a session can be paused and
+// resumed with a POST to /admin/sessions/<id>/pause and
+// /admin/sessions/<id>/resume, by its devtools or connection id: while paused,
+// the proxy stops reading from both of the session's connections (the idle
+// timeout still applies, see WithIdleTimeout). The sessions recently evicted
+// for exceeding their budgets (see WithSessionMaxBytes) are listed by
+// /admin/evictions, with the budget they exceeded (see Proxy.Evictions). The
+// endpoints are protected by the proxy's basic auth credentials, when set (see
+// WithBasicAuth).
+func WithAdmin(admin bool) Option {
+	return func(p *Proxy) {
+		p.admin = admin
+	}
+}
+
+// WithLogStream is a proxy option to serve a /logs websocket endpoint, which
+// streams the log lines of all sessions to connected viewers in real time. A
+// viewer can pass a session query parameter (ie, /logs?session=<id>) to only
+// receive the log lines of a single session. Log lines are dropped for viewers
+// that do not keep up, so that slow viewers never stall the proxied sessions.
+func WithLogStream(logStream bool) Option {
+	return func(p *Proxy) {
+		p.logStream = logStream
+	}
+}
+
+// WithColor is a proxy option to colorize the direction prefix, and the CDP
+// method and id fields, of text log lines written to stdout. Log files are
+// never colorized.
+func WithColor(color bool) Option {
+	return func(p *Proxy) {
+		p.color = color
+	}
+}
+
+// WithFormat is a proxy option to set the log format.
+func WithFormat(format Format) Option {
+	return func(p *Proxy) {
+		p.format = format
+	}
+}
+
+// WithStdoutFormat is a proxy option to set the log format of stdout (and of
+// the log stream and syslog) independently of the log files' format (ie, jsonl
+// on stdout for a supervising process, and text in the log files). An empty
+// format uses the log format (see WithFormat).
+func WithStdoutFormat(stdoutFormat Format) Option {
+	return func(p *Proxy) {
+		p.stdoutFormat = stdoutFormat
+	}
+}
+
+// WithRunID is a proxy option to tag the session log lines with the run id,
+// to tell apart the log lines of multiple proxy processes collected together
+// (ie, "[<run id>] <- {...}" after the timestamp in the text format, or "run"
+// in the jsonl format).
+func WithRunID(runID string) Option {
+	return func(p *Proxy) {
+		p.runID = runID
+	}
+}
+
+// WithLogPrefix is a proxy option to set the template of the prefix of text
+// log lines, replacing the default timestamp (and run id) prefix, to match the
+// schema of an existing log pipeline. The {time} placeholder is replaced with
+// the line's timestamp (in the default format, ie, 2024/01/02 15:04:05), the
+// {session} placeholder with the session's devtools id, the {remote}
+// placeholder with the session's client address, the {run} placeholder with
+// the run id (see WithRunID), and the {dir} placeholder with the direction of
+// proxied messages ("<-" or "->", empty for other lines), which is then no
+// longer logged after the prefix. An empty template keeps the default prefix,
+// which is equivalent to "{time} ".
+//
+// Logs with other prefixes than the default cannot be read back (see
+// NewLogReader).
+func WithLogPrefix(template string) Option {
+	return func(p *Proxy) {
+		p.logPrefix = template
+	}
+}
+
+// WithTimestampFrames is a proxy option to log each message with the elapsed
+// time since the start of its session (ie, "+00:00:01.234"), taken when the
+// message was read, after its sequence number in the text format, and as the
+// elapsed field in the jsonl format.
+func WithTimestampFrames(timestampFrames bool) Option {
+	return func(p *Proxy) {
+		p.frameTimes = timestampFrames
+	}
+}
+
+// WithHeadFrames is a proxy option to only log the first n messages of each
+// direction of a session (ie, the startup sequence of enabling domains and
+// navigating), still forwarding the later messages, with a notice once a
+// direction's cap is hit. The cap applies to messages permitted by the method
+// filter (see WithInclude). A cap of 0 logs all messages.
+func WithHeadFrames(n int64) Option {
+	return func(p *Proxy) {
+		p.headFrames = n
+	}
+}
+
+// WithSample is a proxy option to only log a random sample of the messages
+// with a CDP method matching the glob (ie, Network.dataReceived), at the rate
+// (ie, 0.01 logs 1 in 100 on average), for a sense of a chatty event stream
+// without logging all of it. All messages are still forwarded, and the
+// numbers of sampled and logged messages are added to each session's summary.
+// The option can be passed more than once, with a message sampled by the
+// first matching glob. The rate must be between 0 and 1.
+func WithSample(glob string, rate float64) Option {
+	return func(p *Proxy) {
+		p.samples = append(p.samples, sampleRule{glob: glob, rate: rate})
+	}
+}
+
+// WithSplitByDomain is a proxy option to log the messages of each session to
+// a file per CDP domain, named after the session's log file with the domain of
+// the messages' methods before the extension (ie, cdp-<id>-Network.log), and
+// messages without a method (ie, command responses) to a _responses file.
+// The session's log file keeps the lifecycle lines, and stdout still logs all
+// messages. Only the per-session log files are split (see WithLogMask), not the
+// shared log file (see WithLogSingle), nor the files of failed sessions (see
+// WithLogOnError).
+func WithSplitByDomain(splitDomains bool) Option {
+	return func(p *Proxy) {
+		p.splitDomains = splitDomains
+	}
+}
+
+// WithLogHashes is a proxy option to add the sha256 of each jsonl log entry
+// (as the sha256 field), and the chained hash of the session's entries so far
+// (as the chain field), so that the log can later be verified as untampered
+// (see VerifyLog). Text logs are not hashed.
+func WithLogHashes(logHashes bool) Option {
+	return func(p *Proxy) {
+		p.logHashes = logHashes
+	}
+}
+
+// WithLogMicros is a proxy option to log text timestamps with microseconds,
+// and to tag each logged message with the session's sequence number (ie,
+// "<- seq=42 {...}", or "seq" in the jsonl format). Sequence numbers are
+// assigned as the messages arrive from either side, so the exact order of a
+// session's messages is recoverable from the log.
+func WithLogMicros(logMicros bool) Option {
+	return func(p *Proxy) {
+		p.logMicros = logMicros
+	}
+}
+
+// WithOrderedLog is a proxy option to serialize each session's log lines
+// through a single goroutine, so that the log reflects a consistent total order
+// of the messages observed in both directions, each tagged with a monotonic
+// sequence number in log order (ie, "<- seq=42 {...}"). Without it, lines
+// logged concurrently by the two directions may be interleaved out of order
+// under load. This only affects the log: messages are forwarded as before.
+func WithOrderedLog(orderedLog bool) Option {
+	return func(p *Proxy) {
+		p.orderedLog = orderedLog
+	}
+}
+
+// WithLogHandshake is a proxy option to log the headers of the client's
+// websocket handshake request, and of the remote's handshake response (even
+// when the handshake fails), to diagnose handshake errors. The values of
+// credential headers (ie, Authorization and Cookie) are masked.
+func WithLogHandshake(logHandshake bool) Option {
+	return func(p *Proxy) {
+		p.logHandshake = logHandshake
+	}
+}
+
+// WithMetadataOnly is a proxy option to log only the metadata of each message
+// (its direction, CDP method and id, and byte size, along with the log
+// timestamp), never its payload. Unlike redaction, the payload never reaches
+// any log output, and the proxy refuses to record sessions, write a HAR file,
+// tee the messages (see WithTee), or capture them to a database (see WithDB).
+func WithMetadataOnly(metadataOnly bool) Option {
+	return func(p *Proxy) {
+		p.metadataOnly = metadataOnly
+	}
+}
+
+// WithProtocolCache is a proxy option to cache each remote's protocol
+// definition (served on /json/protocol), so that repeated devtools frontend
+// loads do not each request it from the remote. The cached definition is kept
+// for the lifetime of the proxy.
+func WithProtocolCache(protocolCache bool) Option {
+	return func(p *Proxy) {
+		p.protocolCache = protocolCache
+	}
+}
+
+// WithRules is a proxy option to route the targets served at the root to the
+// proxy's remotes by the routing rules (see ReadRules), based on the target's
+// url and title as listed by the remotes' /json endpoints.
+//
+// The root's target list merges the targets of all remotes: targets matching a
+// rule are only listed from the first matching rule's remote, while other
+// targets are listed from every remote. Connections to a listed target are
+// proxied to the target's remote, and new targets (created with /json/new) are
+// created on the remote of the first rule matching the new target's url, or on
+// the default remote.
+func WithRules(rules ...Rule) Option {
+	return func(p *Proxy) {
+		p.rules = append(p.rules, rules...)
+	}
+}
+
+// WithTLS is a proxy option to serve TLS using the passed certificate and key
+// files.
+func WithTLS(cert, key string) Option {
+	return func(p *Proxy) {
+		p.cert, p.key = cert, key
+	}
+}
+
+// WithTLSListen is a proxy option to serve TLS on a second listen address,
+// using the certificate and key of WithTLS, while the listen address (see
+// WithListen) is served plain HTTP, for clients connecting over ws:// and
+// wss:// to the same proxy. The websocket urls of the proxied targets use the
+// scheme of the listener each request arrived on.
+func WithTLSListen(listen string) Option {
+	return func(p *Proxy) {
+		p.tlsListen = listen
+	}
+}
+
+// WithShutdownTimeout is a proxy option to set how long active sessions are
+// given to finish when the proxy is shut down.
+func WithShutdownTimeout(shutdownTimeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.shutdownTimeout = shutdownTimeout
+	}
+}
+
+// WithPretty is a proxy option to pretty print JSON messages in the text log.
+// The messages forwarded to the peer are not modified.
+func WithPretty(pretty bool) Option {
+	return func(p *Proxy) {
+		p.pretty = pretty
+	}
+}
+
+// WithRedact is a proxy option to replace the values of the JSON field paths
+// (ie, "params.request.headers.Authorization") with "***" in the log. Path
+// segments are matched case-insensitively, and arrays along the path are
+// traversed. The messages forwarded to the peer are not modified.
+func WithRedact(paths ...string) Option {
+	return func(p *Proxy) {
+		p.redactPaths = append(p.redactPaths, paths...)
+	}
+}
+
+// WithLatency is a proxy option to log the round-trip latency of each client
+// command when its response is received from the browser (ie, "[id 42]
+// Page.navigate completed in 238ms").
+func WithLatency(latency bool) Option {
+	return func(p *Proxy) {
+		p.latency = latency
+	}
+}
+
+// WithTracing is a proxy option to export an OpenTelemetry span for each
+// websocket session, from connect to close, to the OTLP/HTTP traces endpoint
+// (ie, http://localhost:4318/v1/traces), with the headers set on each export
+// request. The session's span is a child of the span of the client's W3C
+// traceparent header, when sent. Tracing is disabled when the endpoint is
+// empty.
+func WithTracing(endpoint string, header http.Header) Option {
+	return func(p *Proxy) {
+		p.traceEndpoint, p.traceHeader = endpoint, header
+	}
+}
+
+// WithTraceCalls is a proxy option to also export a child span of the
+// session's span for each client command, from the command to its response
+// (see WithTracing).
+func WithTraceCalls(traceCalls bool) Option {
+	return func(p *Proxy) {
+		p.traceCalls = traceCalls
+	}
+}
+
+// WithMaxLogBytes is a proxy option to set the maximum number of bytes of a
+// message written to the log. Longer messages are truncated in the log, but
+// are always forwarded in full. A value of 0 disables truncation.
+func WithMaxLogBytes(maxLogBytes int) Option {
+	return func(p *Proxy) {
+		p.maxLogBytes = maxLogBytes
+	}
+}
+
+// WithInclude is a proxy option to only log messages whose CDP method matches
+// one of the globs (ie, "Network.*"). Globs prefixed with "-" exclude
+// matching methods.
+func WithInclude(globs ...string) Option {
+	return func(p *Proxy) {
+		p.include = append(p.include, globs...)
+	}
+}
+
+// WithExclude is a proxy option to not log messages whose CDP method matches
+// one of the globs (ie, "Network.dataReceived").
+//
+// Filtered messages are always forwarded.
+func WithExclude(globs ...string) Option {
+	return func(p *Proxy) {
+		p.exclude = append(p.exclude, globs...)
+	}
+}
+
+// WithLogSessions is a proxy option to only log the messages of sessions whose
+// devtools id matches one of the globs. Other sessions are still proxied, but
+// only their lifecycle lines are logged (to stdout), and no log file is
+// created for them.
+func WithLogSessions(globs ...string) Option {
+	return func(p *Proxy) {
+		p.logSessions = append(p.logSessions, globs...)
+	}
+}
+
+// WithLogCDPSessions is a proxy option to only log the messages of the
+// flattened target sessions (see Target.attachToTarget) whose sessionId
+// matches one of the globs, for clients multiplexing targets over a single
+// browser session (ie, Puppeteer and Playwright). Messages without a sessionId
+// (ie, of the browser session itself) match the glob "root". All messages are
+// still proxied.
+func WithLogCDPSessions(globs ...string) Option {
+	return func(p *Proxy) {
+		p.logCDPSessions = append(p.logCDPSessions, globs...)
+	}
+}
+
+// WithLogIDs is a proxy option to only log the messages whose CDP id is within
+// one of the ranges (see ParseIDRanges): the commands with the ids, and their
+// responses. Events, having no id, are not logged. All messages are still
+// proxied.
+func WithLogIDs(ranges ...IDRange) Option {
+	return func(p *Proxy) {
+		p.logIDs = append(p.logIDs, ranges...)
+	}
+}
+
+// WithLogTargetTypes is a proxy option to only log the messages of sessions
+// whose target type matches one of the globs (ie, "page" or "service_worker").
+// Browser sessions have the type "browser". The target type of a session is
+// determined from the remote's /json list when the session connects, and
+// sessions whose target type cannot be determined are logged.
+//
+// Other sessions are filtered as with WithLogSessions.
+func WithLogTargetTypes(globs ...string) Option {
+	return func(p *Proxy) {
+		p.logTargetTypes = append(p.logTargetTypes, globs...)
+	}
+}
+
+// WithInjectDelay is a proxy option to delay forwarding the messages with a
+// CDP method matching the glob (ie, "Page.navigate"), by a random delay
+// between min and max (or by min, when max is not greater), for testing how
+// clients handle a slow browser. Responses match by the method of their
+// command, so that "Page.navigate" with the Outgoing direction only delays the
+// responses to Page.navigate. The delay only applies to the messages of the
+// directions (both, when none is given). As messages are forwarded in order,
+// the later messages of the direction wait for the delayed message.
+//
+// The option can be passed more than once, with a message delayed by the
+// first matching rule.
+func WithInjectDelay(glob string, min, max time.Duration, dirs ...Direction) Option {
+	return func(p *Proxy) {
+		p.delays = append(p.delays, delayRule{methodRule: methodRule{glob: glob, dirs: dirs}, min: min, max: max})
+	}
+}
+
+// WithDropRate is a proxy option to randomly drop the rate of the messages
+// with a CDP method matching the glob (ie, 0.05 drops 1 in 20 messages on
+// average, and "*" matches all messages, including those without a method),
+// instead of forwarding them, for testing how clients handle lost messages.
+// Responses match by the method of their command, and the rule only applies to
+// the messages of the directions (both, when none is given). Each dropped
+// message is logged. The rate must be between 0 and 1.
+//
+// The option can be passed more than once, with a message dropped by the
+// first matching rule.
+func WithDropRate(glob string, rate float64, dirs ...Direction) Option {
+	return func(p *Proxy) {
+		p.drops = append(p.drops, dropRule{methodRule: methodRule{glob: glob, dirs: dirs}, rate: rate})
+	}
+}
+
+// WithBlock is a proxy option to block client commands whose CDP method
+// matches one of the globs (ie, "Target.createTarget" or "Browser.*"). Blocked
+// commands are not forwarded to the remote, and the client is sent a CDP error
+// response instead.
+func WithBlock(globs ...string) Option {
+	return func(p *Proxy) {
+		p.block = append(p.block, globs...)
+	}
+}
+
+// WithDenyBrowserTarget is a proxy option to reject the sessions of the
+// browser target (ie, /devtools/browser/<id>), whose Target and Browser domains
+// can affect the whole browser (ie, closing other targets, or changing download
+// behavior), allowing only the sessions of page and other targets. Browser
+// target sessions are otherwise tagged in the session's connection banner.
+func WithDenyBrowserTarget(deny bool) Option {
+	return func(p *Proxy) {
+		p.denyBrowser = deny
+	}
+}
+
+// WithValidate is a proxy option to validate the client's messages before
+// forwarding them to the remote. Messages that are not well-formed CDP commands
+// (ie, a json object with an integer id and a method) are not forwarded, and
+// the client is sent a CDP error response instead. Client messages are only
+// parsed for validation when enabled, as some CDP implementations accept
+// other messages.
+func WithValidate(validate bool) Option {
+	return func(p *Proxy) {
+		p.validate = validate
+	}
+}
+
+// WithFollowRedirects is a proxy option to follow the redirects of the
+// remote's http endpoints (ie, /json) server-side, instead of passing them on
+// to the client. Without it, redirects pointing at the remote are rewritten to
+// point at the proxy, but redirects to other hosts are passed on unmodified.
+func WithFollowRedirects(followRedirects bool) Option {
+	return func(p *Proxy) {
+		p.followRedirects = followRedirects
+	}
+}
+
+// WithJSONErrors is a proxy option to respond to devtools connections that
+// fail before the websocket is upgraded with a json body
+// ({"error":"...","remote":"...","stage":"dial"}) instead of plain text, so
+// that programmatic clients can distinguish the stage that failed. The stage
+// is one of "limit", "origin", "version", or "dial".
+func WithJSONErrors(jsonErrors bool) Option {
+	return func(p *Proxy) {
+		p.jsonErrors = jsonErrors
+	}
+}
+
+// WithOnConnectCommands is a proxy option to write CDP commands to the remote
+// as soon as a session's websocket to the remote is established, before any
+// client message is forwarded (ie, to enable a domain for every session).
+//
+// The commands are assigned ids counting down from the largest CDP id, so that
+// they do not collide with the client's ids. The responses to the commands
+// are logged, but not forwarded to the client.
+func WithOnConnectCommands(commands ...Command) Option {
+	return func(p *Proxy) {
+		p.connectCommands = append(p.connectCommands, commands...)
+	}
+}
+
+// WithDialRetries is a proxy option to retry connecting to the remote (the
+// version check and websocket dial) up to retries times when it fails, waiting
+// backoff before the first retry and doubling the wait on each subsequent
+// retry. Retries stop when the client's request is canceled.
+func WithDialRetries(retries int, backoff time.Duration) Option {
+	return func(p *Proxy) {
+		p.dialRetries, p.dialBackoff = retries, backoff
+	}
+}
+
+// WithMaxConns is a proxy option to limit the number of concurrent devtools
+// sessions. New sessions are rejected with a 503 once the limit is reached. A
+// limit of 0 disables the limit.
+func WithMaxConns(maxConns int) Option {
+	return func(p *Proxy) {
+		p.maxConns = maxConns
+	}
+}
+
+// WithRate is a proxy option to limit the rate of new devtools sessions, in
+// sessions per second, allowing bursts of up to the rate (rounded up). New
+// sessions are rejected with a 503 once the limit is reached. A rate of 0
+// disables the limit.
+func WithRate(rate float64) Option {
+	return func(p *Proxy) {
+		p.rate = rate
+	}
+}
+
+// WithReconnect is a proxy option to reconnect to the remote when a session's
+// remote connection is lost (ie, when the browser crashed or restarted),
+// without closing the client connection. Reconnection is retried until the
+// timeout, with the dial backoff (see WithDialRetries) doubled on each attempt.
+// The session's target is re-resolved from the remote's /json list, and the
+// reconnection fails when the target is no longer listed, unless reconnecting
+// to any page is enabled (see WithReconnectAnyPage). A timeout of 0 disables
+// reconnection.
+//
+// Reconnection is best-effort: the browser side state (enabled domains,
+// attached targets, pending commands, ...) is lost, and messages from the
+// client are dropped while reconnecting.
+func WithReconnect(reconnect time.Duration) Option {
+	return func(p *Proxy) {
+		p.reconnect = reconnect
+	}
+}
+
+// WithReconnectAnyPage is a proxy option to reconnect sessions whose target is
+// no longer listed by the remote (ie, after the browser restarted) to the
+// remote's first page target instead, when reconnecting (see WithReconnect).
+// The page may be a different tab than the session's, so the client's later
+// commands are sent to a target it did not connect to.
+func WithReconnectAnyPage(anyPage bool) Option {
+	return func(p *Proxy) {
+		p.reconnectAnyPage = anyPage
+	}
+}
+
+// WithBufferSizes is a proxy option to set the websocket buffer sizes. The
+// read buffer size is used for messages read from the client (and written to
+// the remote), and the write buffer size for messages written to the client
+// (and read from the remote).
+func WithBufferSizes(readBufferSize, writeBufferSize int) Option {
+	return func(p *Proxy) {
+		p.readBufferSize, p.writeBufferSize = readBufferSize, writeBufferSize
+	}
+}
+
+// WithMaxMessageSize is a proxy option to limit the size of the messages read
+// from the client and the remote. A session where either peer sends a larger
+// message is closed, with a close frame with the message too big code (1009)
+// sent to both peers. A size of 0 (the default) does not limit message sizes.
+func WithMaxMessageSize(size int64) Option {
+	return func(p *Proxy) {
+		p.maxMessageSize = size
+	}
+}
+
+// WithSessionMaxBytes is a proxy option to limit the total bytes of the
+// messages a session's client can send to the remote, to protect the browser
+// from a runaway client. A session whose client exceeds the limit is logged
+// and closed, with a close frame with the policy violation code (1008) sent
+// to the client. A limit of 0 (the default) does not limit sessions.
+func WithSessionMaxBytes(n int64) Option {
+	return func(p *Proxy) {
+		p.sessionMaxBytes = n
+	}
+}
+
+// WithSessionMaxFrames is a proxy option to limit the number of messages a
+// session's client can send to the remote, closing the session as with
+// WithSessionMaxBytes. A limit of 0 (the default) does not limit sessions.
+func WithSessionMaxFrames(n int64) Option {
+	return func(p *Proxy) {
+		p.sessionMaxFrames = n
+	}
+}
+
+// WithSessionMaxRemoteBytes is a proxy option to limit the total bytes of the
+// messages a session's remote can send to the client (ie, a runaway stream of
+// Network events or screencast frames), closing the session as with
+// WithSessionMaxBytes. A limit of 0 (the default) does not limit sessions.
+func WithSessionMaxRemoteBytes(n int64) Option {
+	return func(p *Proxy) {
+		p.remoteMaxBytes = n
+	}
+}
+
+// WithSubprotocols is a proxy option to forward the websocket subprotocols
+// requested by the client that match one of the globs (ie, "cdp" or "*") to
+// the remote, echoing the subprotocol negotiated with the remote back to the
+// client. By default, no subprotocol is negotiated.
+func WithSubprotocols(globs ...string) Option {
+	return func(p *Proxy) {
+		p.subprotocols = append(p.subprotocols, globs...)
+	}
+}
+
+// WithWriteQueue is a proxy option to queue up to depth messages per direction
+// for writing by a separate goroutine, so that a peer slow to read its
+// messages does not stall reads from the other peer. A session whose queue is
+// full is closed. A depth of 0 (the default) writes each message before the
+// next is read.
+func WithWriteQueue(depth int) Option {
+	return func(p *Proxy) {
+		p.writeQueue = depth
+	}
+}
+
+// WithWriteTimeout is a proxy option to set the timeout of each message
+// written to a peer, so that a peer that stopped reading its messages closes
+// the session instead of blocking its writes forever. With a write queue (see
+// WithWriteQueue), the timeout applies to the writes of the queue. A timeout of
+// 0 (the default) disables the timeout.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.writeTimeout = timeout
+	}
+}
+
+// WithCompression is a proxy option to negotiate websocket compression
+// (permessage-deflate) with the remote and the client. Each connection is only
+// compressed when its peer supports the extension, falling back to
+// uncompressed messages otherwise. Compression trades CPU for bandwidth, and
+// is only worthwhile for high-volume sessions over a slow link.
+func WithCompression(compression bool) Option {
+	return func(p *Proxy) {
+		p.compression = compression
+	}
+}
+
+// WithMetrics is a proxy option to serve prometheus metrics on /metrics at
+// the address when running the proxy with ListenAndServe. The metrics are
+// never served on the proxy's handler.
+func WithMetrics(metricsAddr string) Option {
+	return func(p *Proxy) {
+		p.metricsAddr = metricsAddr
+	}
+}
+
+// WithTargetsPage is a proxy option to serve an HTML index page of each
+// remote's targets at the remote's root (ie, / for the default remote, and
+// /<name>/ for named remotes), instead of the remote's own page. Each target
+// links to its devtools frontend, and lists its websocket url, through the
+// proxy.
+func WithTargetsPage(targetsPage bool) Option {
+	return func(p *Proxy) {
+		p.targetsPage = targetsPage
+	}
+}
+
+// WithStatsInterval is a proxy option to log a line at the interval when
+// running the proxy with ListenAndServe, with the number of active connections
+// and the aggregate message and byte throughput of each direction since the
+// previous line, read from the same counters as the metrics (see WithMetrics).
+// An interval of 0 disables the stats lines.
+func WithStatsInterval(interval time.Duration) Option {
+	return func(p *Proxy) {
+		p.statsInterval = interval
+	}
+}
+
+// WithBasicAuth is a proxy option to require HTTP basic auth credentials on
+// all requests, including websocket upgrades.
+func WithBasicAuth(user, pass string) Option {
+	return func(p *Proxy) {
+		p.authUser, p.authPass = user, pass
+	}
+}
+
+// WithAllowOrigins is a proxy option to only allow websocket connections from
+// browsers with one of the origins (ie, "https://example.com"). By default,
+// all origins are allowed.
+func WithAllowOrigins(origins ...string) Option {
+	return func(p *Proxy) {
+		p.allowOrigins = append(p.allowOrigins, origins...)
+	}
+}
+
+// WithRecord is a proxy option to record all proxied messages, with their
+// timestamps and session ids, to a single session archive file. The archive
+// starts with the remote's /json/version output, and can be read with
+// NewArchiveReader.
+func WithRecord(record string) Option {
+	return func(p *Proxy) {
+		p.record = record
+	}
+}
+
+// WithDB is a proxy option to insert all proxied messages of all sessions in
+// the frames table of a SQLite database file, for querying a capture with SQL
+// (ie, the latency of each Page.navigate command). Each row has the message's
+// session_id (devtools id), conn (connection id), ts (unix time in
+// microseconds), direction (in or out), CDP method, id and cdp_session (when
+// any), size and payload. Messages are inserted in batched transactions by a
+// single writer, and are dropped for the database when inserts fall behind,
+// never delaying the proxied messages.
+//
+// The SQLite driver needs cgo, and is only built in with the sqlite build tag
+// (go build -tags sqlite). Serve returns an error otherwise.
+func WithDB(filename string) Option {
+	return func(p *Proxy) {
+		p.dbFile = filename
+	}
+}
+
+// WithTee is a proxy option to mirror all proxied messages to the sink
+// address, as json lines of archive frames (see ArchiveFrame). The address is
+// a tcp address (host:port), a unix socket (unix:///path), a file or named
+// pipe path (starting with / or .), or a websocket url (ws:// or wss://, sent
+// a text message per line). Messages are dropped for the sink while it is
+// slow or unavailable, never delaying the proxied messages.
+func WithTee(addr string) Option {
+	return func(p *Proxy) {
+		p.teeAddr = addr
+	}
+}
+
+// WithHAR is a proxy option to reconstruct the Network domain events of all
+// sessions into a HAR 1.2 file. The file is rewritten with the entries of
+// each session as it is closed. The proxy's redact paths are applied to the
+// events before they are added to the HAR file.
+//
+// Network events are only sent by the browser when the client has enabled
+// the Network domain.
+func WithHAR(har string) Option {
+	return func(p *Proxy) {
+		p.har = har
+	}
+}
+
+// WithHARBodies is a proxy option to capture the response bodies of the
+// requests in the HAR file (see WithHAR). The bodies are captured over a
+// secondary connection to each session's target, which enables the Fetch
+// domain to pause every response until its body has been retrieved. Browser
+// sessions are not captured.
+//
+// The captured bodies are not redacted, and are held in memory until the
+// session is closed.
+func WithHARBodies(harBodies bool) Option {
+	return func(p *Proxy) {
+		p.harBodies = harBodies
+	}
+}
+
+// WithTraceEvents is a proxy option to write the CDP calls of all sessions to
+// a Trace Event Format file (ie, out.json), for viewing the timeline of the
+// calls in Perfetto or chrome://tracing. Each command is written as a complete
+// event from the command to its response, and each event as an instant event.
+// Commands without a response are not written. The file is rewritten as each
+// session is closed.
+func WithTraceEvents(filename string) Option {
+	return func(p *Proxy) {
+		p.traceEvents = filename
+	}
+}
+
+// WithScreencastDir is a proxy option to save the screencast frames of all
+// sessions (the Page.screencastFrame events sent after a Page.startScreencast
+// command) as image files in the directory, numbered in order (ie,
+// <id>-20240102T150405.000-000001.jpg). Frames are saved as png or jpeg, following
+// the format requested by the Page.startScreencast command.
+func WithScreencastDir(dir string) Option {
+	return func(p *Proxy) {
+		p.screencastDir = dir
+	}
+}
+
+// WithConsoleDir is a proxy option to write the console messages of each
+// session to a console log file in the directory, named by its devtools id
+// and start time (ie, <id>-20240102T150405.000.console.log). The console API
+// calls (Runtime.consoleAPICalled), uncaught exceptions
+// (Runtime.exceptionThrown) and browser log entries (Log.entryAdded) sent to
+// the client are written as plain text lines, with the arguments of console
+// API calls decoded to text where possible. The events are only sent by the
+// browser once the client enables the Runtime or Log domain, and the file is
+// only created for sessions with console messages.
+func WithConsoleDir(dir string) Option {
+	return func(p *Proxy) {
+		p.consoleDir = dir
+	}
+}
+
+// WithBrowserTraceDir is a proxy option to trace the browser for each session,
+// saving the trace of each session to a file in the directory, named by its
+// devtools id and start time (ie, <id>-20240102T150405.000.json), ready to
+// load in chrome://tracing or Perfetto. Tracing is started (with
+// Tracing.start) when the session connects and ended when it closes, over a
+// secondary connection to the session's target, so that the client sees none
+// of the Tracing domain's events.
+func WithBrowserTraceDir(dir string) Option {
+	return func(p *Proxy) {
+		p.browserTraceDir = dir
+	}
+}
+
+// WithKeepalive is a proxy option to send websocket pings to both the client
+// and the remote at the interval, keeping idle sessions from being dropped by
+// intermediaries. A peer that sends no message or pong for twice the interval
+// is disconnected. An interval of 0 disables keepalive pings.
+func WithKeepalive(keepalive time.Duration) Option {
+	return func(p *Proxy) {
+		p.keepalive = keepalive
+	}
+}
+
+// WithIdleTimeout is a proxy option to close sessions that have sent no
+// message in either direction for the timeout. Keepalive pings and pongs are
+// not counted as messages. A timeout of 0 disables the idle timeout.
+func WithIdleTimeout(idleTimeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.idleTimeout = idleTimeout
+	}
+}
+
+// WithHeartbeat is a proxy option to log a heartbeat line (ie, "[heartbeat]
+// session idle for 5m0s, 42 frames so far") each time a session has proxied
+// no message in either direction for the interval, so that the log of a
+// session paused by its client still shows the session is alive. Unlike
+// keepalive pings (see WithKeepalive), heartbeats are only logged, and never
+// sent to the client or the remote. An interval of 0 disables heartbeats.
+func WithHeartbeat(heartbeat time.Duration) Option {
+	return func(p *Proxy) {
+		p.heartbeat = heartbeat
+	}
+}
+
+// WithHandshakeTimeout is a proxy option to set the timeout for the websocket
+// handshake with the remote. A timeout of 0 disables the handshake timeout.
+func WithHandshakeTimeout(handshakeTimeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.handshakeTimeout = handshakeTimeout
+	}
+}
+
+// WithExec is a proxy option to pipe the messages of all sessions to an
+// external program, started with the command (a program path and its args) on
+// the first message, for processing messages without writing a MessageHook.
+//
+// Each message is written to the program's stdin as a header line holding the
+// message's direction ("in" for client messages, "out" for remote messages),
+// the session's devtools id and the message's byte length (ie, "in <id>
+// 62"), followed by the message and a newline.
+//
+// By default (observer mode), messages are forwarded as is without waiting for
+// the program, and are dropped while the program is not keeping up. In
+// intercept mode, the program must reply to each message in turn on its stdout
+// with a line holding the byte length of the message to forward, followed by
+// the message and a newline, or with a length of -1 to drop the message. The
+// messages of all sessions are then passed to the program one at a time,
+// waiting for its reply, which adds the program's latency to every message. A
+// session is closed when the program does not reply within 5 seconds, sends
+// an invalid reply, or has exited.
+//
+// The program is called after the MessageHook (see WithOnMessage), and its
+// stdin is closed when the proxy is closed.
+func WithExec(command []string, intercept bool) Option {
+	return func(p *Proxy) {
+		p.execCommand, p.execIntercept = command, intercept
+	}
+}
+
+// WithOnMessage is a proxy option to set a hook to intercept and rewrite
+// messages in flight (see MessageHook).
+//
+// The hook is called as soon as a message is read, and before the message is
+// counted, recorded, logged or forwarded, so that all of those reflect the
+// rewritten message. Dropped messages are neither logged nor forwarded. The
+// hook is called concurrently for the messages of both directions, and of all
+// sessions, but the messages of a single direction of a session are passed to
+// the hook in order.
+func WithOnMessage(onMessage MessageHook) Option {
+	return func(p *Proxy) {
+		p.onMessage = onMessage
+	}
+}
+
+// WithOnConnect is a proxy option to set a hook called when a devtools session
+// has connected to both the client and the remote, before any message is
+// proxied.
+//
+// The hook is called synchronously from the session's handler, and must not
+// block. The hook is called concurrently for different sessions.
+func WithOnConnect(onConnect ConnectHook) Option {
+	return func(p *Proxy) {
+		p.onConnect = onConnect
+	}
+}
+
+// WithOnDisconnect is a proxy option to set a hook called when a devtools
+// session, for which the connect hook was called (see WithOnConnect), has been
+// closed.
+//
+// The hook is called synchronously from the session's handler, and must not
+// block. The hook is called concurrently for different sessions.
+func WithOnDisconnect(onDisconnect DisconnectHook) Option {
+	return func(p *Proxy) {
+		p.onDisconnect = onDisconnect
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// passthroughBufferSize is the size of the buffers messages are copied
+// through with, when passing messages through (see passthrough).
+const passthroughBufferSize = 32 * 1024
+
+// passthroughBuffers are the buffers messages are copied through with, shared
+// by the proxy's sessions.
+var passthroughBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, passthroughBufferSize)
+		return &buf
+	},
+}
+
+// passthrough returns true when the session's messages can be copied through
+// without being read in full: when nothing of the session is logged, and no
+// option needs to look at, change or account for the messages (other than by
+// their number and size).
+func (s *session) passthrough() bool {
+	p := s.p
+	return s.silent &&
+		p.onMessage == nil &&
+		p.exec == nil &&
+		p.onDisconnect == nil &&
+		p.archive == nil &&
+		p.tee == nil &&
+		p.db == nil &&
+		s.har == nil &&
+		s.screencast == nil &&
+		s.console == nil &&
+		s.pending == nil &&
+		s.span == nil &&
+		!p.validate &&
+		!p.hasBudget() &&
+		len(p.block) == 0 &&
+		len(p.delays) == 0 &&
+		len(p.drops) == 0 &&
+		len(p.connectCommands) == 0 &&
+		p.writeQueue == 0 &&
+		p.reconnect == 0
+}
+
+// copyWS copies messages read from in to the session's connection for the
+// direction, as they are read, through a shared buffer (see passthrough). Any
+// error encountered will be sent to errc.
+func (s *session) copyWS(ctx context.Context, dir Direction, in *websocket.Conn, errc chan error) {
+	bufp := passthroughBuffers.Get().(*[]byte)
+	defer passthroughBuffers.Put(bufp)
+	for {
+		mt, r, err := in.NextReader()
+		if err != nil {
+			errc <- s.readFailed(dir, nil, err)
+			return
+		}
+		if err := s.gate.wait(ctx); err != nil {
+			errc <- err
+			return
+		}
+		s.seq.Add(1)
+		// a message in either direction keeps both connections from idling
+		s.last.Store(time.Now().UnixNano())
+		if err := s.extendReadDeadline(ctx, in); err != nil {
+			errc <- err
+			return
+		}
+		_ = s.extendReadDeadline(ctx, s.out[dir].Load())
+		n, err := s.copyMessage(dir, mt, r, *bufp)
+		if err != nil {
+			errc <- err
+			return
+		}
+		s.stats.recordSize(dir, n)
+		s.p.metrics.recordSize(dir, n)
+	}
+}
+
+// copyMessage copies a message read from r to the session's connection for
+// the direction, returning the size of the message. A read error (ie, a
+// message exceeding the maximum message size) is handled as when reading a
+// message.
+func (s *session) copyMessage(dir Direction, mt int, r io.Reader, buf []byte) (int64, error) {
+	s.writeMu[dir].Lock()
+	defer s.writeMu[dir].Unlock()
+	c := s.out[dir].Load()
+	start := time.Now()
+	if s.p.writeTimeout > 0 {
+		_ = c.SetWriteDeadline(start.Add(s.p.writeTimeout))
+	}
+	w, err := c.NextWriter(mt)
+	if err != nil {
+		s.checkWriteTimeout(dir, start, err)
+		return 0, writeCloseError(dir, err)
+	}
+	var size int64
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if s.p.writeTimeout > 0 {
+				start = time.Now()
+				_ = c.SetWriteDeadline(start.Add(s.p.writeTimeout))
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				s.checkWriteTimeout(dir, start, err)
+				return size, writeCloseError(dir, err)
+			}
+			size += int64(n)
+		}
+		switch {
+		case errors.Is(rerr, io.EOF):
+			if err := w.Close(); err != nil {
+				s.checkWriteTimeout(dir, start, err)
+				return size, writeCloseError(dir, err)
+			}
+			return size, nil
+		case rerr != nil:
+			// the partial message is not completed, as the session is closed
+			return size, s.readFailed(dir, nil, rerr)
+		}
+	}
+}
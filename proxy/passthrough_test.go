@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+// benchModes are the modes messages are proxied in by the benchmarks: copied
+// through (see passthrough), or read in full by a message hook forwarding them
+// unchanged.
+var benchModes = []struct {
+	name string
+	opts []Option
+}{
+	{"passthrough", []Option{WithNoStdout(true)}},
+	{"buffered", []Option{WithNoStdout(true), WithOnMessage(func(_ Direction, raw []byte) ([]byte, error) {
+		return raw, nil
+	})}},
+}
+
+// benchmarkRoundTrip benchmarks round trips of a screencast frame event of
+// the size through a proxy of a fake remote echoing it back.
+func benchmarkRoundTrip(b *testing.B, size int, opts ...Option) {
+	remote := fakeremote.New()
+	defer remote.Close()
+	p, _ := startProxy(b, remote, opts...)
+	c := dialPage(b, p, "P1")
+	msg := []byte(`{"method":"Page.screencastFrame","params":{"data":"` + string(bytes.Repeat([]byte("a"), size)) + `"}}`)
+	b.SetBytes(int64(2 * len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
+			b.Fatalf("expected no error, got: %v", err)
+		}
+		_, buf, err := c.ReadMessage()
+		if err != nil {
+			b.Fatalf("expected no error, got: %v", err)
+		}
+		if len(buf) != len(msg) {
+			b.Fatalf("expected %d bytes, got: %d", len(msg), len(buf))
+		}
+	}
+	b.StopTimer()
+	closeClient(b, c)
+}
+
+func BenchmarkProxyRoundTrip(b *testing.B) {
+	for _, size := range []int{4 << 10, 256 << 10} {
+		for _, mode := range benchModes {
+			b.Run(fmt.Sprintf("%dKiB/%s", size>>10, mode.name), func(b *testing.B) {
+				benchmarkRoundTrip(b, size, mode.opts...)
+			})
+		}
+	}
+}
+
+func BenchmarkProxyLargeMessage(b *testing.B) {
+	for _, size := range []int{1 << 20, 4 << 20, 16 << 20} {
+		for _, mode := range benchModes {
+			b.Run(fmt.Sprintf("%dMiB/%s", size>>20, mode.name), func(b *testing.B) {
+				benchmarkRoundTrip(b, size, mode.opts...)
+			})
+		}
+	}
+}
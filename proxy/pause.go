@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pauseGate is the gate of a session's forwarding, paused and resumed by the
+// admin endpoints (see WithAdmin). While paused, each direction holds the next
+// message it reads, and stops reading from its connection, so that the client
+// and remote are held back by their connection's flow control, rather than the
+// proxy buffering their messages. Control messages (ie, pings) are
+// still handled until a message is held.
+type pauseGate struct {
+	mu sync.Mutex
+	// resumed is closed when the gate is resumed, and is nil while the gate
+	// is not paused
+	resumed chan struct{}
+	since   time.Time
+}
+
+// pause pauses the gate, returning false when it was already paused.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		return false
+	}
+	g.resumed, g.since = make(chan struct{}), time.Now()
+	return true
+}
+
+// resume resumes the gate, returning the time it was paused for, and false
+// when it was not paused.
+func (g *pauseGate) resume() (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return 0, false
+	}
+	close(g.resumed)
+	g.resumed = nil
+	return time.Since(g.since), true
+}
+
+// paused returns true while the gate is paused.
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// wait waits for the gate to be resumed, or for the context to be done.
+func (g *pauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// servePause serves the admin endpoints pausing and resuming the forwarding of
+// the active sessions with an id (see WithAdmin), at
+// /admin/sessions/<id>/pause and /admin/sessions/<id>/resume. The id is either
+// the devtools id or the connection id of the sessions. The affected sessions
+// are served as a json array, as by the sessions endpoint.
+func (p *Proxy) servePause(res http.ResponseWriter, req *http.Request) {
+	id, action := req.PathValue("id"), req.PathValue("action")
+	if action != "pause" && action != "resume" {
+		http.NotFound(res, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.active.mu.Lock()
+	var sessions []*session
+	for s, info := range p.active.sessions {
+		if info.ID == id || info.Conn == id {
+			sessions = append(sessions, s)
+		}
+	}
+	p.active.mu.Unlock()
+	if len(sessions) == 0 {
+		http.Error(res, "no active session "+id, http.StatusNotFound)
+		return
+	}
+	by := req.RemoteAddr
+	if user, ok := authUser(req); ok {
+		by = user + "@" + by
+	}
+	conns := make(map[string]bool, len(sessions))
+	for _, s := range sessions {
+		conns[s.conn.id] = true
+		if action == "pause" {
+			if s.gate.pause() {
+				s.infof("forwarding paused by %s", by)
+			}
+		} else if d, ok := s.gate.resume(); ok {
+			s.infof("forwarding resumed by %s, after %v", by, d.Round(time.Millisecond))
+		}
+	}
+	var v []ActiveSession
+	for _, s := range p.Sessions() {
+		if conns[s.Conn] {
+			v = append(v, s)
+		}
+	}
+	writeSessions(res, v)
+}
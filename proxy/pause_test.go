@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestPauseUser(t *testing.T) {
+	remote := fakeremote.New()
+	defer remote.Close()
+	p, stdout := startProxy(t, remote, WithAdmin(true), WithBasicAuth("alice", "secret"))
+	header := make(http.Header)
+	header.Set("Authorization", "Basic YWxpY2U6c2VjcmV0")
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	c, _, err := p.DialClient(ctx, "/devtools/page/P1", header)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer c.Close()
+	h := p.Handler()
+	for _, action := range []string{"pause", "resume"} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/sessions/P1/"+action, nil)
+		req.SetBasicAuth("alice", "secret")
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got: %d", http.StatusOK, res.Code)
+		}
+	}
+	waitLog(t, stdout, "forwarding paused by alice@192.0.2.1:1234")
+	waitLog(t, stdout, "forwarding resumed by alice@192.0.2.1:1234")
+	if buf := roundTrip(t, c, `{"id":1,"method":"Page.enable"}`); string(buf) != `{"id":1,"method":"Page.enable"}` {
+		t.Errorf("expected the echoed command, got: %s", buf)
+	}
+	closeClient(t, c)
+}
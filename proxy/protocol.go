@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// protocolPath is the path of the remote's protocol definition endpoint.
+const protocolPath = "/json/protocol"
+
+// errNoProtocol is the error returned when the remote does not expose its
+// protocol definition (ie, older browsers).
+var errNoProtocol = errors.New("remote does not expose " + protocolPath)
+
+// protocolCache caches the protocol definitions of the remotes (see
+// WithProtocolCache). Errors are never cached.
+type protocolCache struct {
+	mu      sync.Mutex
+	entries map[*remote][]byte
+}
+
+// serveProtocol serves the remote's protocol definition. Remotes that do not
+// expose the protocol definition are reported with a 404, rather than as a
+// proxy error.
+func (p *Proxy) serveProtocol(r *remote, res http.ResponseWriter, req *http.Request) {
+	body, err := p.protocol(req.Context(), r)
+	switch {
+	case errors.Is(err, errNoProtocol):
+		http.Error(res, err.Error()+" (the browser may be too old)", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(res, fmt.Sprintf("could not retrieve %s from remote %s: %v", protocolPath, r.host, err), http.StatusBadGateway)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if req.Method != http.MethodHead {
+		res.Write(body)
+	}
+}
+
+// protocol returns the remote's protocol definition, reusing the cached
+// definition when the protocol cache is enabled.
+func (p *Proxy) protocol(ctx context.Context, r *remote) ([]byte, error) {
+	if !p.protocolCache {
+		return p.fetchProtocol(ctx, r)
+	}
+	c := &p.protocols
+	c.mu.Lock()
+	body, ok := c.entries[r]
+	c.mu.Unlock()
+	if ok {
+		return body, nil
+	}
+	body, err := p.fetchProtocol(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[*remote][]byte)
+	}
+	c.entries[r] = body
+	return body, nil
+}
+
+// fetchProtocol requests the remote's protocol definition.
+func (p *Proxy) fetchProtocol(ctx context.Context, r *remote) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url(false, protocolPath).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	p.setRemoteHeader(req.Header)
+	cl := &http.Client{Transport: p.transport}
+	res, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	switch {
+	case err != nil:
+		return nil, err
+	case res.StatusCode == http.StatusNotFound:
+		return nil, errNoProtocol
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s returned %s", protocolPath, res.Status)
+	}
+	return body, nil
+}
@@ -0,0 +1,1204 @@
+// Package proxy provides a Chrome DevTools Protocol proxy that logs the
+// websocket messages sent between a CDP client and a browser instance.
+//
+// A Proxy can be run directly with ListenAndServe, or its Handler can be
+// mounted on an existing http.ServeMux.
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Default settings.
+const (
+	DefaultListen  = "localhost:9223"
+	DefaultRemote  = "localhost:9222"
+	DefaultLogMask = "logs/cdp-%s.log"
+	DefaultWSPath  = "/devtools/"
+
+	DefaultShutdownTimeout = 10 * time.Second
+	DefaultMaxLogBytes     = 64 * 1024
+	DefaultLogBackups      = 5
+	DefaultDialBackoff     = 250 * time.Millisecond
+	DefaultSRVInterval     = 30 * time.Second
+
+	// DefaultReadBufferSize is the default size of the buffers for messages
+	// read from the client (and written to the remote).
+	DefaultReadBufferSize = 10 * 1024 * 1024
+	// DefaultWriteBufferSize is the default size of the buffers for messages
+	// written to the client (and read from the remote).
+	DefaultWriteBufferSize = 25 * 1024 * 1024
+)
+
+// Proxy is a Chrome DevTools Protocol proxy.
+type Proxy struct {
+	listen         string
+	tlsListen      string
+	remotes        []*remote
+	remoteInsecure bool
+	remoteProxy    *url.URL
+	remoteHeader   http.Header
+	resHeaders     []string
+	forwardFor     bool
+	trustedProxies []netip.Prefix
+	srv            string
+	srvInterval    time.Duration
+	remoteFile     string
+	activePortFile string
+	remoteSelect   []string
+	noLog          bool
+	logMask        string
+	logOnError     int
+	logNameFunc    func(string) string
+	logNameType    bool
+	logMaxSize     int64
+	logBackups     int
+	logGzip        bool
+	logMaxFiles    int
+	noStdout       bool
+	logMaxTotal    int64
+	stdout         io.Writer
+	format         Format
+	stdoutFormat   Format
+	logMicros      bool
+	logHashes      bool
+	splitDomains   bool
+	logPrefix      string
+	frameTimes     bool
+	headFrames     int64
+	samples        []sampleRule
+	metadataOnly   bool
+	logHandshake   bool
+	orderedLog     bool
+	pretty         bool
+	redactPaths    []string
+	maxLogBytes    int
+	include        []string
+	exclude        []string
+	cert           string
+	key            string
+
+	shutdownTimeout  time.Duration
+	readBufferSize   int
+	writeBufferSize  int
+	maxMessageSize   int64
+	sessionMaxBytes  int64
+	sessionMaxFrames int64
+	remoteMaxBytes   int64
+	subprotocols     []string
+	metricsAddr      string
+	statsInterval    time.Duration
+	targetsPage      bool
+	authUser         string
+	authPass         string
+	allowOrigins     []string
+	record           string
+	har              string
+	harBodies        bool
+	teeAddr          string
+	dbFile           string
+	traceEvents      string
+	screencastDir    string
+	consoleDir       string
+	keepalive        time.Duration
+	idleTimeout      time.Duration
+	heartbeat        time.Duration
+	handshakeTimeout time.Duration
+	onMessage        MessageHook
+	execCommand      []string
+	execIntercept    bool
+	onConnect        ConnectHook
+	onDisconnect     DisconnectHook
+	block            []string
+	delays           []delayRule
+	drops            []dropRule
+	validate         bool
+	denyBrowser      bool
+	jsonErrors       bool
+	followRedirects  bool
+	connectCommands  []Command
+	latency          bool
+	traceEndpoint    string
+	traceHeader      http.Header
+	traceCalls       bool
+	color            bool
+	dialRetries      int
+	dialBackoff      time.Duration
+	logStream        bool
+	admin            bool
+	detachOnClose    bool
+	quiet            bool
+	syslog           bool
+	syslogAddr       string
+	logSingle        string
+	maxConns         int
+	logSessions      []string
+	logCDPSessions   []string
+	logIDs           []IDRange
+	versionOverride  map[string]string
+	versionFile      string
+	browserTraceDir  string
+	logTargetTypes   []string
+	reconnect        time.Duration
+	reconnectAnyPage bool
+	rate             float64
+	protocolCache    bool
+	rules            []Rule
+	writeQueue       int
+	writeTimeout     time.Duration
+	wsPath           string
+	noVersionCheck   bool
+	minProtocol      string
+	noNormalizeHost  bool
+	runID            string
+	compression      bool
+	logS3            *S3Config
+
+	transport *http.Transport
+	dialer    *websocket.Dialer
+	upgrader  *websocket.Upgrader
+	filter    *methodFilter
+	sessions  sync.WaitGroup
+	logSeq    atomic.Int64
+	versions  versionCache
+	verFile   versionFile
+	protocols protocolCache
+	logHub    logHub
+	metrics   metrics
+	archive   *archive
+	tee       *tee
+	db        *captureDB
+	memory    *memoryListener
+	tracer    *tracer
+	harFile   *harFile
+	timeline  *timelineFile
+	single    *singleLog
+	pool      *srvPool
+	addrFile  *remoteFile
+	selected  selectedRemotes
+	logFiles  logFiles
+	logNames  logNames
+	logPrune  sync.Mutex
+	active    activeSessions
+	limiter   *limiter
+	evictions evictions
+	exec      *execHook
+
+	syslogOnce sync.Once
+	sysLogger  sysLogger
+}
+
+// New creates a new proxy.
+func New(opts ...Option) *Proxy {
+	p := &Proxy{
+		listen:  DefaultListen,
+		remotes: []*remote{newRemote("", DefaultRemote)},
+		logMask: DefaultLogMask,
+		stdout:  os.Stdout,
+		format:  FormatText,
+		wsPath:  DefaultWSPath,
+
+		logBackups:      DefaultLogBackups,
+		logNameFunc:     CleanLogName,
+		maxLogBytes:     DefaultMaxLogBytes,
+		shutdownTimeout: DefaultShutdownTimeout,
+		dialBackoff:     DefaultDialBackoff,
+		srvInterval:     DefaultSRVInterval,
+		readBufferSize:  DefaultReadBufferSize,
+		writeBufferSize: DefaultWriteBufferSize,
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	// discarded logs are recognized by their writer
+	if p.stdout != io.Discard {
+		p.stdout = &stdoutWriter{w: p.stdout}
+	}
+	p.filter = newMethodFilter(p.include, p.exclude)
+	p.wsPath = "/" + strings.Trim(p.wsPath, "/") + "/"
+	if p.wsPath == "//" {
+		p.wsPath = "/"
+	}
+	if p.record != "" {
+		p.archive = &archive{filename: p.record}
+	}
+	if p.har != "" {
+		p.harFile = &harFile{filename: p.har}
+	}
+	if p.traceEvents != "" {
+		p.timeline = &timelineFile{filename: p.traceEvents}
+	}
+	if p.logSingle != "" {
+		p.single = &singleLog{filename: p.logSingle}
+	}
+	if len(p.execCommand) != 0 {
+		p.exec = newExecHook(p.execCommand, p.execIntercept)
+	}
+	if p.traceEndpoint != "" {
+		p.tracer = newTracer(p.traceEndpoint, p.traceHeader)
+	}
+	if p.teeAddr != "" {
+		p.tee = newTee(p.teeAddr)
+	}
+	if p.dbFile != "" {
+		p.db = newCaptureDB(p.dbFile)
+	}
+	if p.listen == memoryListen {
+		p.memory = newMemoryListener()
+	}
+	if p.logS3 != nil {
+		p.logFiles.closed = newS3Uploader(*p.logS3).uploadLogs
+	}
+	if p.srv != "" {
+		p.setRemote("", p.srv)
+		p.pool = &srvPool{r: p.remoteByName("")}
+	}
+	if p.remoteFile != "" {
+		p.setRemote("", p.remoteFile)
+		p.addrFile = &remoteFile{filename: p.remoteFile, r: p.remoteByName("")}
+	}
+	if p.activePortFile != "" && p.remoteFile == "" {
+		p.setRemote("", p.activePortFile)
+		p.addrFile = &remoteFile{filename: p.activePortFile, activePort: true, r: p.remoteByName("")}
+	}
+	p.limiter = newLimiter(p.maxConns, p.rate)
+	p.transport = http.DefaultTransport.(*http.Transport).Clone()
+	p.dialer = &websocket.Dialer{
+		ReadBufferSize:    p.writeBufferSize,
+		WriteBufferSize:   p.readBufferSize,
+		HandshakeTimeout:  p.handshakeTimeout,
+		EnableCompression: p.compression,
+	}
+	p.upgrader = &websocket.Upgrader{
+		ReadBufferSize:    p.readBufferSize,
+		WriteBufferSize:   p.writeBufferSize,
+		CheckOrigin:       p.checkOrigin,
+		EnableCompression: p.compression,
+	}
+	// connect to the remote through the http proxy from the environment (ie,
+	// HTTPS_PROXY), unless overridden
+	p.transport.Proxy = http.ProxyFromEnvironment
+	if p.remoteProxy != nil {
+		p.transport.Proxy = http.ProxyURL(p.remoteProxy)
+	}
+	p.dialer.Proxy = p.transport.Proxy
+	if p.remoteInsecure {
+		p.transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		p.dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return p
+}
+
+// ListenAndServe listens on the proxy's listen address and serves requests
+// until the context is closed or an error is encountered (see Listen and
+// Serve).
+func (p *Proxy) ListenAndServe(ctx context.Context) error {
+	ln, err := p.Listen()
+	if err != nil {
+		return err
+	}
+	return p.Serve(ctx, ln)
+}
+
+// Serve serves requests on the listener until the context is closed or an
+// error is encountered. The listener is closed when Serve returns.
+//
+// When a certificate and key have been provided (see WithTLS), the proxy
+// serves HTTPS (and websockets over TLS). When a TLS listen address has also
+// been provided (see WithTLSListen), the listener is served plain HTTP, and
+// HTTPS is served on the TLS listen address, with both sharing the proxy's
+// sessions.
+//
+// When a metrics address has been provided (see WithMetrics), a separate
+// server exposing prometheus metrics on /metrics is also run.
+//
+// When the context is closed, the proxy stops accepting new connections and
+// waits for active sessions to finish, up to the shutdown timeout (see
+// WithShutdownTimeout), before closing any remaining sessions.
+func (p *Proxy) Serve(ctx context.Context, ln net.Listener) error {
+	defer p.Close()
+	defer ln.Close()
+	if (p.cert == "") != (p.key == "") {
+		return errors.New("both a tls certificate and key must be provided")
+	}
+	if p.tlsListen != "" && p.cert == "" {
+		return errors.New("a tls listen address requires a tls certificate and key")
+	}
+	if err := p.checkRules(); err != nil {
+		return err
+	}
+	if err := p.checkRemoteSelect(); err != nil {
+		return err
+	}
+	if p.metadataOnly && (p.record != "" || p.har != "" || p.teeAddr != "" || p.dbFile != "") {
+		return errors.New("metadata only logging cannot be combined with recording, a har file, a tee, or a capture database")
+	}
+	if p.minProtocol != "" {
+		if p.noVersionCheck {
+			return errors.New("a minimum protocol version cannot be checked without version checks")
+		}
+		if _, err := parseProtocolVersion(p.minProtocol); err != nil {
+			return err
+		}
+	}
+	if p.db != nil && p.db.err != nil {
+		return p.db.err
+	}
+	if p.logS3 != nil && p.noLog {
+		return errors.New("uploading log files to s3 requires logging to files")
+	}
+	if (p.logMaxFiles > 0 || p.logMaxTotal > 0) && p.logMask != "" && !logMaskLiteral(p.logMask) {
+		return fmt.Errorf("a log quota requires a log mask with a literal part in its file name (ie, cdp-%%s.log), so that only log files are removed, got: %s", p.logMask)
+	}
+	for _, rule := range p.delays {
+		if rule.min < 0 || rule.max < 0 {
+			return fmt.Errorf("invalid injected delay %s (expected a positive delay)", rule)
+		}
+	}
+	for _, rule := range p.drops {
+		if rule.rate < 0 || rule.rate > 1 {
+			return fmt.Errorf("invalid drop rate %s (expected a rate between 0 and 1)", rule)
+		}
+	}
+	for _, rule := range p.samples {
+		if rule.rate < 0 || rule.rate > 1 {
+			return fmt.Errorf("invalid sample rate %v for %s (expected a rate between 0 and 1)", rule.rate, rule.glob)
+		}
+	}
+	if p.srv != "" && (p.remoteFile != "" || p.activePortFile != "") {
+		return errors.New("a srv record cannot be combined with a remote file")
+	}
+	if p.remoteFile != "" && p.activePortFile != "" {
+		return errors.New("a remote file cannot be combined with a DevToolsActivePort file")
+	}
+	if p.pool != nil {
+		if err := p.resolveSRV(ctx); err != nil {
+			log.Print(err)
+		}
+		go p.runSRV(ctx)
+	}
+	if p.statsInterval > 0 {
+		go p.runStats(ctx)
+	}
+	if p.versionFile != "" {
+		go p.startVersionFile(ctx)
+	}
+	// sessions are not tied to ctx, so that they can drain on shutdown
+	sessCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	server := &http.Server{
+		Handler: p.Handler(),
+		BaseContext: func(net.Listener) context.Context {
+			return sessCtx
+		},
+		ConnContext: connContext,
+		// keep clients on HTTP/1.1 over TLS, as HTTP/2 connections cannot be
+		// upgraded to websocket sessions
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+	}
+	errc := make(chan error, 3)
+	var metricsServer *http.Server
+	if p.metricsAddr != "" {
+		metricsServer = &http.Server{
+			Addr:    p.metricsAddr,
+			Handler: p.metricsHandler(),
+		}
+		go func() {
+			errc <- metricsServer.ListenAndServe()
+		}()
+		defer metricsServer.Close()
+	}
+	if p.tlsListen != "" {
+		tlsLn, err := net.Listen("tcp", p.tlsListen)
+		if err != nil {
+			return err
+		}
+		defer tlsLn.Close()
+		log.Printf("serving tls on %s", tlsLn.Addr())
+		go func() {
+			errc <- server.ServeTLS(tlsLn, p.cert, p.key)
+		}()
+	}
+	go func() {
+		if p.listenTLS() {
+			errc <- server.ServeTLS(ln, p.cert, p.key)
+		} else {
+			errc <- server.Serve(ln)
+		}
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), p.shutdownTimeout)
+	defer cancelShutdown()
+	_ = server.Shutdown(shutdownCtx)
+	if p.detachOnClose {
+		p.detachSessions()
+	}
+	done := make(chan struct{})
+	go func() {
+		p.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		cancel()
+		<-done
+	}
+	return nil
+}
+
+// listenTLS returns true when the proxy's listen address is served over TLS,
+// rather than only its TLS listen address (see WithTLSListen).
+func (p *Proxy) listenTLS() bool {
+	return p.cert != "" && p.tlsListen == ""
+}
+
+// Listen creates the listener for the proxy's listen address, for use with
+// Serve. The listener's address is the resolved listen address (ie, with the
+// port chosen when listening on port 0, as with "localhost:0").
+//
+// Listen addresses prefixed with "unix:" listen on a unix socket, removing any
+// stale socket file (one that is not accepting connections) left at the path.
+// The socket file is removed when the listener is closed. The "memory:" listen
+// address returns the proxy's in-memory listener (see DialClient).
+func (p *Proxy) Listen() (net.Listener, error) {
+	if p.memory != nil {
+		return p.memory, nil
+	}
+	name, ok := strings.CutPrefix(p.listen, "unix:")
+	if !ok {
+		return net.Listen("tcp", p.listen)
+	}
+	if fi, err := os.Stat(name); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", name); err == nil {
+			conn.Close()
+		} else if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", name)
+}
+
+// ReopenLogs reopens the proxy's open log files by name, so that sessions keep
+// logging to new files after the files have been renamed by an external log
+// rotation tool (ie, logrotate). It is safe to call concurrently with active
+// sessions.
+func (p *Proxy) ReopenLogs() error {
+	return p.logFiles.reopen()
+}
+
+// Close closes the proxy's session archive, shared log file and capture
+// database, if any. Close is called automatically when ListenAndServe
+// returns, and only needs to be called when using the proxy's Handler
+// directly.
+func (p *Proxy) Close() error {
+	var errs []error
+	if p.archive != nil {
+		errs = append(errs, p.archive.Close())
+	}
+	if p.single != nil {
+		errs = append(errs, p.single.Close())
+	}
+	if p.tracer != nil {
+		errs = append(errs, p.tracer.Close())
+	}
+	if p.exec != nil {
+		errs = append(errs, p.exec.Close())
+	}
+	if p.tee != nil {
+		errs = append(errs, p.tee.Close())
+	}
+	if p.db != nil {
+		errs = append(errs, p.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Handler returns a http.Handler for the proxy.
+//
+// The default remote is served at the root, and each named remote (see
+// WithNamedRemote) is served under the /<name>/ path prefix. When the proxy has
+// routing rules (see WithRules), the root instead routes to the remotes by the
+// rules. Requests selecting a remote by header (see WithRemoteSelect) are
+// served by the selected remote at the root.
+func (p *Proxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", p.serveHealth)
+	if p.logStream {
+		mux.HandleFunc("/logs", p.serveLogs)
+	}
+	if p.admin {
+		mux.HandleFunc("/admin/sessions", p.serveSessions)
+		mux.HandleFunc("/admin/sessions/{id}/{action}", p.servePause)
+		mux.HandleFunc("/admin/evictions", p.serveEvictions)
+	}
+	handlers := make(map[*remote]http.Handler, len(p.remotes))
+	for _, r := range p.remotes {
+		switch {
+		case p.pool != nil && r == p.pool.r:
+			handlers[r] = p.srvHandler()
+		case p.addrFile != nil && r == p.addrFile.r:
+			handlers[r] = p.remoteFileHandler()
+		default:
+			handlers[r] = p.remoteHandler(r)
+		}
+		if r.name != "" {
+			mux.Handle(r.prefix()+"/", http.StripPrefix(r.prefix(), handlers[r]))
+		}
+	}
+	var root http.Handler
+	switch {
+	case len(p.rules) != 0 && len(p.remotes) != 0:
+		fallback := p.remoteByName("")
+		if fallback == nil {
+			fallback = p.remotes[0]
+		}
+		root = p.routeHandler(handlers, fallback)
+	case p.remoteByName("") != nil:
+		root = handlers[p.remoteByName("")]
+	}
+	if len(p.remoteSelect) != 0 {
+		if root == nil {
+			root = http.NotFoundHandler()
+		}
+		root = p.remoteSelectHandler(root)
+	}
+	if root != nil {
+		mux.Handle("/", root)
+	}
+	if p.authUser != "" || p.authPass != "" {
+		return p.basicAuth(mux)
+	}
+	return mux
+}
+
+// remoteHandler returns a http.Handler for the remote.
+func (p *Proxy) remoteHandler(r *remote) http.Handler {
+	mux := http.NewServeMux()
+	simplep := httputil.NewSingleHostReverseProxy(r.url(false, ""))
+	simplep.Transport = p.transport
+	if p.followRedirects {
+		simplep.Transport = redirectFollower{cl: &http.Client{Transport: p.transport}}
+	}
+	// the remote validates the Host header (only allowing ip addresses and
+	// localhost), so send the remote's host instead of the proxy's
+	director := simplep.Director
+	simplep.Director = func(req *http.Request) {
+		director(req)
+		req.Host = r.host
+		p.setRemoteHeader(req.Header)
+	}
+	simplep.ModifyResponse = func(res *http.Response) error {
+		return p.modifyResponse(r, res)
+	}
+	mux.Handle("/json", withFrontend(r, simplep))
+	mux.HandleFunc(protocolPath, func(res http.ResponseWriter, req *http.Request) {
+		p.serveProtocol(r, res, req)
+	})
+	root := withFrontend(r, simplep)
+	if p.targetsPage {
+		root = p.indexHandler(r, root)
+	}
+	if p.wsPath != "/" {
+		mux.Handle("/", root)
+	}
+	mux.HandleFunc(p.wsPath, func(res http.ResponseWriter, req *http.Request) {
+		if isAsset(req) {
+			root.ServeHTTP(res, req)
+			return
+		}
+		req, ok := p.resolveAlias(r, res, req)
+		if !ok {
+			return
+		}
+		p.serveDevtools(r, res, req)
+	})
+	return mux
+}
+
+// forwardSubprotocols returns the websocket subprotocols requested by the
+// client that are forwarded to the remote (see WithSubprotocols), in the
+// client's order of preference.
+func (p *Proxy) forwardSubprotocols(req *http.Request) []string {
+	if len(p.subprotocols) == 0 {
+		return nil
+	}
+	var protocols []string
+	for _, protocol := range websocket.Subprotocols(req) {
+		if matchGlobs(p.subprotocols, protocol) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	return protocols
+}
+
+// handshakeHeaders are the headers of the websocket handshake response that
+// are set by the upgrader, and are never copied from the remote's response.
+var handshakeHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Accept":     true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+}
+
+// copyResponseHeaders copies the proxy's response headers (see
+// WithResponseHeaders) from the remote's websocket handshake response to the
+// client's.
+func (p *Proxy) copyResponseHeaders(dst, src http.Header) {
+	for _, name := range p.resHeaders {
+		name = http.CanonicalHeaderKey(name)
+		if handshakeHeaders[name] {
+			continue
+		}
+		for _, v := range src.Values(name) {
+			dst.Add(name, v)
+		}
+	}
+}
+
+// authUserKey is the context key of the user a request was authenticated as
+// by basicAuth.
+type authUserKey struct{}
+
+// authUser returns the user the request was authenticated as, if any.
+func authUser(req *http.Request) (string, bool) {
+	user, ok := req.Context().Value(authUserKey{}).(string)
+	return user, ok
+}
+
+// basicAuth wraps the handler, requiring the proxy's basic auth credentials.
+// The authenticated user is saved on the request's context (see authUser), as
+// the credentials are removed from the request.
+func (p *Proxy) basicAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(p.authUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(p.authPass)) != 1 {
+			res.Header().Set("WWW-Authenticate", `Basic realm="chromedp-proxy"`)
+			http.Error(res, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// do not pass the credentials on to the remote
+		req.Header.Del("Authorization")
+		h.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), authUserKey{}, user)))
+	})
+}
+
+// checkOrigin returns true when the request's origin is in the proxy's allowed
+// origins. All origins are allowed when there are no allowed origins, and
+// requests without an origin (ie, from non-browser clients) are always
+// allowed.
+func (p *Proxy) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if len(p.allowOrigins) == 0 || origin == "" {
+		return true
+	}
+	for _, o := range p.allowOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// healthTimeout is the timeout for the remote version check performed by the
+// health endpoint.
+const healthTimeout = 2 * time.Second
+
+// serveHealth serves the health endpoint, reporting whether the remotes are
+// reachable and return valid version information (see probeRemote).
+func (p *Proxy) serveHealth(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), healthTimeout)
+	defer cancel()
+	status, lines := http.StatusOK, make([]string, 0, len(p.remotes))
+	for _, r := range p.remotes {
+		prefix := ""
+		if r.name != "" {
+			prefix = r.name + ": "
+		}
+		browser, err := p.probeRemote(ctx, r)
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			lines = append(lines, fmt.Sprintf("%sremote %s unavailable: %v", prefix, r.host, err))
+			continue
+		}
+		lines = append(lines, prefix+browser)
+	}
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+	fmt.Fprintln(res, strings.Join(lines, "\n"))
+}
+
+// isAsset returns true when the request is for a devtools frontend asset (ie,
+// inspector.html, .js, .css, ...) rather than a websocket session.
+func isAsset(req *http.Request) bool {
+	return !websocket.IsWebSocketUpgrade(req) || path.Ext(req.URL.Path) != ""
+}
+
+// serveDevtools proxies a devtools websocket connection to the remote.
+func (p *Proxy) serveDevtools(r *remote, res http.ResponseWriter, req *http.Request) {
+	reason, ok := p.limiter.acquire()
+	if !ok {
+		p.metrics.rejected(reason)
+		log.Printf("rejected connection from %s (%s limit reached)", p.clientAddr(req), reason)
+		res.Header().Set("Retry-After", "1")
+		p.writeError(res, r, stageLimit, http.StatusServiceUnavailable, "too many connections ("+reason+" limit reached)")
+		return
+	}
+	defer p.limiter.release()
+	p.sessions.Add(1)
+	defer p.sessions.Done()
+	p.metrics.conns.Add(1)
+	p.metrics.active.Add(1)
+	defer p.metrics.active.Add(-1)
+	ctx := req.Context()
+	id := path.Base(req.URL.Path)
+	logID := id
+	if p.logNameType {
+		logID = path.Base(path.Dir(req.URL.Path)) + "_" + id
+	}
+	if r.name != "" {
+		logID = r.name + "-" + logID
+	}
+	logged, logErr := p.logSession(ctx, r, req.URL.Path)
+	browser := isBrowserPath(req.URL.Path)
+	// the client and its peer (when forwarded by a trusted proxy), and
+	// browser target sessions, are tagged in the connection banner
+	client, tag := p.clientAddr(req), ""
+	if client != req.RemoteAddr {
+		tag = " (via " + req.RemoteAddr + ")"
+	}
+	if browser {
+		tag += " (browser target)"
+	}
+	conn := requestConn(req)
+	var s *session
+	if logged {
+		f, outs, filename := p.createLog(logID)
+		// the log name is released once the log is closed
+		defer p.logNames.release(logID)
+		if f != nil {
+			defer f.Close()
+		}
+		s = newSession(p, id, client, outs)
+		s.conn = conn
+		if el, ok := f.(*errorLog); ok {
+			el.failed = s.failed.Load
+		}
+		s.infof("---------- connection from %s%s ----------", client, tag)
+		s.logConn(req)
+		if logErr != nil {
+			s.logf("could not determine target type, logging session: %v", logErr)
+		}
+		if filename != "" {
+			s.infof("logging to %s", filename)
+		}
+		if p.splitDomains && filename != "" {
+			s.domains = newDomainLogs(filename)
+			defer s.domains.close()
+			s.infof("logging messages to %s", s.domains.domainFilename("<domain>"))
+		}
+	} else {
+		// only the lifecycle lines of filtered sessions are logged, to stdout
+		s = newSession(p, id, client, []logOutput{{w: p.stdout, format: p.stdoutLogFormat()}})
+		s.conn = conn
+		s.discard = true
+		s.infof("---------- connection from %s%s (not logged) ----------", client, tag)
+		s.logConn(req)
+	}
+	if p.orderedLog {
+		s.ordered = newOrderedLog()
+		defer s.ordered.close()
+	}
+	if p.tracer != nil {
+		s.span = p.tracer.startSession(req.Header.Get("traceparent"))
+		s.span.str("cdp.target.id", id)
+		s.span.str("url.path", req.URL.Path)
+		s.span.str("client.address", client)
+		s.span.str("cdp.remote", r.host)
+		defer s.finishSpan()
+	}
+	if p.logHandshake {
+		// the Host header is not part of the request's headers
+		header := req.Header.Clone()
+		header.Set("Host", req.Host)
+		s.logHeaders("client handshake request "+req.Method+" "+req.RequestURI, header)
+	}
+	if !p.checkOrigin(req) {
+		msg := fmt.Sprintf("origin %q not allowed", req.Header.Get("Origin"))
+		p.sessionError(s, res, r, stageOrigin, http.StatusForbidden, msg)
+		return
+	}
+	if browser && p.denyBrowser {
+		p.sessionError(s, res, r, stageTarget, http.StatusForbidden, "browser target sessions not allowed")
+		return
+	}
+	s.header = p.dialHeader(req)
+	if protocols := p.forwardSubprotocols(req); len(protocols) != 0 {
+		d := *p.dialer
+		d.Subprotocols = protocols
+		s.dialer = &d
+	}
+	// fail over to the remote's fallbacks, in order
+	var rc remoteConn
+	var stage string
+	var err error
+	for fallback := r; fallback != nil; fallback = fallback.fallback {
+		if fallback != r {
+			s.logf("%v (stage %s), failing over to %s", err, stage, fallback.host)
+			r = fallback
+		}
+		if rc, stage, err = p.connectRemote(ctx, s, r, req, id); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		p.sessionError(s, res, r, stage, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out, pres, ver := rc.conn, rc.res, rc.ver
+	if s.span != nil && ver.Browser != "" {
+		s.span.str("cdp.browser", ver.Browser)
+	}
+	if p.archive != nil {
+		p.archive.start(r.host, ver.Raw())
+	}
+	defer pres.Body.Close()
+	defer out.Close()
+	s.infof("connected to %s (%s -> %s)", rc.endpoint, out.NetConn().LocalAddr(), out.NetConn().RemoteAddr())
+	if p.compression && !strings.Contains(pres.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		s.infof("remote does not support compression, messages to the remote are not compressed")
+	}
+	// connect incoming websocket
+	s.infof("upgrading connection on %s", req.RemoteAddr)
+	header := make(http.Header)
+	if ver.Browser != "" {
+		header.Set("X-Proxied-Browser", ver.Browser)
+	}
+	// echo the subprotocol negotiated with the remote
+	if protocol := out.Subprotocol(); protocol != "" {
+		header.Set("Sec-Websocket-Protocol", protocol)
+	}
+	p.copyResponseHeaders(header, pres.Header)
+	in, err := p.upgrader.Upgrade(res, req, header)
+	if err != nil {
+		msg := fmt.Sprintf("could not upgrade websocket from %s, got: %v", req.RemoteAddr, err)
+		s.logf("%s", msg)
+		http.Error(res, msg, http.StatusInternalServerError)
+		return
+	}
+	defer in.Close()
+	s.infof("upgraded connection on %s", req.RemoteAddr)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	info := Info{
+		ID:         id,
+		Path:       req.URL.Path,
+		Remote:     r.name,
+		RemoteAddr: client,
+		Browser:    ver.Browser,
+		Start:      s.stats.start,
+		Conn:       conn.id,
+	}
+	if p.onConnect != nil {
+		p.onConnect(info)
+	}
+	p.active.add(s, info)
+	defer p.active.remove(s)
+	s.out[Incoming].Store(out)
+	s.out[Outgoing].Store(in)
+	// capture bodies before proxying any message, so that no response is
+	// missed
+	var capture *Conn
+	if p.harBodies && s.har != nil {
+		capture = s.captureBodies(ctx, r, req.URL.Path)
+	}
+	var trace *browserTrace
+	if p.browserTraceDir != "" {
+		trace = s.startBrowserTrace(ctx, r, req.URL.Path)
+	}
+	if len(p.delays) != 0 {
+		rules := make([]string, len(p.delays))
+		for i, r := range p.delays {
+			rules[i] = r.String()
+		}
+		s.infof("injecting delays: %s", strings.Join(rules, " "))
+	}
+	if len(p.drops) != 0 {
+		rules := make([]string, len(p.drops))
+		for i, r := range p.drops {
+			rules[i] = r.String()
+		}
+		s.infof("[chaos] dropping messages: %s", strings.Join(rules, " "))
+	}
+	// inject the on connect commands before any client message
+	if len(p.connectCommands) != 0 {
+		if err := s.injectCommands(); err != nil {
+			s.logf("could not write on connect commands to the remote: %v", err)
+		}
+	}
+	errc := make(chan error, 2)
+	go s.proxyWS(ctx, Incoming, in, errc)
+	if p.reconnect > 0 {
+		go s.reconnectWS(ctx, r, req.URL.Path, out, errc)
+	} else {
+		go s.proxyWS(ctx, Outgoing, out, errc)
+	}
+	if p.heartbeat > 0 {
+		go s.heartbeat(ctx)
+	}
+	n := 0
+	var closeErr error
+	select {
+	case err := <-errc:
+		n++
+		closeErr = err
+		if !cleanClose(err) {
+			s.failed.Store(true)
+		}
+		if reason := s.timeoutReason(ctx, err); reason != "" {
+			s.logf("%s", reason)
+		}
+		// give the peer a chance to respond to a forwarded close frame
+		if isCloseError(err) {
+			select {
+			case <-errc:
+				n++
+			case <-time.After(closeTimeout):
+			case <-ctx.Done():
+			}
+		}
+	case <-ctx.Done():
+	}
+	closed := s.closeReason(ctx, closeErr)
+	// stop and wait for both sides to finish
+	cancel()
+	for ; n < 2; n++ {
+		<-errc
+	}
+	if p.detachOnClose && !s.clientClosed.Load() {
+		s.detach()
+	}
+	if trace != nil {
+		s.stopBrowserTrace(trace)
+	}
+	if s.console != nil {
+		s.console.close()
+	}
+	if capture != nil {
+		capture.Close()
+	}
+	if s.har != nil {
+		if err := p.harFile.add(s.har.entries()); err != nil {
+			s.logf("could not write har file %s, got: %v", p.har, err)
+		}
+	}
+	if s.timeline != nil {
+		if err := p.timeline.add(s.id, s.timeline.entries()); err != nil {
+			s.logf("could not write trace events file %s, got: %v", p.traceEvents, err)
+		}
+	}
+	for _, line := range s.stats.summary() {
+		s.logf("%s", line)
+	}
+	for _, line := range s.sampleSummary() {
+		s.logf("%s", line)
+	}
+	for _, line := range s.errorSummary() {
+		s.logf("%s", line)
+	}
+	if p.onDisconnect != nil {
+		p.onDisconnect(info, s.stats.stats())
+	}
+	now := time.Now()
+	s.infof("connection %s closed at %s, after %v (%s)", conn.id, now.UTC().Format(time.RFC3339Nano), now.Sub(conn.accepted), closed)
+	s.infof("---------- closing %s ----------", client)
+}
+
+// remoteConn is a websocket connection to a remote.
+type remoteConn struct {
+	conn     *websocket.Conn
+	res      *http.Response
+	ver      *Version
+	endpoint string
+}
+
+// connectRemote checks the remote's version (unless disabled), and connects
+// to the remote's websocket endpoint for the client's request. On failure, the
+// failed stage is returned with the error.
+func (p *Proxy) connectRemote(ctx context.Context, s *session, r *remote, req *http.Request, id string) (remoteConn, string, error) {
+	ver := new(Version)
+	if p.noVersionCheck && !p.versionFileWritten() {
+		// without version checks, the version file is written in the
+		// background
+		go func() {
+			_, _ = p.cachedVersion(ctx, r)
+		}()
+	}
+	if !p.noVersionCheck {
+		err := p.retry(ctx, s, "version check", func() error {
+			var err error
+			ver, err = p.cachedVersion(ctx, r)
+			return err
+		})
+		if err != nil {
+			p.metrics.versionFailures.Add(1)
+			return remoteConn{}, stageVersion, fmt.Errorf("version error, got: %w", err)
+		}
+		s.infof("endpoint %s reported: %s", r.host, string(ver.Raw()))
+		if err := p.checkProtocol(ver); err != nil {
+			return remoteConn{}, stageVersion, err
+		}
+	}
+	endpoint := r.url(true, path.Join(path.Dir(req.URL.Path), id)).String()
+	// connect outgoing websocket
+	s.infof("connecting to %s", endpoint)
+	var out *websocket.Conn
+	var pres *http.Response
+	err := p.retry(ctx, s, "connecting to "+endpoint, func() error {
+		var err error
+		if out, pres, err = s.dialer.DialContext(ctx, endpoint, s.header); err != nil && pres != nil {
+			pres.Body.Close()
+		}
+		return err
+	})
+	if p.logHandshake && pres != nil {
+		s.logHeaders("remote handshake response "+pres.Status, pres.Header)
+	}
+	if err != nil {
+		p.metrics.dialFailures.Add(1)
+		return remoteConn{}, stageDial, fmt.Errorf("could not connect to %s, got: %w", endpoint, err)
+	}
+	return remoteConn{conn: out, res: pres, ver: ver, endpoint: endpoint}, "", nil
+}
+
+// retry calls f until it succeeds, retrying up to the proxy's dial retries
+// with exponential backoff, or until the context is closed. Each failed
+// attempt is logged to the session.
+func (p *Proxy) retry(ctx context.Context, s *session, desc string, f func() error) error {
+	backoff := p.dialBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt > p.dialRetries || ctx.Err() != nil {
+			return err
+		}
+		s.logf("%s failed (attempt %d of %d), retrying in %v, got: %v", desc, attempt, p.dialRetries+1, backoff, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// checkVersion retrieves the version information for the remote endpoint.
+func (p *Proxy) checkVersion(ctx context.Context, r *remote) (*Version, error) {
+	body, err := p.remoteRequest(ctx, r, http.MethodGet, "/json/version")
+	if err != nil {
+		return nil, err
+	}
+	v := &Version{raw: body}
+	if err := json.Unmarshal(body, v); err != nil {
+		// the remote request only returns the bodies of 200 OK responses
+		return nil, fmt.Errorf("expected json result from /json/version (200 OK), got %q: %w", bodySnippet(body), err)
+	}
+	v.WebSocketDebuggerURL = p.normalizeHost(r, v.WebSocketDebuggerURL)
+	p.writeVersionFile(r, v)
+	return v, nil
+}
+
+// probeRemote checks that the remote is reachable, returning the browser's
+// version. Without version checks (see WithNoVersionCheck), only checks that
+// the remote accepts connections.
+func (p *Proxy) probeRemote(ctx context.Context, r *remote) (string, error) {
+	r = p.backend(ctx, r)
+	if !p.noVersionCheck {
+		v, err := p.checkVersion(ctx, r)
+		if err != nil {
+			return "", err
+		}
+		return v.Browser, nil
+	}
+	addr := r.host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		port := "80"
+		if r.secure {
+			port = "443"
+		}
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return "reachable", nil
+}
+
+// CheckRemotes checks that each of the proxy's remotes is reachable and
+// returns valid version information from its /json/version endpoint (or only
+// that it accepts connections, see WithNoVersionCheck), returning an error for
+// the first remote that fails the check. Without the check, an unreachable
+// remote is only reported when a client connects.
+func (p *Proxy) CheckRemotes(ctx context.Context) error {
+	for _, r := range p.remotes {
+		ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+		_, err := p.probeRemote(ctx, r)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("remote %s unavailable: %w", r.host, err)
+		}
+	}
+	return nil
+}
+
+// RemoteAddr returns the address of the remote with the name (empty for the
+// default remote), as passed to WithRemote (ie, "localhost:9222"), or an empty
+// string when there is no such remote.
+func (p *Proxy) RemoteAddr(name string) string {
+	if r := p.remoteByName(name); r != nil {
+		return r.host
+	}
+	return ""
+}
+
+// RemoteVersion returns the version information reported by the remote with
+// the name (empty for the default remote).
+func (p *Proxy) RemoteVersion(ctx context.Context, name string) (*Version, error) {
+	r := p.remoteByName(name)
+	if r == nil {
+		return nil, fmt.Errorf("unknown remote %q", name)
+	}
+	return p.checkVersion(ctx, r)
+}
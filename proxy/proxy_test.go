@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+// testTimeout is the timeout of the tests' sessions.
+const testTimeout = 10 * time.Second
+
+// logBuffer is a buffer safe for concurrent writes, capturing a proxy's
+// stdout log in tests.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write satisfies the io.Writer interface.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns the lines written to the buffer.
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// startProxy starts a proxy of the fake remote, listening in memory and
+// logging to stdout only, returning the proxy and its stdout log. The proxy is
+// stopped when the test ends.
+func startProxy(t testing.TB, remote *fakeremote.Remote, opts ...Option) (*Proxy, *logBuffer) {
+	t.Helper()
+	stdout := new(logBuffer)
+	opts = append([]Option{
+		WithListen(memoryListen),
+		WithRemote(remote.Addr),
+		WithNoLog(true),
+		WithStdout(stdout),
+	}, opts...)
+	p := New(opts...)
+	ln, err := p.Listen()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	serve(t, p, ln)
+	return p, stdout
+}
+
+// serve serves the proxy on the listener until the test ends, waiting for
+// the proxy's sessions to finish.
+func serve(t testing.TB, p *Proxy, ln net.Listener) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errc; err != nil {
+			t.Errorf("expected no error, got: %v", err)
+		}
+	})
+}
+
+// dialPage opens a client connection to the proxy's page target with the id,
+// closed when the test ends.
+func dialPage(t testing.TB, p *Proxy, id string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	c, _, err := p.DialClient(ctx, "/devtools/page/"+id, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+// roundTrip sends the command over the client connection, returning the next
+// message received.
+func roundTrip(t testing.TB, c *websocket.Conn, cmd string) []byte {
+	t.Helper()
+	if err := c.SetReadDeadline(time.Now().Add(testTimeout)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := c.WriteMessage(websocket.TextMessage, []byte(cmd)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	_, buf, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	return buf
+}
+
+// closeClient closes the client connection with a close message, waiting for
+// the proxy to close the connection in turn.
+func closeClient(t testing.TB, c *websocket.Conn) {
+	t.Helper()
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(testTimeout)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func TestProxyFakeRemote(t *testing.T) {
+	remote := fakeremote.New()
+	defer remote.Close()
+	remote.Reply = func(target string, msg []byte) [][]byte {
+		var cmd struct {
+			ID int64 `json:"id"`
+		}
+		_ = json.Unmarshal(msg, &cmd)
+		buf, _ := json.Marshal(map[string]any{"id": cmd.ID, "result": map[string]string{"target": target}})
+		return [][]byte{buf}
+	}
+	p, stdout := startProxy(t, remote)
+	c := dialPage(t, p, "P1")
+	if buf := roundTrip(t, c, `{"id":1,"method":"Page.navigate","params":{"url":"https://example.com/"}}`); string(buf) != `{"id":1,"result":{"target":"P1"}}` {
+		t.Errorf("expected the remote's response, got: %s", buf)
+	}
+	closeClient(t, c)
+	received := remote.Received()
+	if len(received) != 1 || received[0].Target != "P1" || string(received[0].Msg) != `{"id":1,"method":"Page.navigate","params":{"url":"https://example.com/"}}` {
+		t.Errorf("expected the command to be forwarded to P1, got: %v", received)
+	}
+	waitLog(t, stdout, "summary: duration")
+}
+
+// waitLog waits for the proxy's stdout log to contain the string, failing the
+// test when it does not in time.
+func waitLog(t testing.TB, stdout *logBuffer, s string) string {
+	t.Helper()
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if log := stdout.String(); strings.Contains(log, s) {
+			return log
+		}
+	}
+	t.Fatalf("expected the log to contain %q, got:\n%s", s, stdout.String())
+	return ""
+}
+
+func TestServeMetadataOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"record", WithRecord(t.TempDir() + "/sessions.cdpr")},
+		{"har", WithHAR(t.TempDir() + "/sessions.har")},
+		{"tee", WithTee("unix://" + t.TempDir() + "/tee.sock")},
+		{"db", WithDB(t.TempDir() + "/capture.sqlite")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := New(WithListen(memoryListen), WithNoLog(true), WithMetadataOnly(true), test.opt)
+			ln, err := p.Listen()
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if err := p.Serve(context.Background(), ln); err == nil || !strings.Contains(err.Error(), "metadata only") {
+				t.Errorf("expected a metadata only error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestWSS(t *testing.T) {
+	remote := fakeremote.NewTLS()
+	defer remote.Close()
+	stdout := new(logBuffer)
+	p := New(
+		WithListen("localhost:0"),
+		WithRemote("https://"+remote.Addr),
+		WithRemoteInsecure(true),
+		WithTLS(writeTestCert(t)),
+		WithNoLog(true),
+		WithStdout(stdout),
+	)
+	ln, err := p.Listen()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	serve(t, p, ln)
+	// clients offering HTTP/2 are kept on HTTP/1.1, so that they can upgrade
+	// to websockets
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	res, err := client.Get("https://" + ln.Addr().String() + "/json")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer res.Body.Close()
+	if res.ProtoMajor != 1 {
+		t.Errorf("expected HTTP/1.1, got: %s", res.Proto)
+	}
+	var targets []struct {
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&targets); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	exp := "wss://" + ln.Addr().String() + "/devtools/page/P1"
+	if len(targets) != 1 || targets[0].WebSocketDebuggerURL != exp {
+		t.Fatalf("expected target %s, got: %v", exp, targets)
+	}
+	d := &websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}}}
+	c, _, err := d.Dial(exp, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	defer c.Close()
+	if buf := roundTrip(t, c, `{"id":1,"method":"Page.enable"}`); string(buf) != `{"id":1,"method":"Page.enable"}` {
+		t.Errorf("expected the echoed command, got: %s", buf)
+	}
+	closeClient(t, c)
+	waitLog(t, stdout, "connected to wss://"+remote.Addr+"/devtools/page/P1")
+	if received := remote.Received(); len(received) != 1 || string(received[0].Msg) != `{"id":1,"method":"Page.enable"}` {
+		t.Errorf("expected the command to be forwarded, got: %v", received)
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errWriteQueueFull is the error ending a session whose write queue is full.
+var errWriteQueueFull = errors.New("write queue full")
+
+// writeQueue is a bounded queue of the messages to write in a direction,
+// written by a separate goroutine so that a slow peer does not stall reads
+// from the other peer (see WithWriteQueue).
+type writeQueue struct {
+	s    *session
+	dir  Direction
+	msgs chan queuedMessage
+	// failed is closed once a write fails, with the error in err
+	failed chan struct{}
+	err    error
+	done   chan struct{}
+	once   sync.Once
+}
+
+// queuedMessage is a queued message.
+type queuedMessage struct {
+	mt  int
+	buf []byte
+}
+
+// newWriteQueue creates and starts a write queue for the direction. A failed
+// write calls cancel, so that the reader of the direction stops promptly.
+func (s *session) newWriteQueue(ctx context.Context, dir Direction, cancel func()) *writeQueue {
+	q := &writeQueue{
+		s:      s,
+		dir:    dir,
+		msgs:   make(chan queuedMessage, s.p.writeQueue),
+		failed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go q.run(ctx, cancel)
+	return q
+}
+
+// run writes the queued messages until the queue is closed or a write fails.
+// When the context is closed, the write deadline of the connection is set to
+// unblock any pending write.
+func (q *writeQueue) run(ctx context.Context, cancel func()) {
+	defer close(q.done)
+	stop := context.AfterFunc(ctx, func() {
+		_ = q.s.out[q.dir].Load().NetConn().SetWriteDeadline(time.Now())
+	})
+	defer stop()
+	for m := range q.msgs {
+		err := q.s.write(q.dir, m.mt, m.buf)
+		switch {
+		case err == nil:
+			continue
+		case q.dir == Incoming && q.s.p.reconnect > 0:
+			// messages to a lost remote are dropped while reconnecting
+			q.s.logf("dropped message, could not write to the remote: %v", err)
+			continue
+		}
+		q.err = writeCloseError(q.dir, err)
+		close(q.failed)
+		cancel()
+		// discard the remaining messages, until the queue is closed
+		for range q.msgs {
+		}
+		return
+	}
+}
+
+// push queues the message. Returns errWriteQueueFull when the queue is full,
+// or the error of a failed write.
+func (q *writeQueue) push(mt int, buf []byte) error {
+	if err := q.failure(); err != nil {
+		return err
+	}
+	select {
+	case q.msgs <- queuedMessage{mt: mt, buf: buf}:
+		return nil
+	default:
+		return errWriteQueueFull
+	}
+}
+
+// failure returns the error of a failed write, or nil.
+func (q *writeQueue) failure() error {
+	select {
+	case <-q.failed:
+		return q.err
+	default:
+		return nil
+	}
+}
+
+// close closes the queue, waiting for the queued messages to be written.
+// Returns the error of a failed write, or nil.
+func (q *writeQueue) close() error {
+	q.once.Do(func() {
+		close(q.msgs)
+	})
+	<-q.done
+	return q.failure()
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+// floodEvents is the number of events the remote replies to a command with,
+// in the slow client tests.
+const floodEvents = 200
+
+// newFloodRemote starts a fake remote replying to each command with
+// floodEvents events of 8KiB.
+func newFloodRemote() *fakeremote.Remote {
+	remote := fakeremote.New()
+	remote.Reply = func(string, []byte) [][]byte {
+		var events [][]byte
+		for i := 0; i < floodEvents; i++ {
+			events = append(events, []byte(`{"method":"Flood.event","params":{"n":`+strconv.Itoa(i)+`,"data":"`+strings.Repeat("x", 8192)+`"}}`))
+		}
+		return events
+	}
+	return remote
+}
+
+func TestWriteQueueSlowClient(t *testing.T) {
+	remote := newFloodRemote()
+	defer remote.Close()
+	p, stdout := startProxy(t, remote, WithWriteQueue(floodEvents))
+	c := dialPage(t, p, "P1")
+	if err := c.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"method":"Flood.start"}`)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the remote's events are all read while the client is not reading
+	waitLog(t, stdout, `"n":`+strconv.Itoa(floodEvents-1)+`,`)
+	_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+	for i := 0; i < floodEvents; i++ {
+		_, buf, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected no error reading event %d, got: %v", i, err)
+		}
+		if !strings.HasPrefix(string(buf), `{"method":"Flood.event","params":{"n":`+strconv.Itoa(i)+`,`) {
+			t.Fatalf("expected event %d, got: %.60s", i, buf)
+		}
+	}
+	closeClient(t, c)
+}
+
+func TestWriteQueueFull(t *testing.T) {
+	remote := newFloodRemote()
+	defer remote.Close()
+	p, stdout := startProxy(t, remote, WithWriteQueue(10))
+	c := dialPage(t, p, "P1")
+	if err := c.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"method":"Flood.start"}`)); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	// the client does not read until the session is closed
+	waitLog(t, stdout, "write queue to the client full (10 messages), closing session")
+	_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		if _, _, err := c.ReadMessage(); err != nil {
+			break
+		}
+	}
+	waitLog(t, stdout, "---------- closing")
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxReconnectBackoff is the maximum wait between reconnection attempts.
+const maxReconnectBackoff = 2 * time.Second
+
+// reconnectWS proxies the messages read from the remote connection out to the
+// client, reconnecting to the remote when the remote connection is lost. The
+// error ending the session is sent to errc.
+//
+// Only lost connections (ie, when the browser crashed or restarted, or stopped
+// responding to keepalive pings) are reconnected. Close frames from the
+// remote, a closed client connection, messages exceeding the maximum message
+// size, and idle sessions end the session as usual.
+func (s *session) reconnectWS(ctx context.Context, r *remote, urlpath string, out *websocket.Conn, errc chan error) {
+	for {
+		remoteErrc := make(chan error, 1)
+		s.proxyWS(ctx, Outgoing, out, remoteErrc)
+		err := <-remoteErrc
+		if ctx.Err() != nil || isCloseError(err) || errors.Is(err, websocket.ErrReadLimit) || s.clientClosed.Load() || s.idle() {
+			errc <- err
+			return
+		}
+		s.logf("remote connection lost, reconnecting, got: %v", err)
+		c, endpoint, target, reconnErr := s.p.redial(ctx, s, r, urlpath)
+		if reconnErr != nil {
+			s.logf("could not reconnect to the remote, got: %v", reconnErr)
+			errc <- err
+			return
+		}
+		s.writeMu[Incoming].Lock()
+		s.out[Incoming].Store(c)
+		s.writeMu[Incoming].Unlock()
+		out.Close()
+		out = c
+		if id := path.Base(urlpath); target != id && !isBrowserPath(urlpath) {
+			s.logf("target %s is no longer listed, reconnected to %s, attached to page target %s instead", id, endpoint, target)
+		} else {
+			s.logf("reconnected to %s, attached to target %s", endpoint, target)
+		}
+	}
+}
+
+// redial reconnects to the remote target for the devtools path, re-resolving
+// the target from the remote until the proxy's reconnect timeout, waiting the
+// dial backoff before the first attempt, and doubling the wait on each
+// subsequent attempt. The endpoint and the id of the target connected to are
+// returned with the connection.
+func (p *Proxy) redial(ctx context.Context, s *session, r *remote, urlpath string) (*websocket.Conn, string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.reconnect)
+	defer cancel()
+	backoff := p.dialBackoff
+	for attempt := 1; ; attempt++ {
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, "", "", fmt.Errorf("gave up after %d attempts: %w", attempt-1, ctx.Err())
+		case <-t.C:
+		}
+		endpoint, target, err := p.resolveEndpoint(ctx, r, urlpath)
+		if err == nil {
+			var c *websocket.Conn
+			var res *http.Response
+			if c, res, err = s.dialer.DialContext(ctx, endpoint, s.header); err == nil {
+				res.Body.Close()
+				return c, endpoint, target, nil
+			}
+			if res != nil {
+				res.Body.Close()
+			}
+		}
+		if ctx.Err() == nil {
+			s.logf("reconnect attempt %d failed, got: %v", attempt, err)
+		}
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// resolveEndpoint returns the remote's websocket endpoint for the target of
+// the devtools path, and the target's id. Browser targets are resolved from
+// the remote's /json/version, and other targets from the remote's /json list.
+// When the target is no longer listed (ie, after the browser restarted), an
+// error is returned, unless reconnecting to any page is enabled (see
+// WithReconnectAnyPage), which falls back to the first page target.
+func (p *Proxy) resolveEndpoint(ctx context.Context, r *remote, urlpath string) (string, string, error) {
+	if isBrowserPath(urlpath) {
+		u, err := p.remoteEndpoint(ctx, r, true)
+		if err != nil {
+			return "", "", err
+		}
+		return u.String(), path.Base(u.Path), nil
+	}
+	targets, err := p.remoteTargets(ctx, r)
+	if err != nil {
+		return "", "", err
+	}
+	id, page := path.Base(urlpath), ""
+	for _, t := range targets {
+		switch {
+		case t.ID == id:
+			return r.url(true, urlpath).String(), id, nil
+		case page == "" && t.Type == "page":
+			page = t.ID
+		}
+	}
+	switch {
+	case !p.reconnectAnyPage:
+		return "", "", fmt.Errorf("target %s is no longer listed by the remote", id)
+	case page == "":
+		return "", "", errors.New("no page target listed by the remote")
+	}
+	return r.url(true, path.Join(path.Dir(urlpath), page)).String(), page, nil
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestReconnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		restart bool
+		anyPage bool
+		exp     string
+		target  string
+	}{
+		{"same target", false, false, "reconnected to ws://%s/devtools/page/P1, attached to target P1", "P1"},
+		{"target gone", true, false, "could not reconnect to the remote", ""},
+		{"target gone any page", true, true, "target P1 is no longer listed, reconnected to ws://%s/devtools/page/P2, attached to page target P2 instead", "P2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := fakeremote.New()
+			defer remote.Close()
+			p, stdout := startProxy(t, remote, WithReconnect(time.Second), WithDialRetries(0, 10*time.Millisecond), WithReconnectAnyPage(test.anyPage))
+			c := dialPage(t, p, "P1")
+			roundTrip(t, c, `{"id":1,"method":"Page.enable"}`)
+			if test.restart {
+				// the browser restarted, with a new page target
+				res, err := http.Get("http://" + remote.Addr + "/json/close/P1")
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				res.Body.Close()
+				remote.AddTarget(fakeremote.Target{ID: "P2", Type: "page"})
+			}
+			remote.CloseConns()
+			exp := test.exp
+			if test.target != "" {
+				exp = fmt.Sprintf(exp, remote.Addr)
+			}
+			waitLog(t, stdout, exp)
+			if test.target == "" {
+				// the client connection is closed with the session
+				_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+				if _, _, err := c.ReadMessage(); err == nil {
+					t.Errorf("expected the client connection to be closed")
+				}
+				if received := remote.Received(); len(received) != 1 {
+					t.Errorf("expected no messages after the reconnect, got: %v", received)
+				}
+				return
+			}
+			roundTrip(t, c, `{"id":2,"method":"Page.reload"}`)
+			received := remote.Received()
+			if len(received) != 2 || received[1].Target != test.target {
+				t.Errorf("expected the command to be sent to %s, got: %v", test.target, received)
+			}
+			closeClient(t, c)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// redacted is the value redacted fields are replaced with.
+const redacted = "***"
+
+// redact returns a copy of buf with the values at the proxy's redact paths
+// replaced. The original buf is returned when there are no redact paths, buf
+// is not a JSON object, or no field was redacted.
+func (p *Proxy) redact(buf []byte) []byte {
+	if len(p.redactPaths) == 0 {
+		return buf
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return buf
+	}
+	changed := false
+	for _, path := range p.redactPaths {
+		if redactPath(v, strings.Split(path, ".")) {
+			changed = true
+		}
+	}
+	if !changed {
+		return buf
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return buf
+	}
+	return b
+}
+
+// redactPath replaces the value at the path in v, returning true when a value
+// was replaced. Object keys are matched case-insensitively, and arrays are
+// traversed element by element.
+func redactPath(v interface{}, path []string) bool {
+	switch x := v.(type) {
+	case []interface{}:
+		changed := false
+		for _, e := range x {
+			if redactPath(e, path) {
+				changed = true
+			}
+		}
+		return changed
+	case map[string]interface{}:
+		changed := false
+		for k, e := range x {
+			if !strings.EqualFold(k, path[0]) {
+				continue
+			}
+			if len(path) == 1 {
+				x[k], changed = redacted, true
+			} else if redactPath(e, path[1:]) {
+				changed = true
+			}
+		}
+		return changed
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redirectFollower is a http.RoundTripper following the remote's redirects
+// server-side, so that the client only ever sees the final response (see
+// WithFollowRedirects).
+type redirectFollower struct {
+	cl *http.Client
+}
+
+// RoundTrip satisfies the http.RoundTripper interface.
+func (t redirectFollower) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.RequestURI = ""
+	res, err := t.cl.Do(out)
+	if err != nil {
+		return nil, err
+	}
+	// the response is rewritten for the path originally requested, not the
+	// path redirected to
+	res.Request = req
+	return res, nil
+}
+
+// rewriteLocation rewrites the Location header of a redirect from the remote
+// that points at the remote, so that it points at the frontend instead.
+// Redirects to other hosts are left untouched.
+func rewriteLocation(r *remote, res *http.Response, fe frontend) {
+	loc := res.Header.Get("Location")
+	if loc == "" {
+		return
+	}
+	u, err := url.Parse(loc)
+	if err != nil || (u.Host != "" && u.Host != r.host) || !strings.HasPrefix(u.Path, "/") {
+		return
+	}
+	if u.Host != "" {
+		u.Host, u.Scheme = fe.host, "http"
+		if fe.secure {
+			u.Scheme = "https"
+		}
+	}
+	u.Path, u.RawPath = fe.prefix+r.trimPath(false, u.Path), ""
+	res.Header.Set("Location", u.String())
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// remote is a remote browser endpoint.
+type remote struct {
+	// name is the name of the remote, used as the path prefix the remote is
+	// served under. Empty for the default remote served at the root.
+	name   string
+	host   string
+	secure bool
+	// path is the path prefix the remote's endpoints are served under,
+	// without a trailing / (ie, /chrome for a remote behind a reverse proxy
+	// at https://host/chrome/devtools/...). Empty for a remote at the root.
+	path string
+	// ws is the remote's websocket endpoint, when not served by the same host
+	// as the http endpoints (see WithRemoteWS)
+	ws *remote
+	// fallback is the remote failed over to when the remote cannot be
+	// connected to, if any
+	fallback *remote
+}
+
+// newRemote creates a remote for the address, which can be either a
+// host:port address, or a full URL (ie, https://host:port or wss://host:port)
+// when the remote is served over TLS, or under a path prefix (ie,
+// https://host/chrome).
+func newRemote(name, addr string) *remote {
+	r := &remote{name: name, host: addr}
+	if !strings.Contains(addr, "://") {
+		r.host = normalizeHost(addr)
+		return r
+	}
+	if u, err := url.Parse(addr); err == nil {
+		r.host, r.secure = u.Host, u.Scheme == "https" || u.Scheme == "wss"
+		r.path = strings.TrimSuffix(u.Path, "/")
+	}
+	return r
+}
+
+// normalizeHost normalizes a host:port address, so that IPv6 literals are
+// bracketed (ie, "::1" becomes "[::1]"). IPv6 literals with a port must
+// already be bracketed (ie, "[::1]:9222"), as an unbracketed literal is
+// always treated as an address without a port.
+func normalizeHost(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return net.JoinHostPort(host, port)
+	}
+	if ip := net.ParseIP(strings.Trim(addr, "[]")); ip != nil && strings.Contains(addr, ":") {
+		return "[" + ip.String() + "]"
+	}
+	return addr
+}
+
+// prefix returns the path prefix the remote is served under.
+func (r *remote) prefix() string {
+	if r.name == "" {
+		return ""
+	}
+	return "/" + r.name
+}
+
+// url builds a http (or websocket, when ws is true) url for the path on the
+// remote.
+func (r *remote) url(ws bool, urlpath string) *url.URL {
+	if ws && r.ws != nil {
+		return r.ws.url(true, urlpath)
+	}
+	scheme := "http"
+	if ws {
+		scheme = "ws"
+	}
+	if r.secure {
+		scheme += "s"
+	}
+	return &url.URL{Scheme: scheme, Host: r.host, Path: r.path + urlpath}
+}
+
+// trimPath returns the path of a url of the remote (or of its websocket
+// endpoint, when ws is true) without the remote's path prefix, for urls
+// reported by the remote that include the prefix.
+func (r *remote) trimPath(ws bool, urlpath string) string {
+	if ws && r.ws != nil {
+		return r.ws.trimPath(true, urlpath)
+	}
+	if rest, ok := strings.CutPrefix(urlpath, r.path); ok && r.path != "" && (rest == "" || rest[0] == '/') {
+		return rest
+	}
+	return urlpath
+}
+
+// normalizeHost returns the websocket url reported by the remote (ie, the
+// webSocketDebuggerUrl of /json/version) with the remote's scheme and host,
+// as remotes can report a host that is not reachable from the proxy (ie, a
+// container-internal hostname). The url is returned as is when normalization
+// is disabled (see WithNoNormalizeHost), or when it is not a valid url.
+func (p *Proxy) normalizeHost(r *remote, s string) string {
+	if p.noNormalizeHost || s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Path == "" {
+		return s
+	}
+	v := r.url(true, r.trimPath(true, u.Path))
+	v.RawQuery = u.RawQuery
+	return v.String()
+}
+
+// setRemote sets the remote with the name on the proxy, replacing any
+// existing remote with the same name. An empty addr removes the remote. The
+// addr can be a comma-separated list of addresses, the first being the
+// primary remote, and the others its fallbacks, in order.
+func (p *Proxy) setRemote(name, addr string) {
+	remotes := p.remotes[:0]
+	for _, r := range p.remotes {
+		if r.name != name {
+			remotes = append(remotes, r)
+		}
+	}
+	p.remotes = remotes
+	if addr == "" {
+		return
+	}
+	addrs := strings.Split(addr, ",")
+	r := newRemote(name, strings.TrimSpace(addrs[0]))
+	p.remotes = append(p.remotes, r)
+	for _, addr := range addrs[1:] {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			r.fallback = newRemote(name, addr)
+			r = r.fallback
+		}
+	}
+}
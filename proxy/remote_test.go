@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestRemoteURL(t *testing.T) {
+	tests := []struct {
+		addr string
+		ws   bool
+		exp  string
+	}{
+		{"localhost:9222", false, "http://localhost:9222/json/version"},
+		{"127.0.0.1:9222", true, "ws://127.0.0.1:9222/json/version"},
+		{"[::1]:9222", false, "http://[::1]:9222/json/version"},
+		{"[::1]:9222", true, "ws://[::1]:9222/json/version"},
+		{"::1", false, "http://[::1]/json/version"},
+		{"[fe80::1%eth0]:9222", false, "http://[fe80::1%25eth0]:9222/json/version"},
+		{"https://[::1]:9222/chrome", true, "wss://[::1]:9222/chrome/json/version"},
+	}
+	for i, test := range tests {
+		if s := newRemote("", test.addr).url(test.ws, "/json/version").String(); s != test.exp {
+			t.Errorf("test %d (%s): expected %s, got: %s", i, test.addr, test.exp, s)
+		}
+	}
+}
+
+func TestListenIPv4IPv6(t *testing.T) {
+	for _, listen := range []string{"127.0.0.1:0", "[::1]:0"} {
+		t.Run(listen, func(t *testing.T) {
+			remote := fakeremote.New()
+			defer remote.Close()
+			p := New(WithListen(listen), WithRemote(remote.Addr), WithNoLog(true), WithStdout(io.Discard))
+			ln, err := p.Listen()
+			if err != nil {
+				t.Skipf("cannot listen on %s: %v", listen, err)
+			}
+			serve(t, p, ln)
+			// the targets' websocket urls point at the listen address
+			res, err := http.Get("http://" + ln.Addr().String() + "/json")
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			defer res.Body.Close()
+			var targets []struct {
+				WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&targets); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			exp := "ws://" + ln.Addr().String() + "/devtools/page/P1"
+			if len(targets) != 1 || targets[0].WebSocketDebuggerURL != exp {
+				t.Fatalf("expected target %s, got: %v", exp, targets)
+			}
+			c, _, err := websocket.DefaultDialer.Dial(exp, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			defer c.Close()
+			if buf := roundTrip(t, c, `{"id":1,"method":"Page.enable"}`); string(buf) != `{"id":1,"method":"Page.enable"}` {
+				t.Errorf("expected the echoed command, got: %s", buf)
+			}
+			closeClient(t, c)
+		})
+	}
+}
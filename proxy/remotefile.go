@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// remoteFile is the file the address of the default remote is read from (see
+// WithRemoteFile and WithDevToolsActivePort).
+type remoteFile struct {
+	filename string
+	// activePort is true when the file is a browser's DevToolsActivePort
+	// file, instead of a file with the remote's address
+	activePort bool
+	// r is the default remote, standing in for the remote read from the
+	// file
+	r *remote
+
+	mu   sync.Mutex
+	addr string
+	cur  *remote
+	h    http.Handler
+	err  error
+}
+
+// readRemoteFile reads the address of the default remote from the proxy's
+// remote file, returning the remote for the address and its handler. The
+// remote and handler are kept for as long as the file has the same address.
+func (p *Proxy) readRemoteFile() (*remote, http.Handler, error) {
+	f := p.addrFile
+	var addr, browser string
+	var err error
+	if f.activePort {
+		addr, browser, err = readActivePortAddr(f.filename)
+	} else {
+		addr, err = readRemoteAddr(f.filename)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		// only log each new error, as the file is read on every request
+		if f.err == nil || f.err.Error() != err.Error() {
+			log.Print(err)
+		}
+		f.err = err
+		return nil, nil, err
+	}
+	f.err = nil
+	if addr != f.addr {
+		r := newRemote(f.r.name, addr)
+		f.addr, f.cur, f.h = addr, r, p.remoteHandler(r)
+		if f.activePort {
+			log.Printf("DevToolsActivePort file %s: using remote %s (browser target %s)", f.filename, addr, browser)
+		} else {
+			log.Printf("remote file %s: using remote %s", f.filename, addr)
+		}
+	}
+	return f.cur, f.h, nil
+}
+
+// readRemoteAddr reads the remote address from the file, which is its first
+// non-empty line.
+func readRemoteAddr(filename string) (string, error) {
+	buf, err := os.ReadFile(filename)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "", fmt.Errorf("remote file %s does not exist", filename)
+	case err != nil:
+		return "", fmt.Errorf("could not read remote file %s: %w", filename, err)
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("remote file %s is empty", filename)
+}
+
+// readActivePortAddr reads the address of the browser's remote debugging port
+// and the browser target's path from a browser's DevToolsActivePort file. The
+// browser is reached on the loopback address, as the file has no host.
+func readActivePortAddr(filename string) (string, string, error) {
+	port, browser, ok := readActivePort(filename)
+	if !ok {
+		if _, err := os.Stat(filename); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return "", "", fmt.Errorf("DevToolsActivePort file %s does not exist", filename)
+			}
+			return "", "", fmt.Errorf("could not read DevToolsActivePort file %s: %w", filename, err)
+		}
+		return "", "", fmt.Errorf("DevToolsActivePort file %s is incomplete", filename)
+	}
+	return net.JoinHostPort("127.0.0.1", port), browser, nil
+}
+
+// remoteFileHandler returns a http.Handler for the default remote read from
+// the proxy's remote file, re-reading the file on each request. Requests are
+// rejected while the file does not exist or is empty (or incomplete).
+func (p *Proxy) remoteFileHandler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, h, err := p.readRemoteFile()
+		if err != nil {
+			p.writeError(res, p.addrFile.r, stageDial, http.StatusBadGateway, "no remote address: "+err.Error())
+			return
+		}
+		h.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// remoteSelectHeader is the header of requests selecting the remote they are
+// served by (see WithRemoteSelect).
+const remoteSelectHeader = "X-CDP-Remote"
+
+// maxSelectedRemotes is the maximum number of selected remotes whose handlers
+// are kept, the oldest being dropped first.
+const maxSelectedRemotes = 256
+
+// selectRule is a remote address allowed by WithRemoteSelect: a host, matched
+// as an IP address, a CIDR, or a glob of the host's dot-separated labels (so
+// that a * cannot match across a .), and an exact port.
+type selectRule struct {
+	prefix netip.Prefix
+	glob   string
+	port   string
+}
+
+// parseSelectRule parses a remote select rule (ie, 10.0.0.5:9222,
+// 10.0.0.0/24:9222, 10.0.0.*:9222, or *.browsers.internal:9222).
+func parseSelectRule(s string) (selectRule, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return selectRule{}, fmt.Errorf("invalid remote select %q (expected host:port)", s)
+	}
+	host, port := strings.Trim(s[:i], "[]"), s[i+1:]
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return selectRule{}, fmt.Errorf("invalid remote select %q (expected a port)", s)
+	}
+	rule := selectRule{port: port}
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		rule.prefix = prefix.Masked()
+		return rule, nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		rule.prefix = netip.PrefixFrom(ip, ip.BitLen())
+		return rule, nil
+	}
+	if host == "" || strings.ContainsAny(host, "/:") {
+		return selectRule{}, fmt.Errorf("invalid remote select %q (expected an address, cidr, or host glob)", s)
+	}
+	if _, err := path.Match(host, ""); err != nil {
+		return selectRule{}, fmt.Errorf("invalid remote select %q: %w", s, err)
+	}
+	rule.glob = strings.ToLower(host)
+	return rule, nil
+}
+
+// match returns true when the host and port match the rule.
+func (rule selectRule) match(host, port string) bool {
+	if port != rule.port {
+		return false
+	}
+	if rule.prefix.IsValid() {
+		ip, err := netip.ParseAddr(host)
+		return err == nil && rule.prefix.Contains(ip.Unmap())
+	}
+	globs, labels := strings.Split(rule.glob, "."), strings.Split(strings.ToLower(host), ".")
+	if len(globs) != len(labels) {
+		return false
+	}
+	for i, glob := range globs {
+		if ok, _ := path.Match(glob, labels[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// selectAllowed returns true when the remote address selected by a request
+// matches one of the proxy's remote select rules.
+func (p *Proxy) selectAllowed(addr string) bool {
+	r := newRemote("", addr)
+	host, port, err := net.SplitHostPort(r.host)
+	switch {
+	case err == nil:
+	case strings.Contains(addr, "://"):
+		host, port = r.host, "80"
+		if r.secure {
+			port = "443"
+		}
+	default:
+		return false
+	}
+	for _, s := range p.remoteSelect {
+		if rule, err := parseSelectRule(s); err == nil && rule.match(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRemoteSelect checks the proxy's remote select rules.
+func (p *Proxy) checkRemoteSelect() error {
+	var errs []error
+	for _, s := range p.remoteSelect {
+		if _, err := parseSelectRule(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// selectedRemotes are the remotes selected by requests, by address, with the
+// addresses in the order they were first selected.
+type selectedRemotes struct {
+	mu    sync.Mutex
+	h     map[string]http.Handler
+	order []string
+}
+
+// selectedHandler returns the handler of the remote with the address selected
+// by a request, creating the remote on first use. Only the handlers of the
+// most recently created maxSelectedRemotes remotes are kept.
+func (p *Proxy) selectedHandler(addr string) http.Handler {
+	p.selected.mu.Lock()
+	defer p.selected.mu.Unlock()
+	if h, ok := p.selected.h[addr]; ok {
+		return h
+	}
+	if p.selected.h == nil {
+		p.selected.h = make(map[string]http.Handler)
+	}
+	if len(p.selected.order) >= maxSelectedRemotes {
+		delete(p.selected.h, p.selected.order[0])
+		p.selected.order = p.selected.order[1:]
+	}
+	h := p.remoteHandler(newRemote("", addr))
+	p.selected.h[addr] = h
+	p.selected.order = append(p.selected.order, addr)
+	return h
+}
+
+// remoteSelectHandler returns a http.Handler serving the requests with a
+// X-CDP-Remote header by the remote with the header's address, when the
+// address matches one of the proxy's remote select rules, and the requests
+// without the header by the handler. When the proxy has trusted proxies (see
+// WithTrustedProxies), the header is only honored from them, and requests
+// from other peers are rejected. Otherwise, the header is only honored from
+// peers on the proxy's host, and ignored from other peers.
+func (p *Proxy) remoteSelectHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		addr := req.Header.Get(remoteSelectHeader)
+		if addr == "" {
+			h.ServeHTTP(res, req)
+			return
+		}
+		// the header is not sent on to the remote
+		req.Header.Del(remoteSelectHeader)
+		addr = normalizeHost(addr)
+		switch {
+		case len(p.trustedProxies) != 0 && !p.trusted(peerHost(req)):
+			log.Printf("rejected %s %s from untrusted %s", remoteSelectHeader, addr, req.RemoteAddr)
+			http.Error(res, remoteSelectHeader+" is only accepted from trusted proxies", http.StatusForbidden)
+			return
+		case len(p.trustedProxies) == 0 && !localPeer(req):
+			log.Printf("ignored %s %s from untrusted %s", remoteSelectHeader, addr, req.RemoteAddr)
+			h.ServeHTTP(res, req)
+			return
+		case !p.selectAllowed(addr):
+			log.Printf("rejected %s %s from %s: remote not allowed", remoteSelectHeader, addr, req.RemoteAddr)
+			http.Error(res, "remote "+addr+" is not allowed", http.StatusForbidden)
+			return
+		}
+		p.selectedHandler(addr).ServeHTTP(res, req)
+	})
+}
+
+// localPeer returns true when the request's peer is on the proxy's host: a
+// loopback address, or a unix socket or in-memory peer, which have no IP
+// address.
+func localPeer(req *http.Request) bool {
+	ip, err := netip.ParseAddr(peerHost(req))
+	return err != nil || ip.Unmap().IsLoopback()
+}
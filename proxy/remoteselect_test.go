@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestSelectAllowed(t *testing.T) {
+	tests := []struct {
+		rule string
+		addr string
+		exp  bool
+	}{
+		{"10.0.0.*:9222", "10.0.0.5:9222", true},
+		{"10.0.0.*:9222", "10.0.0.1.attacker.example:9222", false},
+		{"10.0.0.*:9222", "10.0.0.5:9223", false},
+		{"10.0.0.*:9222", "10.0.0.5:92220", false},
+		{"10.0.0.0/24:9222", "10.0.0.5:9222", true},
+		{"10.0.0.0/24:9222", "10.0.1.5:9222", false},
+		{"10.0.0.0/24:9222", "10.0.0.5.attacker.example:9222", false},
+		{"10.0.0.5:9222", "10.0.0.5:9222", true},
+		{"10.0.0.5:9222", "10.0.0.50:9222", false},
+		{"[::1]:9222", "[::1]:9222", true},
+		{"*.browsers.internal:9222", "a.browsers.internal:9222", true},
+		{"*.browsers.internal:9222", "A.Browsers.Internal:9222", true},
+		{"*.browsers.internal:9222", "a.b.browsers.internal:9222", false},
+		{"*.browsers.internal:9222", "a.browsers.internal.attacker.example:9222", false},
+		{"*.browsers.internal:9222", "ws://a.browsers.internal:9222", true},
+		{"*.browsers.internal:443", "wss://a.browsers.internal/chrome", true},
+		{"10.0.0.*:9222", "10.0.0.5", false},
+	}
+	for i, test := range tests {
+		p := New(WithRemoteSelect(test.rule))
+		if ok := p.selectAllowed(test.addr); ok != test.exp {
+			t.Errorf("test %d (%s, %s): expected %t, got: %t", i, test.rule, test.addr, test.exp, ok)
+		}
+	}
+}
+
+func TestCheckRemoteSelect(t *testing.T) {
+	for i, rule := range []string{"10.0.0.*", "10.0.0.*:", "10.0.0.*:port", "10.0.0.*:0", "[:9222", ":9222", "10.0.0.0/33:9222"} {
+		if err := New(WithRemoteSelect(rule)).checkRemoteSelect(); err == nil {
+			t.Errorf("test %d (%s): expected error", i, rule)
+		}
+	}
+}
+
+func TestRemoteSelectUntrusted(t *testing.T) {
+	p := New(WithRemoteSelect("10.0.0.*:9222"))
+	h := p.remoteSelectHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for i, test := range []struct {
+		remoteAddr string
+		exp        int
+	}{
+		{"127.0.0.1:1234", 403},
+		{"192.0.2.10:1234", 200},
+	} {
+		req := httptest.NewRequest("GET", "/json/version", nil)
+		req.RemoteAddr = test.remoteAddr
+		// not allowed, so rejected only when the header is honored
+		req.Header.Set(remoteSelectHeader, "10.0.1.5:9222")
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != test.exp {
+			t.Errorf("test %d (%s): expected %d, got: %d", i, test.remoteAddr, test.exp, res.Code)
+		}
+	}
+}
+
+func TestSelectedHandlerLimit(t *testing.T) {
+	p := New()
+	for i := 0; i < maxSelectedRemotes+10; i++ {
+		p.selectedHandler("10.0.0.5:" + strconv.Itoa(1000+i))
+	}
+	if n := len(p.selected.h); n != maxSelectedRemotes {
+		t.Errorf("expected %d handlers, got: %d", maxSelectedRemotes, n)
+	}
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// replayTimeout is the time a replayed command waits for its response.
+const replayTimeout = 30 * time.Second
+
+// Replay replays the client to browser messages (the "<-" lines in the text
+// format, or "in" entries in the jsonl format) read from a log written by the
+// proxy to the proxy's default remote. The replayed session is logged in the
+// same way as a proxied session.
+//
+// The messages are replayed over a fresh websocket connection to a new page
+// target (created with /json/new), or to the browser target when the logged
+// session was a browser session. Each replayed command waits for its response
+// before the next message is sent. Truncated messages are skipped.
+func (p *Proxy) Replay(ctx context.Context, rd io.Reader) error {
+	if len(p.remotes) == 0 {
+		return errors.New("no remote")
+	}
+	r := p.remotes[0]
+	// read messages
+	var msgs []*LogEntry
+	browser, skipped := false, 0
+	lr := NewLogReader(rd)
+	for {
+		e, err := lr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+		case err != nil:
+			return fmt.Errorf("unable to read log: %w", err)
+		case !e.IsMessage():
+			if strings.HasPrefix(e.Log, "connecting to ") && strings.Contains(e.Log, p.wsPath+"browser/") {
+				browser = true
+			}
+			continue
+		case e.Dir != Incoming:
+			continue
+		case e.Metadata:
+			return errors.New("unable to replay a log without message payloads (logged with metadata only)")
+		case e.Truncated:
+			skipped++
+			continue
+		default:
+			msgs = append(msgs, e)
+			continue
+		}
+		break
+	}
+	endpoint, err := p.remoteEndpoint(ctx, r, browser)
+	if err != nil {
+		return err
+	}
+	// connect
+	id := endpoint.Path[strings.LastIndex(endpoint.Path, "/")+1:]
+	f, outs, filename := p.createLog(id)
+	// the log name is released once the log is closed
+	defer p.logNames.release(id)
+	if f != nil {
+		defer f.Close()
+	}
+	s := newSession(p, id, "replay", outs)
+	s.infof("---------- replaying %d messages (%d truncated skipped) ----------", len(msgs), skipped)
+	if filename != "" {
+		s.infof("logging to %s", filename)
+	}
+	s.infof("connecting to %s", endpoint)
+	conn, res, err := p.dialer.DialContext(ctx, endpoint.String(), p.remoteHeader)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", endpoint, err)
+	}
+	defer res.Body.Close()
+	defer conn.Close()
+	s.infof("connected to %s", endpoint)
+	// read responses
+	ids, errc := make(chan int64, 64), make(chan error, 1)
+	go func() {
+		for {
+			mt, buf, err := conn.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			f := &frame{dir: Outgoing, typ: mt, buf: buf}
+			s.logFrame(f)
+			if m := f.message(); m.ID != nil && m.Method == "" {
+				ids <- *m.ID
+			}
+		}
+	}()
+	// replay
+	for _, e := range msgs {
+		mt := websocket.TextMessage
+		if e.Binary {
+			mt = websocket.BinaryMessage
+		}
+		f := &frame{dir: Incoming, typ: mt, buf: e.Msg}
+		s.logFrame(f)
+		if err := conn.WriteMessage(mt, e.Msg); err != nil {
+			return err
+		}
+		m := f.message()
+		if m.ID == nil {
+			continue
+		}
+		if err := waitResponse(ctx, *m.ID, ids, errc); err != nil {
+			return err
+		}
+	}
+	s.infof("---------- replay finished ----------")
+	return conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+}
+
+// waitResponse waits for the response with the id.
+func waitResponse(ctx context.Context, id int64, ids <-chan int64, errc <-chan error) error {
+	timer := time.NewTimer(replayTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case v := <-ids:
+			if v == id {
+				return nil
+			}
+		case err := <-errc:
+			return err
+		case <-timer.C:
+			return fmt.Errorf("timeout waiting for response to id %d", id)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// remoteEndpoint returns a websocket endpoint on the remote, either the
+// browser target or a newly created page target.
+func (p *Proxy) remoteEndpoint(ctx context.Context, r *remote, browser bool) (*url.URL, error) {
+	r = p.backend(ctx, r)
+	var wsURL string
+	if browser {
+		v, err := p.checkVersion(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		wsURL = v.WebSocketDebuggerURL
+	} else {
+		body, err := p.remoteRequest(ctx, r, http.MethodPut, "/json/new")
+		if err != nil {
+			return nil, err
+		}
+		var v struct {
+			WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("expected json result: %w", err)
+		}
+		wsURL = p.normalizeHost(r, v.WebSocketDebuggerURL)
+	}
+	u, err := url.Parse(wsURL)
+	if err != nil || u.Path == "" {
+		return nil, fmt.Errorf("invalid webSocketDebuggerUrl %q", wsURL)
+	}
+	return u, nil
+}
+
+// setRemoteHeader sets the proxy's remote headers (see WithRemoteHeader) on
+// the header of a request to the remote.
+func (p *Proxy) setRemoteHeader(h http.Header) {
+	for k, v := range p.remoteHeader {
+		h[k] = v
+	}
+}
+
+// remoteRequest performs a http request against the path on the remote,
+// returning the response body.
+func (p *Proxy) remoteRequest(ctx context.Context, r *remote, method, urlpath string) ([]byte, error) {
+	r = p.backend(ctx, r)
+	req, err := http.NewRequestWithContext(ctx, method, r.url(false, urlpath).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	p.setRemoteHeader(req.Header)
+	cl := &http.Client{Transport: p.transport}
+	res, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned %s: %q", method, urlpath, res.Status, bodySnippet(body))
+	}
+	return body, nil
+}
+
+// maxBodySnippet is the maximum length of the snippets of the remote's
+// responses in errors.
+const maxBodySnippet = 120
+
+// bodySnippet returns the start of a response body for errors, with its
+// whitespace collapsed, so that an unexpected response (ie, an html error
+// page) can be recognized.
+func bodySnippet(body []byte) string {
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if len(s) > maxBodySnippet {
+		s = strings.ToValidUTF8(s[:maxBodySnippet], "") + "..."
+	}
+	return s
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// rotateFile is a log file that rotates to numbered backups (ie,
+// cdp-<id>.1.log, cdp-<id>.2.log, ...) when its size limit is reached.
+//
+// When gzip is enabled, the file is written uncompressed while it is open (so
+// that a crash leaves a readable file), and is compressed to a .gz file after
+// it has been closed or rotated.
+type rotateFile struct {
+	mu       sync.Mutex
+	filename string
+	maxSize  int64
+	backups  int
+	gzip     bool
+	f        *os.File
+	size     int64
+	// files is the set of open log files the file was opened in, if any
+	files *logFiles
+}
+
+// logFiles is the set of the proxy's open log files, which are reopened
+// together (see Proxy.ReopenLogs).
+type logFiles struct {
+	mu    sync.Mutex
+	files map[*rotateFile]bool
+	// closed is called with the names of each closed file and its backups,
+	// when set (see WithLogS3)
+	closed func(names []string)
+}
+
+// open opens the named log file (see openRotateFile), adding it to the set
+// until it is closed.
+func (l *logFiles) open(filename string, maxSize int64, backups int, gzip bool) (*rotateFile, error) {
+	r, err := openRotateFile(filename, maxSize, backups, gzip)
+	if err != nil {
+		return nil, err
+	}
+	r.files = l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.files == nil {
+		l.files = make(map[*rotateFile]bool)
+	}
+	l.files[r] = true
+	return r, nil
+}
+
+// reopen reopens all the open log files.
+func (l *logFiles) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for r := range l.files {
+		if err := r.reopen(); err != nil {
+			errs = append(errs, fmt.Errorf("could not reopen log file %s: %w", r.filename, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// names returns the names of the open log files.
+func (l *logFiles) names() map[string]bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make(map[string]bool, len(l.files))
+	for r := range l.files {
+		names[r.filename] = true
+	}
+	return names
+}
+
+// remove removes the file from the set.
+func (l *logFiles) remove(r *rotateFile) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.files, r)
+}
+
+// openRotateFile opens the named file for appending, rotating it when
+// maxSize is exceeded and keeping at most backups old files. A maxSize of 0
+// disables rotation. The file's directory is created when missing.
+func openRotateFile(filename string, maxSize int64, backups int, gzip bool) (*rotateFile, error) {
+	r := &rotateFile{
+		filename: filename,
+		maxSize:  maxSize,
+		backups:  backups,
+		gzip:     gzip,
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the file.
+func (r *rotateFile) open() error {
+	f, err := os.OpenFile(r.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// Write satisfies the io.Writer interface.
+func (r *rotateFile) Write(buf []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(buf)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(buf)
+	r.size += int64(n)
+	return n, err
+}
+
+// reopen reopens the file by name, so that writes go to a new file when the
+// file has been renamed by an external tool (ie, logrotate). The current file
+// is kept when the file cannot be reopened.
+func (r *rotateFile) reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev := r.f
+	if err := r.open(); err != nil {
+		return err
+	}
+	return prev.Close()
+}
+
+// Close satisfies the io.Closer interface.
+func (r *rotateFile) Close() error {
+	if r.files != nil {
+		r.files.remove(r)
+	}
+	if err := r.close(); err != nil {
+		return err
+	}
+	if r.files != nil && r.files.closed != nil {
+		r.files.closed(r.names())
+	}
+	return nil
+}
+
+// close closes the file, compressing it when gzip is enabled.
+func (r *rotateFile) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.gzip {
+		return gzipFile(r.filename, r.filename+".gz")
+	}
+	return nil
+}
+
+// names returns the names of the closed file and of its existing backups.
+func (r *rotateFile) names() []string {
+	name := r.filename
+	if r.gzip {
+		name += ".gz"
+	}
+	names := []string{name}
+	for i := 1; i <= r.backups; i++ {
+		if _, err := os.Stat(r.backupName(i)); err == nil {
+			names = append(names, r.backupName(i))
+		}
+	}
+	return names
+}
+
+// rotate closes the current file, shifts the existing backups, and reopens
+// the file.
+func (r *rotateFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.backups > 0 {
+		_ = os.Remove(r.backupName(r.backups))
+		for i := r.backups - 1; i > 0; i-- {
+			_ = os.Rename(r.backupName(i), r.backupName(i+1))
+		}
+		name := strings.TrimSuffix(r.backupName(1), ".gz")
+		if err := os.Rename(r.filename, name); err != nil {
+			return err
+		}
+		if r.gzip {
+			if err := gzipFile(name, name+".gz"); err != nil {
+				return err
+			}
+		}
+	} else if err := os.Remove(r.filename); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// backupName returns the name of the n-th backup of the file.
+func (r *rotateFile) backupName(n int) string {
+	ext := filepath.Ext(r.filename)
+	name := fmt.Sprintf("%s.%d%s", strings.TrimSuffix(r.filename, ext), n, ext)
+	if r.gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// gzipFile compresses the file src to dst, removing src. When dst already
+// exists, the compressed data is appended as an additional gzip member.
+//
+// The compressed data is written to a temporary file that is renamed to dst,
+// so that dst is never left with a truncated gzip stream.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	// copy existing members
+	if prev, err := os.Open(dst); err == nil {
+		_, err = io.Copy(tmp, prev)
+		prev.Close()
+		if err != nil {
+			return err
+		}
+	}
+	w := gzip.NewWriter(tmp)
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
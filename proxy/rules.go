@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Rule is a routing rule, routing the targets whose url, host or title match
+// the rule's pattern to a remote (see WithRules).
+type Rule struct {
+	// Remote is the name of the remote the matching targets are routed to,
+	// empty for the default remote.
+	Remote string
+	// Field is the target field matched by the pattern: "url", "host" (the
+	// url's host name), or "title".
+	Field string
+	// Pattern is the pattern matched against the field, where "*" matches any
+	// sequence of characters (including "/") and "?" any single character.
+	Pattern string
+}
+
+// ruleFields are the target fields a rule can match.
+var ruleFields = map[string]bool{"url": true, "host": true, "title": true}
+
+// ReadRules reads routing rules, one per line in the form "<remote> <field>
+// <pattern>" (ie, "a host *.internal"), where the pattern is the rest of the
+// line. The default remote is named "default". Empty lines and lines starting
+// with "#" are ignored.
+func ReadRules(rd io.Reader) ([]Rule, error) {
+	var rules []Rule
+	s := bufio.NewScanner(rd)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || strings.TrimSpace(fields[2]) == "" {
+			return nil, fmt.Errorf("line %d: expected <remote> <field> <pattern>", n)
+		}
+		rule := Rule{Remote: fields[0], Field: fields[1], Pattern: strings.TrimSpace(fields[2])}
+		if !ruleFields[rule.Field] {
+			return nil, fmt.Errorf("line %d: invalid field %q (expected url, host, or title)", n, rule.Field)
+		}
+		if rule.Remote == "default" {
+			rule.Remote = ""
+		}
+		rules = append(rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// match returns true when the rule matches the target.
+func (rule Rule) match(t Target) bool {
+	switch rule.Field {
+	case "url":
+		return matchWildcard(rule.Pattern, t.URL)
+	case "host":
+		u, err := url.Parse(t.URL)
+		return err == nil && matchWildcard(rule.Pattern, u.Hostname())
+	case "title":
+		return matchWildcard(rule.Pattern, t.Title)
+	}
+	return false
+}
+
+// matchWildcard returns true when s matches the pattern, where "*" matches any
+// sequence of characters and "?" any single character.
+func matchWildcard(pattern, s string) bool {
+	// the position of the last "*" in the pattern, and of s where it matched
+	star, next := -1, 0
+	i, j := 0, 0
+	for j < len(s) {
+		switch {
+		case i < len(pattern) && (pattern[i] == '?' || pattern[i] == s[j]):
+			i++
+			j++
+		case i < len(pattern) && pattern[i] == '*':
+			star, next = i, j
+			i++
+		case star != -1:
+			// let the last "*" match one more character
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(pattern) && pattern[i] == '*' {
+		i++
+	}
+	return i == len(pattern)
+}
+
+// route returns the name of the remote the target is routed to by the first
+// matching rule. Returns false when no rule matches the target.
+func (p *Proxy) route(t Target) (string, bool) {
+	for _, rule := range p.rules {
+		if rule.match(t) {
+			return rule.Remote, true
+		}
+	}
+	return "", false
+}
+
+// checkRules checks that the proxy's rules route to configured remotes.
+func (p *Proxy) checkRules() error {
+	for _, rule := range p.rules {
+		if p.remoteByName(rule.Remote) == nil {
+			name := rule.Remote
+			if name == "" {
+				name = "default"
+			}
+			return fmt.Errorf("rule %q routes to unknown remote %q", rule.Field+" "+rule.Pattern, name)
+		}
+	}
+	return nil
+}
+
+// remoteByName returns the remote with the name, or nil.
+func (p *Proxy) remoteByName(name string) *remote {
+	for _, r := range p.remotes {
+		if r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// routedTarget is a target listed at the root, with its raw json.
+type routedTarget struct {
+	r      *remote
+	t      Target
+	fields map[string]json.RawMessage
+}
+
+// routedTargets returns the targets of all remotes that are listed at the root
+// by the proxy's rules: targets matching a rule are only listed from the
+// rule's remote, and targets matching no rule are listed from every remote.
+// Remotes that cannot be reached are skipped.
+func (p *Proxy) routedTargets(ctx context.Context) ([]routedTarget, error) {
+	var targets []routedTarget
+	var lastErr error
+	reached := false
+	for _, r := range p.remotes {
+		body, err := p.remoteRequest(ctx, r, http.MethodGet, "/json")
+		if err != nil {
+			log.Printf("could not list the targets of remote %s: %v", r.host, err)
+			lastErr = err
+			continue
+		}
+		var v []Target
+		var fields []map[string]json.RawMessage
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("expected json result: %w", err)
+		}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, fmt.Errorf("expected json result: %w", err)
+		}
+		reached = true
+		for i, t := range v {
+			t.Remote = r.name
+			if name, ok := p.route(t); ok && name != r.name {
+				continue
+			}
+			targets = append(targets, routedTarget{r: r, t: t, fields: fields[i]})
+		}
+	}
+	if !reached && lastErr != nil {
+		return nil, lastErr
+	}
+	return targets, nil
+}
+
+// routeHandler returns a http.Handler for the root that routes requests to
+// the remotes' handlers by the proxy's rules.
+//
+// The target list endpoints list the routed targets of all remotes, with their
+// urls pointing at their remote's path prefix. New targets are created on the
+// remote of the first rule matching the new target's url, and requests for an
+// existing target (ie, /devtools/page/<id>, /json/close/<id>) are sent to the
+// remote listing the routed target. Other requests are sent to the fallback
+// remote.
+func (p *Proxy) routeHandler(handlers map[*remote]http.Handler, fallback *remote) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		r, urlpath := fallback, strings.TrimSuffix(req.URL.Path, "/")
+		switch {
+		case urlpath == "/json" || urlpath == "/json/list":
+			p.serveRoutedTargets(res, req)
+			return
+		case urlpath == "/json/new":
+			// the new target's url is the raw query (ie, /json/new?https://...)
+			u, _ := url.QueryUnescape(req.URL.RawQuery)
+			if strings.HasPrefix(u, "url=") {
+				u = u[4:]
+			}
+			if name, ok := p.route(Target{URL: u}); ok && p.remoteByName(name) != nil {
+				r = p.remoteByName(name)
+			}
+		case strings.HasPrefix(urlpath, p.wsPath+"page/"),
+			strings.HasPrefix(urlpath, "/json/activate/"),
+			strings.HasPrefix(urlpath, "/json/close/"):
+			targets, err := p.routedTargets(req.Context())
+			if err != nil {
+				http.Error(res, fmt.Sprintf("could not list targets: %v", err), http.StatusBadGateway)
+				return
+			}
+			id := path.Base(urlpath)
+			for _, t := range targets {
+				if t.t.ID == id {
+					r = t.r
+					break
+				}
+			}
+		}
+		handlers[r].ServeHTTP(res, req)
+	})
+}
+
+// serveRoutedTargets serves the routed target list of all remotes.
+func (p *Proxy) serveRoutedTargets(res http.ResponseWriter, req *http.Request) {
+	targets, err := p.routedTargets(req.Context())
+	if err != nil {
+		http.Error(res, fmt.Sprintf("could not list targets: %v", err), http.StatusBadGateway)
+		return
+	}
+	list := make([]map[string]json.RawMessage, 0, len(targets))
+	for _, t := range targets {
+		rewriteTarget(t.r, t.fields, frontend{host: req.Host, secure: req.TLS != nil, prefix: t.r.prefix()})
+		list = append(list, t.fields)
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout is the timeout of uploading a log file to the s3 bucket.
+const s3Timeout = 5 * time.Minute
+
+// S3Config is the configuration of the s3 bucket log files are uploaded to
+// (see WithLogS3).
+type S3Config struct {
+	// Bucket is the bucket name.
+	Bucket string
+	// Prefix is the prefix of the object keys, without a trailing /.
+	Prefix string
+	// Region is the bucket's region (ie, us-east-1).
+	Region string
+	// Endpoint is the url of an s3 compatible object store (ie,
+	// http://localhost:9000), addressing the bucket by path. When empty, the
+	// bucket is addressed on the regional amazon s3 endpoint.
+	Endpoint string
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials
+	// requests are signed with. The session token is only set for temporary
+	// credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// ParseS3URL parses a s3://bucket/prefix url to the bucket and key prefix.
+func ParseS3URL(s string) (string, string, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", fmt.Errorf("invalid s3 url %q, expected s3://bucket/prefix", s)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// s3Uploader uploads the proxy's closed log files to the s3 bucket.
+type s3Uploader struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+// newS3Uploader creates an uploader to the s3 bucket.
+func newS3Uploader(cfg S3Config) *s3Uploader {
+	return &s3Uploader{
+		cfg:    cfg,
+		client: &http.Client{Timeout: s3Timeout},
+	}
+}
+
+// uploadLogs uploads the log files to the bucket, removing each uploaded
+// file. Files failing to upload are kept, and the error is logged.
+func (u *s3Uploader) uploadLogs(filenames []string) {
+	for _, filename := range filenames {
+		key := filepath.Base(filename)
+		if u.cfg.Prefix != "" {
+			key = u.cfg.Prefix + "/" + key
+		}
+		dest := "s3://" + u.cfg.Bucket + "/" + key
+		if err := u.upload(filename, key); err != nil {
+			log.Printf("could not upload log file %s to %s, keeping the file: %v", filename, dest, err)
+			continue
+		}
+		if err := os.Remove(filename); err != nil {
+			log.Printf("uploaded log file %s to %s, but could not remove the file: %v", filename, dest, err)
+			continue
+		}
+		log.Printf("uploaded log file %s to %s", filename, dest)
+	}
+}
+
+// upload uploads the file as the object with the key.
+func (u *s3Uploader) upload(filename, key string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// the payload is hashed for the signature before it is sent
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.objectURL(key), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasSuffix(filename, ".gz") {
+		contentType = "application/gzip"
+	}
+	req.Header.Set("Content-Type", contentType)
+	signS3(req, u.cfg, hex.EncodeToString(h.Sum(nil)), time.Now())
+	res, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	var s3Err struct {
+		Code    string
+		Message string
+	}
+	if body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024)); xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("bucket returned %s: %s: %s", res.Status, s3Err.Code, s3Err.Message)
+	}
+	return errors.New("bucket returned " + res.Status)
+}
+
+// objectURL returns the url of the object with the key.
+func (u *s3Uploader) objectURL(key string) string {
+	path := s3EscapePath(key)
+	switch {
+	case u.cfg.Endpoint != "":
+		return strings.TrimSuffix(u.cfg.Endpoint, "/") + "/" + u.cfg.Bucket + "/" + path
+	case strings.Contains(u.cfg.Bucket, "."):
+		// dotted bucket names do not match the endpoint's tls certificate
+		// as a subdomain
+		return "https://s3." + u.cfg.Region + ".amazonaws.com/" + u.cfg.Bucket + "/" + path
+	}
+	return "https://" + u.cfg.Bucket + ".s3." + u.cfg.Region + ".amazonaws.com/" + path
+}
+
+// s3EscapePath escapes the path as required by the signature: every byte but
+// the unreserved characters and / is percent encoded.
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signS3 signs the request with the credentials using AWS Signature Version 4,
+// for the payload's hex sha256 hash, signing the host and all of the request's
+// headers.
+func signS3(req *http.Request, cfg S3Config, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", cfg.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonical.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + cfg.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + cfg.SecretAccessKey)
+	for _, s := range []string{date, cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+cfg.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
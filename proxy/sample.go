@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"path"
+	"strconv"
+	"sync/atomic"
+)
+
+// sampleRule is a method glob whose messages are only logged at the rate (see
+// WithSample).
+type sampleRule struct {
+	glob string
+	rate float64
+}
+
+// sampleCount are the numbers of messages of a session matching a sample
+// rule, and of those logged.
+type sampleCount struct {
+	total  atomic.Int64
+	logged atomic.Int64
+}
+
+// logSample returns true when the frame is to be logged by the proxy's sample
+// rules, counting the frame for the first rule matching its method. Frames
+// matching no rule are always logged.
+func (s *session) logSample(f *frame) bool {
+	method := f.message().Method
+	for i, rule := range s.p.samples {
+		if ok, _ := path.Match(rule.glob, method); !ok {
+			continue
+		}
+		s.sampled[i].total.Add(1)
+		if rand.Float64() >= rule.rate {
+			return false
+		}
+		s.sampled[i].logged.Add(1)
+		return true
+	}
+	return true
+}
+
+// sampleSummary returns the summary lines of the session's sampled messages,
+// for the rules that matched any message.
+func (s *session) sampleSummary() []string {
+	var lines []string
+	for i, rule := range s.p.samples {
+		total := s.sampled[i].total.Load()
+		if total == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("summary: sampled %s=%s, logged %d of %d messages",
+			rule.glob, strconv.FormatFloat(rule.rate, 'g', -1, 64), s.sampled[i].logged.Load(), total))
+	}
+	return lines
+}
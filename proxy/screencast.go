@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// screencastTimeFormat is the format of the session start time in the names
+// of the image files.
+const screencastTimeFormat = "20060102T150405.000"
+
+// screencastSession saves the screencast frames of a session as image files
+// in the proxy's screencast directory (see WithScreencastDir).
+type screencastSession struct {
+	// prefix is the prefix of the session's image files, from its devtools
+	// id and start time
+	prefix string
+
+	mu sync.Mutex
+	// formats are the image formats requested by Page.startScreencast, per
+	// CDP session id
+	formats map[string]string
+	n       int
+}
+
+// newScreencastSession creates a new screencast session, with the prefix for
+// its image files.
+func newScreencastSession(prefix string) *screencastSession {
+	return &screencastSession{prefix: prefix, formats: make(map[string]string)}
+}
+
+// screencastMessage is the subset of the Page.startScreencast command and the
+// Page.screencastFrame event used by the proxy.
+type screencastMessage struct {
+	SessionID string `json:"sessionId"`
+	Params    struct {
+		Format string `json:"format"`
+		Data   string `json:"data"`
+	} `json:"params"`
+}
+
+// record records the image format of a Page.startScreencast command, and
+// saves the image of a Page.screencastFrame event, numbering the files in
+// order. Frames are saved as jpeg unless png was requested, as the browser
+// does.
+func (c *screencastSession) record(s *session, f *frame) {
+	method := f.message().Method
+	if f.dir == Incoming && method != "Page.startScreencast" || f.dir == Outgoing && method != "Page.screencastFrame" {
+		return
+	}
+	var msg screencastMessage
+	if err := json.Unmarshal(f.buf, &msg); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.dir == Incoming {
+		c.formats[msg.SessionID] = msg.Params.Format
+		return
+	}
+	buf, err := base64.StdEncoding.DecodeString(msg.Params.Data)
+	if err != nil {
+		s.logf("could not decode screencast frame: %v", err)
+		return
+	}
+	ext := ".jpg"
+	if c.formats[msg.SessionID] == "png" {
+		ext = ".png"
+	}
+	c.n++
+	name := filepath.Join(s.p.screencastDir, fmt.Sprintf("%s-%06d%s", c.prefix, c.n, ext))
+	if err := os.MkdirAll(s.p.screencastDir, 0o755); err != nil {
+		s.logf("could not save screencast frame: %v", err)
+		return
+	}
+	if err := os.WriteFile(name, buf, 0o644); err != nil {
+		s.logf("could not save screencast frame: %v", err)
+	}
+}
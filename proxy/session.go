@@ -0,0 +1,626 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// session is a proxied devtools session.
+type session struct {
+	p          *Proxy
+	id         string
+	remoteAddr string
+	logs       []*sessionLog
+	stats      *sessionStats
+	har        *harSession
+	pending    *pendingCommands
+	ordered    *orderedLog
+	timeline   *timelineSession
+	screencast *screencastSession
+	console    *consoleSession
+	domains    *domainLogs
+	span       *span
+	injected   injectedCommands
+	last       atomic.Int64
+	seq        atomic.Int64
+	// budget are the messages and bytes sent by the client, and the bytes
+	// sent by the remote, when limited (see WithSessionMaxFrames,
+	// WithSessionMaxBytes and WithSessionMaxRemoteBytes)
+	budget sessionBudget
+	// logged are the numbers of messages of each direction logged, when
+	// only logging the first messages (see WithHeadFrames)
+	logged [2]atomic.Int64
+	// sampled are the numbers of messages matching each of the proxy's
+	// sample rules, and of those logged (see WithSample)
+	sampled []sampleCount
+	// errs are the error responses sent by the remote, counted when the
+	// session's messages are logged (see recordError)
+	errs sessionErrors
+	// discard is true when the session's messages are not logged (ie, when
+	// the log is discarded, or the session is filtered)
+	discard bool
+	// silent is true when nothing of the session is logged, not even its
+	// lifecycle lines (ie, with WithNoLog and WithNoStdout)
+	silent bool
+	// out are the connections the messages of each direction are written to,
+	// the remote connection being replaced when reconnecting
+	out [2]atomic.Pointer[websocket.Conn]
+	// writeMu serializes writes of each direction, as blocked commands are
+	// replied to by the incoming direction
+	writeMu [2]sync.Mutex
+	// clientClosed is set once the client connection has been closed, other
+	// than by a read timeout
+	clientClosed atomic.Bool
+	// failed is set once the session has failed: before its websocket was
+	// upgraded, or by closing without a normal close frame
+	failed atomic.Bool
+	// detachOnce sends the Inspector.detached event to the client once (see
+	// WithDetachOnClose)
+	detachOnce sync.Once
+	// dialer is the dialer for the session's remote connections, with the
+	// subprotocols requested by the client (see WithSubprotocols)
+	dialer *websocket.Dialer
+	// conn is the client's connection (see connInfo)
+	conn connInfo
+	// gate pauses the session's forwarding (see pauseGate)
+	gate pauseGate
+	// header is the header of the session's websocket handshakes with the
+	// remote (see WithRemoteHeader and WithForwardedFor)
+	header http.Header
+}
+
+// newSession creates a new session logging to the outputs.
+func newSession(p *Proxy, id, remoteAddr string, outs []logOutput) *session {
+	s := &session{
+		p:          p,
+		id:         id,
+		remoteAddr: remoteAddr,
+		stats:      newSessionStats(),
+		dialer:     p.dialer,
+		header:     p.remoteHeader,
+		discard:    true,
+	}
+	for _, out := range outs {
+		s.logs = append(s.logs, s.newLog(out))
+		if out.w != io.Discard {
+			s.discard = false
+		}
+	}
+	s.silent = s.discard
+	if len(p.samples) != 0 {
+		s.sampled = make([]sampleCount, len(p.samples))
+	}
+	if p.harFile != nil {
+		s.har = newHarSession()
+	}
+	if p.timeline != nil {
+		s.timeline = new(timelineSession)
+	}
+	if p.screencastDir != "" {
+		s.screencast = newScreencastSession(CleanLogName(id) + "-" + s.stats.start.Format(screencastTimeFormat))
+	}
+	if p.consoleDir != "" {
+		s.console = newConsoleSession(s.consoleFilename())
+	}
+	// responses are delayed, dropped and logged as errors by the methods of
+	// their commands
+	if p.latency || p.traceCalls && p.tracer != nil || p.timeline != nil || len(p.delays) != 0 || len(p.drops) != 0 || !s.discard {
+		s.pending = newPendingCommands()
+	}
+	s.last.Store(time.Now().UnixNano())
+	return s
+}
+
+// newLog creates a log of the session writing to the output.
+func (s *session) newLog(out logOutput) *sessionLog {
+	p := s.p
+	// the run id is logged after the timestamp
+	flags, prefix := log.LstdFlags|log.Lmsgprefix, ""
+	if p.logMicros {
+		flags |= log.Lmicroseconds
+	}
+	if p.runID != "" {
+		prefix = "[" + p.runID + "] "
+	}
+	w := out.w
+	switch {
+	case out.format == FormatJSONL:
+		// jsonl entries carry the run id
+		flags, prefix = 0, ""
+	case p.logPrefix != "" && w != io.Discard:
+		// the prefix template replaces the timestamp and run id
+		flags, prefix, w = 0, "", prefixWriter{w: w, s: s}
+	}
+	return &sessionLog{logger: log.New(w, prefix, flags), format: out.format, split: out.split}
+}
+
+// proxyWS proxies messages read from in to the session's connection for the
+// direction, logging the message with the passed direction. Any error
+// encountered will be sent to errc.
+//
+// When the context is closed, the read deadline on in is set to unblock any
+// pending read, so that proxyWS returns promptly.
+//
+// With a write queue (see WithWriteQueue), messages are written by a separate
+// goroutine, and the session is closed once the queue is full.
+func (s *session) proxyWS(ctx context.Context, dir Direction, in *websocket.Conn, errc chan error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		_ = in.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	var q *writeQueue
+	if s.p.writeQueue > 0 {
+		q = s.newWriteQueue(ctx, dir, cancel)
+		defer q.close()
+	}
+	if s.p.maxMessageSize > 0 {
+		in.SetReadLimit(s.p.maxMessageSize)
+	}
+	_ = s.extendReadDeadline(ctx, in)
+	if s.p.keepalive > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		in.SetPongHandler(func(string) error {
+			return s.extendReadDeadline(ctx, in)
+		})
+		go s.ping(ctx, in)
+	}
+	if q == nil && s.passthrough() {
+		s.copyWS(ctx, dir, in, errc)
+		return
+	}
+	for {
+		if q != nil {
+			if err := q.failure(); err != nil {
+				errc <- err
+				return
+			}
+		}
+		mt, buf, err := in.ReadMessage()
+		if err != nil {
+			errc <- s.readFailed(dir, q, err)
+			return
+		}
+		if err := s.gate.wait(ctx); err != nil {
+			errc <- err
+			return
+		}
+		seq := s.seq.Add(1)
+		// a message in either direction keeps both connections from idling
+		s.last.Store(time.Now().UnixNano())
+		if err := s.extendReadDeadline(ctx, in); err != nil {
+			errc <- err
+			return
+		}
+		_ = s.extendReadDeadline(ctx, s.out[dir].Load())
+		if s.p.hasBudget() {
+			if err := s.spend(dir, len(buf)); err != nil {
+				errc <- err
+				return
+			}
+		}
+		if s.p.onMessage != nil {
+			switch buf, err = s.p.onMessage(dir, buf); {
+			case errors.Is(err, ErrDropMessage):
+				continue
+			case err != nil:
+				s.logf("message hook error, closing session: %v", err)
+				errc <- err
+				return
+			}
+		}
+		if s.p.exec != nil {
+			switch buf, err = s.p.exec.process(s, dir, buf); {
+			case errors.Is(err, ErrDropMessage):
+				continue
+			case err != nil:
+				s.logf("exec program error, closing session: %v", err)
+				errc <- err
+				return
+			}
+		}
+		f := &frame{dir: dir, typ: mt, buf: buf, seq: seq, elapsed: time.Since(s.stats.start)}
+		s.stats.record(f)
+		s.p.metrics.record(f)
+		if s.p.archive != nil {
+			s.p.archive.record(s, f)
+		}
+		if s.p.tee != nil {
+			s.p.tee.record(s, f)
+		}
+		if s.p.db != nil {
+			s.p.db.record(s, f)
+		}
+		if s.har != nil {
+			s.har.record(f, s.p.redact(f.buf))
+		}
+		if s.screencast != nil {
+			s.screencast.record(s, f)
+		}
+		if s.console != nil {
+			s.console.record(s, f)
+		}
+		s.logFrame(f)
+		if dir == Outgoing && s.injectedResponse(f) {
+			continue
+		}
+		if dir == Incoming && s.p.validate {
+			rejected, err := s.rejectInvalid(f)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if rejected {
+				continue
+			}
+		}
+		if dir == Incoming && s.blocked(f) {
+			if err := s.replyBlocked(f); err != nil {
+				errc <- err
+				return
+			}
+			continue
+		}
+		if dir == Outgoing && !s.discard {
+			s.recordError(f)
+		}
+		// the error, delay and drop are looked up before the response's
+		// command is no longer pending
+		var delay time.Duration
+		if len(s.p.delays) != 0 {
+			delay = s.delay(f)
+		}
+		drop := len(s.p.drops) != 0 && s.drop(f)
+		if s.pending != nil {
+			s.trackCommand(f)
+		}
+		if drop {
+			continue
+		}
+		if delay > 0 {
+			if err := s.injectDelay(ctx, delay); err != nil {
+				errc <- err
+				return
+			}
+			// the read deadline may have passed while delaying the message
+			if err := s.extendReadDeadline(ctx, in); err != nil {
+				errc <- err
+				return
+			}
+		}
+		if q != nil {
+			if err := q.push(mt, buf); err != nil {
+				if errors.Is(err, errWriteQueueFull) {
+					peer := "remote"
+					if dir == Outgoing {
+						peer = "client"
+					}
+					s.logf("write queue to the %s full (%d messages), closing session", peer, s.p.writeQueue)
+				}
+				errc <- err
+				return
+			}
+			continue
+		}
+		if err := s.write(dir, mt, buf); err != nil {
+			// messages to a lost remote are dropped while reconnecting
+			if dir == Incoming && s.p.reconnect > 0 {
+				s.logf("dropped message, could not write to the remote: %v", err)
+				continue
+			}
+			errc <- writeCloseError(dir, err)
+			return
+		}
+	}
+}
+
+// readFailed handles the error reading a message of the direction, returning
+// the error closing the session.
+func (s *session) readFailed(dir Direction, q *writeQueue, err error) error {
+	// a client connection that timed out (ie, when idle) can still be
+	// written to
+	var netErr net.Error
+	if dir == Incoming && (!errors.As(err, &netErr) || !netErr.Timeout()) {
+		s.clientClosed.Store(true)
+	}
+	// write the queued messages before forwarding the close frame
+	if q != nil {
+		if werr := q.close(); werr != nil {
+			err = werr
+		}
+	}
+	s.forwardClose(dir, s.out[dir].Load(), err)
+	if errors.Is(err, websocket.ErrReadLimit) {
+		s.closeTooBig(dir)
+	}
+	return &closeError{reason: readCloseReason(dir, err), err: err}
+}
+
+// closeError is an error closing a session, with the reason the session was
+// closed.
+type closeError struct {
+	reason string
+	err    error
+}
+
+// Error satisfies the error interface.
+func (e *closeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *closeError) Unwrap() error {
+	return e.err
+}
+
+// readCloseReason returns the reason for closing a session after the error
+// reading a message of the direction.
+func readCloseReason(dir Direction, err error) string {
+	peer := "client"
+	if dir == Outgoing {
+		peer = "remote"
+	}
+	var closeErr *websocket.CloseError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		return "message too big from the " + peer
+	case errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure:
+		return peer + " closed"
+	case errors.As(err, &closeErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return peer + " disconnected without closing"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout reading from the " + peer
+	}
+	return "read error from the " + peer + ": " + err.Error()
+}
+
+// writeCloseError returns the error closing a session after the error writing
+// a message of the direction.
+func writeCloseError(dir Direction, err error) error {
+	peer := "remote"
+	if dir == Outgoing {
+		peer = "client"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &closeError{reason: "timeout writing to the " + peer, err: err}
+	}
+	return &closeError{reason: "write error to the " + peer + ": " + err.Error(), err: err}
+}
+
+// closeReason returns the reason the session was closed, by the error read
+// from the session's proxying goroutines (nil when the context was closed
+// first).
+func (s *session) closeReason(ctx context.Context, err error) string {
+	if err == nil || ctx.Err() != nil {
+		return "closed by the proxy"
+	}
+	if s.timeoutReason(ctx, err) != "" {
+		if s.idle() {
+			return "idle timeout"
+		}
+		return "keepalive timeout"
+	}
+	var closeErr *closeError
+	if errors.As(err, &closeErr) {
+		return closeErr.reason
+	}
+	return err.Error()
+}
+
+// closeTimeout is the time to wait when forwarding a close frame, and for the
+// peer to respond to a forwarded close frame.
+const closeTimeout = time.Second
+
+// forwardClose forwards the close frame to out when err is a close error read
+// from the peer in the direction, with the same code and reason. Closures
+// without a close frame (ie, abnormal closures) are not forwarded.
+func (s *session) forwardClose(dir Direction, out *websocket.Conn, err error) {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return
+	}
+	switch closeErr.Code {
+	case websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+		return
+	}
+	peer := "client"
+	if dir == Outgoing {
+		peer = "remote"
+	}
+	if closeErr.Text != "" {
+		s.logf("%s closed the connection with code %d: %s", peer, closeErr.Code, closeErr.Text)
+	} else {
+		s.logf("%s closed the connection with code %d", peer, closeErr.Code)
+	}
+	msg := websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
+	_ = out.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+}
+
+// closeTooBig closes the session after the peer in the direction sent a
+// message larger than the proxy's maximum message size, sending the other peer
+// a close frame with the message too big code (the sending peer is sent one
+// when the message is read).
+func (s *session) closeTooBig(dir Direction) {
+	peer := "client"
+	if dir == Outgoing {
+		peer = "remote"
+	}
+	s.logf("message from the %s exceeds the maximum message size (%d bytes), closing session", peer, s.p.maxMessageSize)
+	msg := websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "message too big")
+	_ = s.out[dir].Load().WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+}
+
+// isCloseError returns true when err is a close error with a close frame.
+func isCloseError(err error) bool {
+	var closeErr *websocket.CloseError
+	return errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure
+}
+
+// write writes a message of the direction to the session's connection for the
+// direction.
+//
+// With a write timeout (see WithWriteTimeout), a write to a peer that stopped
+// reading fails once the timeout has elapsed.
+func (s *session) write(dir Direction, mt int, buf []byte) error {
+	s.writeMu[dir].Lock()
+	defer s.writeMu[dir].Unlock()
+	c := s.out[dir].Load()
+	if s.p.writeTimeout <= 0 {
+		return c.WriteMessage(mt, buf)
+	}
+	start := time.Now()
+	_ = c.SetWriteDeadline(start.Add(s.p.writeTimeout))
+	err := c.WriteMessage(mt, buf)
+	s.checkWriteTimeout(dir, start, err)
+	return err
+}
+
+// checkWriteTimeout logs when the write of the direction started at start
+// failed with err by timing out.
+func (s *session) checkWriteTimeout(dir Direction, start time.Time, err error) {
+	// the deadline is also set to unblock pending writes when the session is
+	// closed
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && time.Since(start) >= s.p.writeTimeout {
+		peer := "remote"
+		if dir == Outgoing {
+			peer = "client"
+		}
+		s.logf("write to the %s timed out after %v, closing session", peer, s.p.writeTimeout)
+	}
+}
+
+// blocked returns true when the frame is a command for one of the proxy's
+// blocked methods.
+func (s *session) blocked(f *frame) bool {
+	return len(s.p.block) != 0 && matchGlobs(s.p.block, f.message().Method)
+}
+
+// replyBlocked replies to a blocked command on the client connection with a
+// CDP error response. Blocked messages without an id are dropped without a
+// reply.
+func (s *session) replyBlocked(f *frame) error {
+	msg := f.message()
+	s.logf("blocked %s", msg.Method)
+	if msg.ID == nil {
+		return nil
+	}
+	return s.replyError(*msg.ID, msg.SessionID, blockedErrorCode, "blocked by proxy")
+}
+
+// replyError replies to a command on the client connection with a CDP error
+// response synthesized by the proxy.
+func (s *session) replyError(id int64, sessionID string, code int, message string) error {
+	buf, err := json.Marshal(blockedResponse{
+		ID:        id,
+		SessionID: sessionID,
+		Error:     cdpError{Code: code, Message: message},
+	})
+	if err != nil {
+		return err
+	}
+	s.logFrame(&frame{dir: Outgoing, typ: websocket.TextMessage, buf: buf})
+	return s.write(Outgoing, websocket.TextMessage, buf)
+}
+
+// blockedErrorCode is the CDP error code for blocked commands (method not
+// found).
+const blockedErrorCode = -32601
+
+// blockedResponse is the error response sent for a blocked or invalid
+// command.
+type blockedResponse struct {
+	ID        int64    `json:"id"`
+	SessionID string   `json:"sessionId,omitempty"`
+	Error     cdpError `json:"error"`
+}
+
+// cdpError is a CDP error.
+type cdpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// extendReadDeadline extends the read deadline on c when keepalive pings or
+// an idle timeout are enabled. With keepalive pings, a peer is allowed twice
+// the keepalive interval to send a message or pong, and with an idle timeout,
+// the deadline never extends past the idle timeout after the session's last
+// message.
+//
+// The deadline is extended before checking the context, so that a deadline
+// set when the context is closed is never overwritten.
+func (s *session) extendReadDeadline(ctx context.Context, c *websocket.Conn) error {
+	var t time.Time
+	if s.p.keepalive > 0 {
+		t = time.Now().Add(2 * s.p.keepalive)
+	}
+	if s.p.idleTimeout > 0 {
+		if idle := time.Unix(0, s.last.Load()).Add(s.p.idleTimeout); t.IsZero() || idle.Before(t) {
+			t = idle
+		}
+	}
+	if t.IsZero() {
+		return nil
+	}
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return c.SetReadDeadline(time.Now())
+	}
+	return nil
+}
+
+// timeoutReason returns the reason a session is closed when err is a read
+// deadline timeout caused by the idle timeout or keepalive pings.
+func (s *session) timeoutReason(ctx context.Context, err error) string {
+	var netErr net.Error
+	if ctx.Err() != nil || !errors.As(err, &netErr) || !netErr.Timeout() {
+		return ""
+	}
+	if s.idle() {
+		return fmt.Sprintf("no messages for %v, closing idle session", s.p.idleTimeout)
+	}
+	if s.p.keepalive > 0 {
+		return fmt.Sprintf("no response to keepalive pings for %v, closing session", 2*s.p.keepalive)
+	}
+	return ""
+}
+
+// idle returns true when the session has sent no message for the idle
+// timeout.
+func (s *session) idle() bool {
+	return s.p.idleTimeout > 0 && time.Since(time.Unix(0, s.last.Load())) >= s.p.idleTimeout
+}
+
+// ping sends a ping to c every keepalive interval, until the context is
+// closed.
+func (s *session) ping(ctx context.Context, c *websocket.Conn) {
+	t := time.NewTicker(s.p.keepalive)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.p.keepalive)); err != nil {
+				return
+			}
+		}
+	}
+}
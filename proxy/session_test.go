@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/chromedp/chromedp-proxy/proxy/internal/fakeremote"
+)
+
+func TestSessionGoroutines(t *testing.T) {
+	remote := fakeremote.New()
+	defer remote.Close()
+	p, _ := startProxy(t, remote)
+	// the first session opens the idle connections to the remote's http
+	// endpoints, which are kept
+	c := dialPage(t, p, "P1")
+	roundTrip(t, c, `{"id":1,"method":"Page.enable"}`)
+	closeClient(t, c)
+	tests := []struct {
+		name  string
+		close func(t *testing.T, c *websocket.Conn)
+	}{
+		{"client closed", func(t *testing.T, c *websocket.Conn) {
+			closeClient(t, c)
+		}},
+		{"client disconnected", func(t *testing.T, c *websocket.Conn) {
+			c.Close()
+		}},
+		{"remote disconnected", func(t *testing.T, c *websocket.Conn) {
+			remote.CloseConns()
+			_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseline := waitGoroutines(t, 0)
+			for i := 0; i < 10; i++ {
+				c := dialPage(t, p, "P1")
+				roundTrip(t, c, `{"id":1,"method":"Page.enable"}`)
+				test.close(t, c)
+			}
+			if n := waitGoroutines(t, baseline); n > baseline {
+				buf := make([]byte, 1<<20)
+				t.Errorf("expected at most %d goroutines, got: %d\n%s", baseline, n, buf[:runtime.Stack(buf, true)])
+			}
+		})
+	}
+}
+
+// waitGoroutines waits for the number of goroutines to settle at most at n
+// (or, with n of 0, to stop changing), returning the number of goroutines.
+func waitGoroutines(t testing.TB, n int) int {
+	t.Helper()
+	prev := runtime.NumGoroutine()
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		cur := runtime.NumGoroutine()
+		if n != 0 && cur <= n || n == 0 && cur == prev {
+			return cur
+		}
+		prev = cur
+	}
+	return prev
+}
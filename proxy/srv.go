@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// srvTimeout is the timeout of each DNS SRV lookup.
+const srvTimeout = 5 * time.Second
+
+// srvPool is the pool of backends of the default remote, resolved from a DNS
+// SRV record (see WithSRV).
+type srvPool struct {
+	// r is the default remote, with the SRV record's name as host
+	r *remote
+
+	mu       sync.Mutex
+	backends []*srvBackend
+	next     int
+}
+
+// srvBackend is a backend of a srv pool.
+type srvBackend struct {
+	r *remote
+	h http.Handler
+	// active is the number of active sessions on the backend
+	active int
+}
+
+// resolveSRV looks up the pool's SRV record, replacing the pool's backends.
+// Backends that are still listed are kept, along with their active session
+// counts.
+func (p *Proxy) resolveSRV(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, srvTimeout)
+	defer cancel()
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", p.pool.r.host)
+	if err != nil {
+		return fmt.Errorf("could not resolve srv %s: %w", p.pool.r.host, err)
+	}
+	pool := p.pool
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	prev := make(map[string]*srvBackend, len(pool.backends))
+	for _, b := range pool.backends {
+		prev[b.r.host] = b
+	}
+	backends := make([]*srvBackend, 0, len(records))
+	addrs := make([]string, 0, len(records))
+	changed := len(records) != len(pool.backends)
+	for _, rec := range records {
+		addr := net.JoinHostPort(strings.TrimSuffix(rec.Target, "."), strconv.Itoa(int(rec.Port)))
+		b, ok := prev[addr]
+		if !ok {
+			r := &remote{name: pool.r.name, host: addr, secure: pool.r.secure, path: pool.r.path}
+			b, changed = &srvBackend{r: r, h: p.remoteHandler(r)}, true
+		}
+		backends = append(backends, b)
+		addrs = append(addrs, addr)
+	}
+	pool.backends = backends
+	if changed {
+		log.Printf("srv %s resolved to %d backends: %s", pool.r.host, len(addrs), strings.Join(addrs, ", "))
+	}
+	return nil
+}
+
+// runSRV re-resolves the pool's SRV record at the proxy's interval, until the
+// context is done.
+func (p *Proxy) runSRV(ctx context.Context) {
+	ticker := time.NewTicker(p.srvInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.resolveSRV(ctx); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+// pick returns the backend with the fewest active sessions, taking turns
+// between backends with the same count. When session is true, the backend's
+// active session count is incremented, and must be released with done.
+func (pool *srvPool) pick(session bool) *srvBackend {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var best *srvBackend
+	for i := range pool.backends {
+		b := pool.backends[(pool.next+i)%len(pool.backends)]
+		if best == nil || b.active < best.active {
+			best = b
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	pool.next++
+	if session {
+		best.active++
+	}
+	return best
+}
+
+// done releases a session picked on the backend.
+func (pool *srvPool) done(b *srvBackend) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	b.active--
+}
+
+// pickBackend picks a backend of the srv pool (see srvPool.pick), first
+// resolving the pool when it has no backends (ie, when it has not been
+// resolved yet, as when the proxy's handler is used directly).
+func (p *Proxy) pickBackend(ctx context.Context, session bool) *srvBackend {
+	if b := p.pool.pick(session); b != nil {
+		return b
+	}
+	if err := p.resolveSRV(ctx); err != nil {
+		log.Print(err)
+	}
+	return p.pool.pick(session)
+}
+
+// backend returns the remote to send a request for the remote to, which is
+// one of the pool's backends for the default remote of a srv pool, the remote
+// read from the remote file for the default remote of a remote file, and the
+// remote itself otherwise.
+func (p *Proxy) backend(ctx context.Context, r *remote) *remote {
+	if p.addrFile != nil && r == p.addrFile.r {
+		if cur, _, err := p.readRemoteFile(); err == nil {
+			return cur
+		}
+		return r
+	}
+	if p.pool == nil || r != p.pool.r {
+		return r
+	}
+	if b := p.pickBackend(ctx, false); b != nil {
+		return b.r
+	}
+	return r
+}
+
+// srvHandler returns a http.Handler for the default remote of a srv pool,
+// sending each request to one of the pool's backends. Websocket sessions are
+// balanced across the backends by their active sessions, and stay on their
+// backend for their lifetime.
+func (p *Proxy) srvHandler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		session := websocket.IsWebSocketUpgrade(req)
+		b := p.pickBackend(req.Context(), session)
+		if b == nil {
+			http.Error(res, fmt.Sprintf("no backends for srv %s", p.pool.r.host), http.StatusBadGateway)
+			return
+		}
+		if session {
+			defer p.pool.done(b)
+		}
+		b.h.ServeHTTP(res, req)
+	})
+}
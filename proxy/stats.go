@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionStats are the accumulated statistics for a session.
+type sessionStats struct {
+	mu      sync.Mutex
+	start   time.Time
+	msgs    [2]int64
+	bytes   [2]int64
+	methods map[string]int64
+}
+
+// newSessionStats creates a new session stats.
+func newSessionStats() *sessionStats {
+	return &sessionStats{
+		start:   time.Now(),
+		methods: make(map[string]int64),
+	}
+}
+
+// record records a proxied frame.
+func (st *sessionStats) record(f *frame) {
+	method := f.message().Method
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.msgs[f.dir]++
+	st.bytes[f.dir] += int64(len(f.buf))
+	if method != "" {
+		st.methods[method]++
+	}
+}
+
+// recordSize records a proxied message of the direction by its size only,
+// without counting its method (see passthrough).
+func (st *sessionStats) recordSize(dir Direction, n int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.msgs[dir]++
+	st.bytes[dir] += n
+}
+
+// stats returns the session's statistics.
+func (st *sessionStats) stats() Stats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	methods := make(map[string]int64, len(st.methods))
+	for method, count := range st.methods {
+		methods[method] = count
+	}
+	return Stats{
+		Duration: time.Since(st.start),
+		Messages: st.msgs,
+		Bytes:    st.bytes,
+		Methods:  methods,
+	}
+}
+
+// summary returns the summary lines for the session.
+func (st *sessionStats) summary() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	type methodCount struct {
+		method string
+		count  int64
+	}
+	var counts []methodCount
+	for method, count := range st.methods {
+		counts = append(counts, methodCount{method, count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count == counts[j].count {
+			return counts[i].method < counts[j].method
+		}
+		return counts[i].count > counts[j].count
+	})
+	var top []string
+	for i := 0; i < len(counts) && i < 5; i++ {
+		top = append(top, fmt.Sprintf("%s=%d", counts[i].method, counts[i].count))
+	}
+	return []string{
+		fmt.Sprintf(
+			"summary: duration %s, in %d messages (%d bytes), out %d messages (%d bytes)",
+			time.Since(st.start).Round(time.Millisecond),
+			st.msgs[Incoming], st.bytes[Incoming],
+			st.msgs[Outgoing], st.bytes[Outgoing],
+		),
+		"summary: top methods: " + strings.Join(top, " "),
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows && !plan9
+
+package proxy
+
+import (
+	"log/syslog"
+	"net/url"
+)
+
+// dialSyslog connects to the syslog daemon at the address (ie,
+// udp://host:514), or to the local syslog daemon when the address is empty.
+func dialSyslog(addr string) (sysLogger, error) {
+	var network, raddr string
+	if addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "chromedp-proxy")
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package proxy
+
+import (
+	"errors"
+	"runtime"
+)
+
+// dialSyslog returns an error, as syslog is not available on the platform.
+func dialSyslog(string) (sysLogger, error) {
+	return nil, errors.New("syslog is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Target is a target exposed by a remote.
+type Target struct {
+	// Remote is the name of the remote exposing the target, empty for the
+	// default remote.
+	Remote string `json:"-"`
+	// ID is the target's devtools id.
+	ID string `json:"id"`
+	// Type is the target's type (ie, page, iframe, service_worker).
+	Type string `json:"type"`
+	// Title is the target's title.
+	Title string `json:"title"`
+	// URL is the target's url.
+	URL string `json:"url"`
+	// WebSocketDebuggerURL is the remote's websocket url for the target.
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	// DevtoolsFrontendURL is the remote's devtools frontend url for the
+	// target.
+	DevtoolsFrontendURL string `json:"devtoolsFrontendUrl"`
+}
+
+// Targets returns the targets exposed by each of the proxy's remotes, as
+// reported by the remote's /json endpoint.
+func (p *Proxy) Targets(ctx context.Context) ([]Target, error) {
+	var targets []Target
+	for _, r := range p.remotes {
+		v, err := p.remoteTargets(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, v...)
+	}
+	return targets, nil
+}
+
+// remoteTargets returns the targets exposed by the remote.
+func (p *Proxy) remoteTargets(ctx context.Context, r *remote) ([]Target, error) {
+	body, err := p.remoteRequest(ctx, r, http.MethodGet, "/json")
+	if err != nil {
+		return nil, err
+	}
+	var targets []Target
+	if err := json.Unmarshal(body, &targets); err != nil {
+		return nil, fmt.Errorf("expected json result: %w", err)
+	}
+	for i := range targets {
+		targets[i].Remote = r.name
+		targets[i].WebSocketDebuggerURL = p.normalizeHost(r, targets[i].WebSocketDebuggerURL)
+	}
+	return targets, nil
+}
+
+// isBrowserPath returns true when the devtools path is the path of the
+// browser target (ie, /devtools/browser/<id>), which is used for the
+// browser-wide Target and Browser domains rather than a single page.
+func isBrowserPath(urlpath string) bool {
+	return path.Base(path.Dir(urlpath)) == "browser"
+}
+
+// targetType returns the type of the remote's target for the devtools path
+// (ie, /devtools/page/<id>). Browser targets are not listed by the remote, and
+// have the type "browser".
+func (p *Proxy) targetType(ctx context.Context, r *remote, urlpath string) (string, error) {
+	if isBrowserPath(urlpath) {
+		return "browser", nil
+	}
+	targets, err := p.remoteTargets(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	id := path.Base(urlpath)
+	for _, t := range targets {
+		if t.ID == id {
+			return t.Type, nil
+		}
+	}
+	return "", fmt.Errorf("target %s not listed by the remote", id)
+}
+
+// The devtools alias paths of page targets, matched by their url or title
+// instead of their id (ie, /devtools/page-by-url/<escaped url>).
+const (
+	aliasByURL   = "page-by-url"
+	aliasByTitle = "page-by-title"
+)
+
+// resolveAlias resolves the devtools alias path of a request (see aliasByURL
+// and aliasByTitle) to the path of the first page target listed by the remote
+// whose url or title matches the path's unescaped glob (see path.Match),
+// returning a copy of the request with the target's path. Requests for other
+// paths are returned as is. When no target matches, the error is written and
+// false is returned.
+func (p *Proxy) resolveAlias(r *remote, res http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	// the url or title is escaped as a single path segment
+	dir, value := path.Split(req.URL.EscapedPath())
+	dir = strings.TrimSuffix(dir, "/")
+	kind := path.Base(dir)
+	if kind != aliasByURL && kind != aliasByTitle {
+		return req, true
+	}
+	glob, err := url.PathUnescape(value)
+	if err != nil {
+		p.writeError(res, r, stageTarget, http.StatusBadRequest, fmt.Sprintf("invalid %s alias %q", kind, value))
+		return nil, false
+	}
+	targets, err := p.remoteTargets(req.Context(), r)
+	if err != nil {
+		p.writeError(res, r, stageTarget, http.StatusBadGateway, fmt.Sprintf("could not list targets: %v", err))
+		return nil, false
+	}
+	for _, t := range targets {
+		v := t.URL
+		if kind == aliasByTitle {
+			v = t.Title
+		}
+		if t.Type != "page" || !matchGlobs([]string{glob}, v) {
+			continue
+		}
+		prefix, err := url.PathUnescape(path.Dir(dir))
+		if err != nil {
+			prefix = path.Dir(req.URL.Path)
+		}
+		log.Printf("resolved %s %s to target %s", kind, glob, t.ID)
+		req = req.Clone(req.Context())
+		req.URL.Path, req.URL.RawPath = path.Join(prefix, "page", t.ID), ""
+		return req, true
+	}
+	p.writeError(res, r, stageTarget, http.StatusNotFound, fmt.Sprintf("no page target with a %s matching %q", strings.TrimPrefix(kind, "page-by-"), glob))
+	return nil, false
+}
@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// teeBuffer is the number of messages buffered for the tee sink.
+	// Messages proxied while the buffer is full are dropped.
+	teeBuffer = 4096
+	// teeTimeout is the timeout of connecting to the tee sink and of each
+	// write to it, and of the final writes when the proxy is closed.
+	teeTimeout = 5 * time.Second
+	// teeRetry is the interval between attempts to connect to the tee sink,
+	// messages being dropped in between.
+	teeRetry = time.Second
+)
+
+// tee mirrors the proxied messages of all sessions to a sink, as json lines of
+// archive frames (see WithTee). Messages are buffered and written by a
+// separate goroutine, so that a slow or unavailable sink never delays the
+// proxied messages: messages are dropped for the sink instead.
+type tee struct {
+	addr    string
+	frames  chan []byte
+	dropped atomic.Int64
+	once    sync.Once
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// newTee creates a tee to the sink address, starting its writer.
+func newTee(addr string) *tee {
+	t := &tee{
+		addr:   addr,
+		frames: make(chan []byte, teeBuffer),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// record mirrors a proxied frame of the session to the sink, without blocking.
+func (t *tee) record(s *session, f *frame) {
+	buf, err := json.Marshal(ArchiveFrame{
+		Time:    time.Now(),
+		Session: s.id,
+		Remote:  s.remoteAddr,
+		Dir:     f.dir,
+		Binary:  f.typ == websocket.BinaryMessage,
+		Data:    f.buf,
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case t.frames <- append(buf, '\n'):
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run writes the buffered messages to the sink, connecting to the sink when
+// needed, until the tee is closed.
+func (t *tee) run() {
+	defer close(t.done)
+	var sink teeSink
+	defer func() {
+		if sink != nil {
+			sink.Close()
+		}
+	}()
+	var retry time.Time
+	down := false
+	write := func(buf []byte) {
+		if sink == nil {
+			if time.Now().Before(retry) {
+				t.dropped.Add(1)
+				return
+			}
+			var err error
+			if sink, err = dialTee(t.addr); err != nil {
+				if !down {
+					log.Printf("could not connect to tee sink %s, dropping messages until connected: %v", t.addr, err)
+				}
+				retry, down = time.Now().Add(teeRetry), true
+				t.dropped.Add(1)
+				return
+			}
+			down = false
+			if n := t.dropped.Swap(0); n != 0 {
+				log.Printf("connected to tee sink %s (%d messages dropped)", t.addr, n)
+			} else {
+				log.Printf("connected to tee sink %s", t.addr)
+			}
+		}
+		if err := sink.write(buf); err != nil {
+			log.Printf("could not write to tee sink %s, reconnecting: %v", t.addr, err)
+			sink.Close()
+			sink, retry = nil, time.Now().Add(teeRetry)
+			t.dropped.Add(1)
+		}
+	}
+	for {
+		select {
+		case buf := <-t.frames:
+			write(buf)
+		case <-t.quit:
+			for {
+				select {
+				case buf := <-t.frames:
+					write(buf)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close writes the buffered messages, and closes the sink.
+func (t *tee) Close() error {
+	t.once.Do(func() {
+		close(t.quit)
+	})
+	select {
+	case <-t.done:
+		return nil
+	case <-time.After(teeTimeout):
+		return fmt.Errorf("timed out writing to tee sink %s", t.addr)
+	}
+}
+
+// teeSink is a connected tee sink.
+type teeSink interface {
+	write(buf []byte) error
+	Close() error
+}
+
+// dialTee connects to the tee sink address, which is either a websocket url
+// (ws:// or wss://), a unix socket (unix:///path), a file or named pipe path
+// (starting with / or .), or a tcp address (host:port, optionally prefixed
+// with tcp://).
+func dialTee(addr string) (teeSink, error) {
+	switch {
+	case strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://"):
+		d := *websocket.DefaultDialer
+		d.HandshakeTimeout = teeTimeout
+		c, _, err := d.Dial(addr, nil)
+		if err != nil {
+			return nil, err
+		}
+		return wsTeeSink{c: c}, nil
+	case strings.HasPrefix(addr, "unix://"):
+		c, err := net.DialTimeout("unix", strings.TrimPrefix(addr, "unix://"), teeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return connTeeSink{c: c}, nil
+	case strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, "."):
+		// opening a named pipe blocks until it has a reader
+		f, err := os.OpenFile(addr, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return fileTeeSink{f: f}, nil
+	}
+	c, err := net.DialTimeout("tcp", strings.TrimPrefix(addr, "tcp://"), teeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return connTeeSink{c: c}, nil
+}
+
+// wsTeeSink is a websocket tee sink, written a text message per json line.
+type wsTeeSink struct {
+	c *websocket.Conn
+}
+
+// write satisfies the teeSink interface.
+func (s wsTeeSink) write(buf []byte) error {
+	_ = s.c.SetWriteDeadline(time.Now().Add(teeTimeout))
+	return s.c.WriteMessage(websocket.TextMessage, bytes.TrimSuffix(buf, []byte{'\n'}))
+}
+
+// Close satisfies the teeSink interface.
+func (s wsTeeSink) Close() error {
+	return s.c.Close()
+}
+
+// connTeeSink is a tcp or unix socket tee sink.
+type connTeeSink struct {
+	c net.Conn
+}
+
+// write satisfies the teeSink interface.
+func (s connTeeSink) write(buf []byte) error {
+	_ = s.c.SetWriteDeadline(time.Now().Add(teeTimeout))
+	_, err := s.c.Write(buf)
+	return err
+}
+
+// Close satisfies the teeSink interface.
+func (s connTeeSink) Close() error {
+	return s.c.Close()
+}
+
+// fileTeeSink is a file or named pipe tee sink.
+type fileTeeSink struct {
+	f io.WriteCloser
+}
+
+// write satisfies the teeSink interface.
+func (s fileTeeSink) write(buf []byte) error {
+	_, err := s.f.Write(buf)
+	return err
+}
+
+// Close satisfies the teeSink interface.
+func (s fileTeeSink) Close() error {
+	return s.f.Close()
+}
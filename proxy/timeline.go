@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timelineFile is a Trace Event Format file being written (see
+// WithTraceEvents), collecting the events of all sessions, for viewing in
+// Perfetto or chrome://tracing. Like the HAR file, the file is rewritten each
+// time a session is closed, so that it is always a complete document.
+type timelineFile struct {
+	mu       sync.Mutex
+	filename string
+	events   []traceEvent
+	sessions int
+}
+
+// traceEvent is an event of the Trace Event Format.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	S    string                 `json:"s,omitempty"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// traceDocument is a Trace Event Format document.
+type traceDocument struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+// Trace event phases.
+const (
+	phaseComplete = "X"
+	phaseInstant  = "i"
+	phaseMetadata = "M"
+)
+
+// add adds the events of a session to the file, and rewrites the file. Each
+// session is shown as a process, with its events on the first thread and its
+// commands on as many threads as needed for overlapping commands to not share
+// a thread.
+func (t *timelineFile) add(id string, events []traceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessions++
+	pid := t.sessions
+	t.events = append(t.events,
+		traceEvent{Name: "process_name", Ph: phaseMetadata, Pid: pid, Args: map[string]interface{}{"name": "session " + id}},
+		traceEvent{Name: "thread_name", Ph: phaseMetadata, Pid: pid, Args: map[string]interface{}{"name": "events"}},
+	)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Ts < events[j].Ts
+	})
+	// the end times of the command threads
+	var ends []int64
+	for _, e := range events {
+		e.Pid = pid
+		if e.Ph == phaseComplete {
+			tid := 0
+			for i, end := range ends {
+				if end <= e.Ts {
+					tid = i + 1
+					break
+				}
+			}
+			if tid == 0 {
+				ends = append(ends, 0)
+				tid = len(ends)
+				t.events = append(t.events, traceEvent{Name: "thread_name", Ph: phaseMetadata, Pid: pid, Tid: tid, Args: map[string]interface{}{"name": "commands"}})
+			}
+			ends[tid-1], e.Tid = e.Ts+e.Dur, tid
+		}
+		t.events = append(t.events, e)
+	}
+	buf, err := json.Marshal(traceDocument{TraceEvents: t.events, DisplayTimeUnit: "ms"})
+	if err != nil {
+		return err
+	}
+	return replaceFile(t.filename, buf)
+}
+
+// timelineSession collects the trace events of a session: a complete event
+// for each command, from the command to its response, and an instant event for
+// each event.
+type timelineSession struct {
+	mu     sync.Mutex
+	events []traceEvent
+}
+
+// command adds the complete event of a command, on its response frame.
+func (t *timelineSession) command(cmd pendingCommand, f *frame) {
+	msg := f.message()
+	args := map[string]interface{}{"id": *msg.ID}
+	if msg.SessionID != "" {
+		args["sessionId"] = msg.SessionID
+	}
+	if msg.Error != nil {
+		var cerr cdpError
+		_ = json.Unmarshal(msg.Error, &cerr)
+		args["error"] = cerr.Message
+	}
+	t.add(traceEvent{
+		Name: cmd.method,
+		Cat:  "command",
+		Ph:   phaseComplete,
+		Ts:   cmd.start.UnixMicro(),
+		Dur:  time.Since(cmd.start).Microseconds(),
+		Args: args,
+	})
+}
+
+// event adds the instant event of an event frame.
+func (t *timelineSession) event(f *frame) {
+	msg := f.message()
+	var args map[string]interface{}
+	if msg.SessionID != "" {
+		args = map[string]interface{}{"sessionId": msg.SessionID}
+	}
+	t.add(traceEvent{
+		Name: msg.Method,
+		Cat:  "event",
+		Ph:   phaseInstant,
+		Ts:   time.Now().UnixMicro(),
+		S:    "t",
+		Args: args,
+	})
+}
+
+// add adds an event.
+func (t *timelineSession) add(e traceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+// entries returns the session's events.
+func (t *timelineSession) entries() []traceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]traceEvent(nil), t.events...)
+}
@@ -0,0 +1,329 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// traceBuffer is the number of ended spans buffered for export. Spans
+	// ending while the buffer is full are dropped.
+	traceBuffer = 4096
+	// traceBatchSize is the maximum number of spans exported per request.
+	traceBatchSize = 512
+	// traceInterval is the interval ended spans are exported at.
+	traceInterval = 2 * time.Second
+	// traceTimeout is the timeout of each export request, and of the final
+	// export when the proxy is closed.
+	traceTimeout = 10 * time.Second
+)
+
+// The OTLP span kinds.
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+)
+
+// tracer exports the spans of the proxy's sessions, and of their CDP calls, to
+// an OTLP/HTTP endpoint in the OTLP JSON encoding (see WithTracing).
+type tracer struct {
+	endpoint string
+	header   http.Header
+	client   *http.Client
+	spans    chan *span
+	once     sync.Once
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// span is a span of a traced session or CDP call.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []otlpAttribute
+	err      string
+}
+
+// newTracer creates and starts a tracer exporting spans to the endpoint.
+func newTracer(endpoint string, header http.Header) *tracer {
+	t := &tracer{
+		endpoint: endpoint,
+		header:   header,
+		client:   &http.Client{Timeout: traceTimeout},
+		spans:    make(chan *span, traceBuffer),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// startSession starts the span of a session, as a child of the span of the W3C
+// traceparent header of the client's handshake request, when valid.
+func (t *tracer) startSession(traceparent string) *span {
+	sp := &span{name: "cdp.session", kind: spanKindServer, start: time.Now()}
+	if traceID, parentID, ok := parseTraceparent(traceparent); ok {
+		sp.traceID, sp.parentID = traceID, parentID
+	} else {
+		_, _ = rand.Read(sp.traceID[:])
+	}
+	_, _ = rand.Read(sp.spanID[:])
+	return sp
+}
+
+// child returns a child span of the span, started at start.
+func (sp *span) child(name string, kind int, start time.Time) *span {
+	c := &span{traceID: sp.traceID, parentID: sp.spanID, name: name, kind: kind, start: start}
+	_, _ = rand.Read(c.spanID[:])
+	return c
+}
+
+// str adds a string attribute to the span.
+func (sp *span) str(key, value string) {
+	sp.attrs = append(sp.attrs, stringAttribute(key, value))
+}
+
+// int adds an integer attribute to the span.
+func (sp *span) int(key string, value int64) {
+	v := strconv.FormatInt(value, 10)
+	sp.attrs = append(sp.attrs, otlpAttribute{Key: key, Value: otlpValue{IntValue: &v}})
+}
+
+// finish ends the span, queueing it for export. The span is dropped when the
+// export buffer is full.
+func (t *tracer) finish(sp *span) {
+	sp.end = time.Now()
+	select {
+	case t.spans <- sp:
+	default:
+	}
+}
+
+// traceCall ends the span of a CDP call, from the command's pending command
+// to the response frame.
+func (s *session) traceCall(cmd pendingCommand, f *frame) {
+	msg := f.message()
+	c := s.span.child(cmd.method, spanKindClient, cmd.start)
+	c.str("cdp.method", cmd.method)
+	c.int("cdp.id", *msg.ID)
+	if msg.SessionID != "" {
+		c.str("cdp.session_id", msg.SessionID)
+	}
+	c.int("cdp.request.size", int64(cmd.size))
+	c.int("cdp.response.size", int64(len(f.buf)))
+	if msg.Error != nil {
+		var cerr cdpError
+		_ = json.Unmarshal(msg.Error, &cerr)
+		c.err = cerr.Message
+		if c.err == "" {
+			c.err = "error response"
+		}
+	}
+	s.p.tracer.finish(c)
+}
+
+// finishSpan ends the span of the session, adding the session's message and
+// byte counts.
+func (s *session) finishSpan() {
+	st := s.stats.stats()
+	s.span.int("cdp.messages.in", st.Messages[Incoming])
+	s.span.int("cdp.messages.out", st.Messages[Outgoing])
+	s.span.int("cdp.bytes.in", st.Bytes[Incoming])
+	s.span.int("cdp.bytes.out", st.Bytes[Outgoing])
+	s.p.tracer.finish(s.span)
+}
+
+// run exports the ended spans in batches, until the tracer is closed.
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceInterval)
+	defer ticker.Stop()
+	var batch []*span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			log.Printf("could not export %d spans to %s: %v", len(batch), t.endpoint, err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case sp := <-t.spans:
+			if batch = append(batch, sp); len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.quit:
+			for {
+				select {
+				case sp := <-t.spans:
+					if batch = append(batch, sp); len(batch) >= traceBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close exports the remaining ended spans, and stops the tracer. Spans ended
+// after the tracer is closed are dropped.
+func (t *tracer) Close() error {
+	t.once.Do(func() {
+		close(t.quit)
+	})
+	select {
+	case <-t.done:
+		return nil
+	case <-time.After(traceTimeout):
+		return fmt.Errorf("timed out exporting spans to %s", t.endpoint)
+	}
+}
+
+// export exports the spans.
+func (t *tracer) export(spans []*span) error {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/chromedp/chromedp-proxy"},
+		}},
+	}}}
+	for _, sp := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(sp.traceID[:]),
+			SpanID:            hex.EncodeToString(sp.spanID[:]),
+			Name:              sp.name,
+			Kind:              sp.kind,
+			StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sp.end.UnixNano(), 10),
+			Attributes:        sp.attrs,
+		}
+		if sp.parentID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+		}
+		if sp.err != "" {
+			s.Status = &otlpStatus{Code: 2, Message: sp.err}
+		}
+		req.ResourceSpans[0].ScopeSpans[0].Spans = append(req.ResourceSpans[0].ScopeSpans[0].Spans, s)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceTimeout)
+	defer cancel()
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range t.header {
+		hreq.Header[k] = v
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	res, err := t.client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("endpoint returned %s", res.Status)
+	}
+	return nil
+}
+
+// parseTraceparent parses a W3C traceparent header (ie,
+// "00-<trace id>-<parent id>-<flags>").
+func parseTraceparent(h string) ([16]byte, [8]byte, bool) {
+	var traceID [16]byte
+	var parentID [8]byte
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return traceID, parentID, false
+	}
+	if traceID == [16]byte{} || parentID == [8]byte{} {
+		return traceID, parentID, false
+	}
+	return traceID, parentID, true
+}
+
+// serviceName is the service name of the exported spans.
+const serviceName = "chromedp-proxy"
+
+// stringAttribute returns a string attribute.
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+// The OTLP JSON encoding of an export request.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
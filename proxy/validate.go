@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// The CDP error codes for invalid client messages (see WithValidate), as used
+// by the browser.
+const (
+	parseErrorCode     = -32700
+	invalidRequestCode = -32600
+)
+
+// validateCommand checks that a client message is a well-formed CDP command: a
+// text message holding a json object with an integer id and a non-empty method.
+// Returns the CDP error code and message for invalid messages, and the
+// message's id, when valid.
+func validateCommand(f *frame) (int64, int, string) {
+	if f.typ != websocket.TextMessage {
+		return 0, invalidRequestCode, "message must be a text message"
+	}
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal(f.buf, &v); err != nil || v == nil {
+		return 0, parseErrorCode, "message must be a json object"
+	}
+	var id int64
+	if raw, ok := v["id"]; !ok || json.Unmarshal(raw, &id) != nil {
+		return 0, invalidRequestCode, "message must have integer 'id' property"
+	}
+	var method string
+	if raw, ok := v["method"]; !ok || json.Unmarshal(raw, &method) != nil || method == "" {
+		return id, invalidRequestCode, "message must have string 'method' property"
+	}
+	return id, 0, ""
+}
+
+// rejectInvalid replies to an invalid client message (see validateCommand) on
+// the client connection with a CDP error response, instead of forwarding it to
+// the remote. Returns false when the message is a valid command.
+func (s *session) rejectInvalid(f *frame) (bool, error) {
+	id, code, msg := validateCommand(f)
+	if code == 0 {
+		return false, nil
+	}
+	s.logf("rejected invalid message: %s", msg)
+	var sessionID string
+	if f.typ == websocket.TextMessage {
+		sessionID = f.message().SessionID
+	}
+	return true, s.replyError(id, sessionID, code, msg)
+}
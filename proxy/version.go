@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the version information reported by a remote's /json/version
+// endpoint.
+type Version struct {
+	// Browser is the browser's name and version (ie,
+	// HeadlessChrome/120.0.6099.109).
+	Browser string `json:"Browser"`
+	// ProtocolVersion is the version of the devtools protocol (ie, 1.3).
+	ProtocolVersion string `json:"Protocol-Version"`
+	// UserAgent is the browser's user agent.
+	UserAgent string `json:"User-Agent"`
+	// V8Version is the version of the browser's V8 engine.
+	V8Version string `json:"V8-Version"`
+	// WebKitVersion is the version of the browser's WebKit engine.
+	WebKitVersion string `json:"WebKit-Version"`
+	// WebSocketDebuggerURL is the remote's websocket url for the browser
+	// target.
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+
+	raw []byte
+}
+
+// Raw returns the version information as returned by the remote.
+func (v *Version) Raw() []byte {
+	return v.raw
+}
+
+// versionCacheTTL is how long a remote's version information is reused for
+// new sessions.
+const versionCacheTTL = 5 * time.Second
+
+// versionTimeout is the timeout for a cached version check, which is not
+// tied to the request of any one session.
+const versionTimeout = 10 * time.Second
+
+// versionCache caches the version information of the remotes, so that bursts
+// of new sessions do not each request the remote's /json/version. Concurrent
+// checks for the same remote share a single request. Errors are never cached,
+// and are only returned to the checks waiting on the failed request.
+type versionCache struct {
+	mu      sync.Mutex
+	entries map[*remote]*versionEntry
+}
+
+// versionEntry is a cached version check.
+type versionEntry struct {
+	done    chan struct{}
+	ver     *Version
+	err     error
+	expires time.Time
+}
+
+// stale returns true when the entry's check has finished, and either failed
+// or expired. Must be called with the cache's lock held.
+func (e *versionEntry) stale(now time.Time) bool {
+	select {
+	case <-e.done:
+		return e.err != nil || now.After(e.expires)
+	default:
+		return false
+	}
+}
+
+// cachedVersion returns the remote's version information (see checkVersion),
+// reusing a recent or in-flight check of the remote.
+func (p *Proxy) cachedVersion(ctx context.Context, r *remote) (*Version, error) {
+	c := &p.versions
+	c.mu.Lock()
+	e := c.entries[r]
+	if e == nil || e.stale(time.Now()) {
+		if c.entries == nil {
+			c.entries = make(map[*remote]*versionEntry)
+		}
+		e = &versionEntry{done: make(chan struct{})}
+		c.entries[r] = e
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), versionTimeout)
+			defer cancel()
+			e.ver, e.err = p.checkVersion(ctx, r)
+			e.expires = time.Now().Add(versionCacheTTL)
+			close(e.done)
+		}()
+	}
+	c.mu.Unlock()
+	select {
+	case <-e.done:
+		return e.ver, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// parseProtocolVersion parses a devtools protocol version (ie, 1.3) into its
+// numeric components.
+func parseProtocolVersion(s string) ([]int, error) {
+	var v []int
+	for _, part := range strings.Split(strings.TrimSpace(s), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid protocol version %q", s)
+		}
+		v = append(v, n)
+	}
+	return v, nil
+}
+
+// checkProtocol returns an error when the remote's protocol version does not
+// satisfy the proxy's minimum protocol version (see WithMinProtocol).
+func (p *Proxy) checkProtocol(ver *Version) error {
+	if p.minProtocol == "" {
+		return nil
+	}
+	min, err := parseProtocolVersion(p.minProtocol)
+	if err != nil {
+		return err
+	}
+	v, err := parseProtocolVersion(ver.ProtocolVersion)
+	if err != nil {
+		return fmt.Errorf("remote reported an %w, requires at least %s", err, p.minProtocol)
+	}
+	for i := 0; i < len(min) || i < len(v); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(min) {
+			b = min[i]
+		}
+		switch {
+		case a > b:
+			return nil
+		case a < b:
+			return fmt.Errorf("remote protocol version %s does not satisfy the minimum protocol version %s (%s)", ver.ProtocolVersion, p.minProtocol, ver.Browser)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// versionFile is the state of the file the default remote's version
+// information is written to (see WithVersionFile).
+type versionFile struct {
+	mu sync.Mutex
+	// last is the version information last written to the file
+	last []byte
+}
+
+// writeVersionFile writes the version information reported by the remote to
+// the proxy's version file, when the remote is the default remote and its
+// version information changed since last written (ie, after the remote was
+// relaunched).
+func (p *Proxy) writeVersionFile(r *remote, v *Version) {
+	if p.versionFile == "" || r.name != "" {
+		return
+	}
+	f := &p.verFile
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if bytes.Equal(f.last, v.raw) {
+		return
+	}
+	// the file is replaced, so that it is never read half written
+	tmp, err := os.CreateTemp(filepath.Dir(p.versionFile), "."+filepath.Base(p.versionFile)+".*")
+	if err != nil {
+		log.Printf("could not write version file %s: %v", p.versionFile, err)
+		return
+	}
+	_, err = tmp.Write(v.raw)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p.versionFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("could not write version file %s: %v", p.versionFile, err)
+		return
+	}
+	f.last = v.raw
+	log.Printf("wrote the remote's version (%s) to %s", v.Browser, p.versionFile)
+}
+
+// startVersionFile writes the default remote's version information to the
+// proxy's version file at startup. When the remote is unavailable, the file is
+// written once the version of the remote is first checked for a session.
+func (p *Proxy) startVersionFile(ctx context.Context) {
+	r := p.remoteByName("")
+	if r == nil {
+		return
+	}
+	if _, err := p.cachedVersion(ctx, r); err != nil {
+		log.Printf("could not write version file %s, writing it once the remote is reachable: %v", p.versionFile, err)
+	}
+}
+
+// versionFileWritten returns true when the proxy has no version file, or the
+// file has been written.
+func (p *Proxy) versionFileWritten() bool {
+	if p.versionFile == "" {
+		return true
+	}
+	p.verFile.mu.Lock()
+	defer p.verFile.mu.Unlock()
+	return p.verFile.last != nil
+}